@@ -0,0 +1,329 @@
+package torrential
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Scope is the access level of authenticated requests to Handler. Requests
+// with the read scope can use the GET and HEAD endpoints, including the event
+// streams, except for the webhook endpoints. Requests with the admin scope can
+// use all endpoints.
+type Scope string
+
+const (
+	ScopeNone  Scope = ""
+	ScopeRead  Scope = "read"
+	ScopeAdmin Scope = "admin"
+)
+
+// ParseScope returns the Scope with the given name.
+func ParseScope(name string) (Scope, error) {
+	switch s := Scope(name); s {
+	case ScopeRead, ScopeAdmin:
+		return s, nil
+	}
+	return ScopeNone, parseErr{errors.Errorf("unknown scope %q", name)}
+}
+
+// allows reports whether the scope grants the required scope.
+func (s Scope) allows(required Scope) bool {
+	switch required {
+	case ScopeNone:
+		return true
+	case ScopeRead:
+		return s == ScopeRead || s == ScopeAdmin
+	}
+	return s == ScopeAdmin
+}
+
+// Authenticator authenticates requests to Handler. See Auth.
+type Authenticator interface {
+	// Authenticate returns the scope of the credentials of the request, or
+	// ScopeNone if the request has no credentials that the authenticator
+	// handles. It returns an error if the credentials are invalid.
+	Authenticate(r *http.Request) (Scope, error)
+
+	// Challenge returns the WWW-Authenticate header that is sent when a
+	// request has no valid credentials.
+	Challenge() string
+}
+
+// TokenAuth authenticates requests with static bearer tokens, mapped to their
+// scope. Since browsers can't set headers on websocket requests, the token
+// can also be passed in the access_token query parameter. Query parameters
+// show up in the access logs of proxies and in browser history, so the header
+// should be used where possible.
+type TokenAuth map[string]Scope
+
+var _ Authenticator = TokenAuth{}
+
+// LoadTokenAuth reads tokens from r. Each line has the form "scope token",
+// and lines starting with # are ignored.
+func LoadTokenAuth(r io.Reader) (TokenAuth, error) {
+	tokens := TokenAuth{}
+	err := readLines(r, func(line string) error {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return parseErr{errors.New("expected a scope and a token")}
+		}
+		scope, err := ParseScope(fields[0])
+		if err != nil {
+			return err
+		}
+		tokens[fields[1]] = scope
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "could not load tokens")
+	}
+	return tokens, nil
+}
+
+func (a TokenAuth) Authenticate(r *http.Request) (Scope, error) {
+	token := r.URL.Query().Get("access_token")
+	if h := r.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+		token = strings.TrimPrefix(h, "Bearer ")
+	}
+	if token == "" {
+		return ScopeNone, nil
+	}
+	// Compare with every token, so that the time taken doesn't tell which
+	// prefix of a token is right.
+	scope := ScopeNone
+	for t, s := range a {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			scope = s
+		}
+	}
+	if scope == ScopeNone {
+		return ScopeNone, errors.New("invalid token")
+	}
+	return scope, nil
+}
+
+func (a TokenAuth) Challenge() string {
+	return `Bearer realm="torrential"`
+}
+
+const (
+	// basicAuthCacheTTL is how long the result of checking a password is
+	// reused, so that clients, which send their password with every request,
+	// don't wait for a bcrypt comparison each time.
+	basicAuthCacheTTL = time.Minute
+
+	// basicAuthCacheSize is the maximum number of cached results.
+	basicAuthCacheSize = 1024
+)
+
+// BasicAuth authenticates requests with HTTP basic auth, with the users of an
+// htpasswd file. Passwords must be hashed with bcrypt (htpasswd -B) or SHA-1
+// (htpasswd -s).
+type BasicAuth struct {
+	users map[string]string
+	scope Scope
+
+	// dummy is compared with the passwords of unknown users, so that the time
+	// taken doesn't tell which users exist.
+	dummy string
+
+	checked   map[[sha256.Size]byte]checkedPassword
+	checkedMu sync.Mutex
+}
+
+// checkedPassword is the cached result of checking the password of a user.
+type checkedPassword struct {
+	known   bool
+	valid   bool
+	expires time.Time
+}
+
+var _ Authenticator = &BasicAuth{}
+
+// NewBasicAuth returns a BasicAuth for the users of the htpasswd file read
+// from r, which are given scope.
+func NewBasicAuth(r io.Reader, scope Scope) (*BasicAuth, error) {
+	a := &BasicAuth{
+		users:   make(map[string]string),
+		scope:   scope,
+		checked: make(map[[sha256.Size]byte]checkedPassword),
+	}
+	cost := 0
+	err := readLines(r, func(line string) error {
+		i := strings.Index(line, ":")
+		if i <= 0 {
+			return parseErr{errors.New("expected user:hash")}
+		}
+		user, hash := line[:i], line[i+1:]
+		if !strings.HasPrefix(hash, "$2") && !strings.HasPrefix(hash, "{SHA}") {
+			return parseErr{errors.Errorf("unsupported password hash of user %q", user)}
+		}
+		if c, err := bcrypt.Cost([]byte(hash)); err == nil && c > cost {
+			cost = c
+		}
+		a.users[user] = hash
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "could not load htpasswd file")
+	}
+	a.dummy = "{SHA}"
+	if cost > 0 {
+		dummy, err := bcrypt.GenerateFromPassword([]byte("torrential"), cost)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not hash dummy password")
+		}
+		a.dummy = string(dummy)
+	}
+	return a, nil
+}
+
+func (a *BasicAuth) Authenticate(r *http.Request) (Scope, error) {
+	user, password, ok := r.BasicAuth()
+	if !ok {
+		return ScopeNone, nil
+	}
+	key := sha256.Sum256([]byte(user + "\x00" + password))
+	c, ok := a.cached(key)
+	if !ok {
+		c = a.check(user, password)
+		a.cache(key, c)
+	}
+	if !c.known {
+		// The user may be known to another authenticator.
+		return ScopeNone, nil
+	}
+	if !c.valid {
+		return ScopeNone, errors.New("invalid user or password")
+	}
+	return a.scope, nil
+}
+
+// check checks the password of a user. Results for unknown users and wrong
+// passwords are cached too, so that repeated requests don't tell them apart
+// either.
+func (a *BasicAuth) check(user, password string) checkedPassword {
+	hash, ok := a.users[user]
+	if !ok {
+		checkPassword(a.dummy, password)
+		return checkedPassword{}
+	}
+	return checkedPassword{known: true, valid: checkPassword(hash, password)}
+}
+
+func (a *BasicAuth) cached(key [sha256.Size]byte) (checkedPassword, bool) {
+	a.checkedMu.Lock()
+	defer a.checkedMu.Unlock()
+	c, ok := a.checked[key]
+	if !ok || time.Now().After(c.expires) {
+		return checkedPassword{}, false
+	}
+	return c, true
+}
+
+func (a *BasicAuth) cache(key [sha256.Size]byte, c checkedPassword) {
+	now := time.Now()
+	c.expires = now.Add(basicAuthCacheTTL)
+	a.checkedMu.Lock()
+	defer a.checkedMu.Unlock()
+	if len(a.checked) >= basicAuthCacheSize {
+		for k, old := range a.checked {
+			if now.After(old.expires) {
+				delete(a.checked, k)
+			}
+		}
+		if len(a.checked) >= basicAuthCacheSize {
+			a.checked = make(map[[sha256.Size]byte]checkedPassword)
+		}
+	}
+	a.checked[key] = c
+}
+
+func (a *BasicAuth) Challenge() string {
+	return `Basic realm="torrential"`
+}
+
+func checkPassword(hash, password string) bool {
+	if strings.HasPrefix(hash, "{SHA}") {
+		sum := sha1.Sum([]byte(password))
+		expected := "{SHA}" + base64.StdEncoding.EncodeToString(sum[:])
+		return subtle.ConstantTimeCompare([]byte(hash), []byte(expected)) == 1
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+// readLines calls fn with each line of r that isn't empty or a comment.
+func readLines(r io.Reader, fn func(line string) error) error {
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := fn(line); err != nil {
+			return errors.Wrapf(err, "line %d", n)
+		}
+	}
+	return scanner.Err()
+}
+
+// authHandler authenticates requests before passing them to the handler. GET
+// and HEAD requests require the read scope, and other requests and requests
+// for webhooks require the admin scope.
+type authHandler struct {
+	handler        http.Handler
+	basePath       string
+	authenticators []Authenticator
+}
+
+func (h *authHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	scope := ScopeNone
+	for _, a := range h.authenticators {
+		s, err := a.Authenticate(r)
+		if err != nil {
+			h.unauthorized(w, err)
+			return
+		}
+		if s != ScopeNone {
+			scope = s
+			break
+		}
+	}
+	if scope == ScopeNone {
+		h.unauthorized(w, errors.New("invalid or missing credentials"))
+		return
+	}
+
+	required := ScopeAdmin
+	path := strings.TrimPrefix(r.URL.Path, strings.TrimRight(h.basePath, "/"))
+	if (r.Method == "GET" || r.Method == "HEAD") && !strings.HasPrefix(path, "/webhooks") {
+		required = ScopeRead
+	}
+	if !scope.allows(required) {
+		encodeError(w, http.StatusForbidden, errors.Errorf("%s scope required", required))
+		return
+	}
+	h.handler.ServeHTTP(w, r)
+}
+
+func (h *authHandler) unauthorized(w http.ResponseWriter, err error) {
+	seen := make(map[string]bool)
+	for _, a := range h.authenticators {
+		if c := a.Challenge(); !seen[c] {
+			w.Header().Add("WWW-Authenticate", c)
+			seen[c] = true
+		}
+	}
+	encodeError(w, http.StatusUnauthorized, err)
+}
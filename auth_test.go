@@ -0,0 +1,118 @@
+package torrential_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/joelanford/torrential"
+)
+
+func TestTokenAuth(t *testing.T) {
+	auth, err := torrential.LoadTokenAuth(strings.NewReader("# tokens\nread r3ad\nadmin adm1n\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest("GET", "/torrents", nil)
+	scope, err := auth.Authenticate(r)
+	assert.NoError(t, err)
+	assert.Equal(t, torrential.ScopeNone, scope)
+
+	r.Header.Set("Authorization", "Bearer adm1n")
+	scope, err = auth.Authenticate(r)
+	assert.NoError(t, err)
+	assert.Equal(t, torrential.ScopeAdmin, scope)
+
+	r = httptest.NewRequest("GET", "/torrents/events?access_token=r3ad", nil)
+	scope, err = auth.Authenticate(r)
+	assert.NoError(t, err)
+	assert.Equal(t, torrential.ScopeRead, scope)
+
+	r.Header.Set("Authorization", "Bearer wrong")
+	_, err = auth.Authenticate(r)
+	assert.Error(t, err)
+
+	_, err = torrential.LoadTokenAuth(strings.NewReader("root t0ken\n"))
+	assert.Error(t, err)
+}
+
+func TestBasicAuth(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	htpasswd := "alice:" + string(hash) + "\nbob:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\n"
+	auth, err := torrential.NewBasicAuth(strings.NewReader(htpasswd), torrential.ScopeRead)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		user, password string
+		scope          torrential.Scope
+		valid          bool
+	}{
+		{"alice", "secret", torrential.ScopeRead, true},
+		{"alice", "wrong", torrential.ScopeNone, false},
+		{"bob", "secret", torrential.ScopeRead, true},
+		{"carol", "secret", torrential.ScopeNone, true},
+	}
+	// The second time, the cached results are used.
+	for i := 0; i < 2; i++ {
+		for _, test := range tests {
+			r := httptest.NewRequest("GET", "/torrents", nil)
+			r.SetBasicAuth(test.user, test.password)
+			scope, err := auth.Authenticate(r)
+			assert.Equal(t, test.valid, err == nil, "%s: %v", test.user, err)
+			assert.Equal(t, test.scope, scope, test.user)
+		}
+	}
+
+	_, err = torrential.NewBasicAuth(strings.NewReader("alice:$apr1$abc$def\n"), torrential.ScopeRead)
+	assert.Error(t, err)
+}
+
+func TestHandlerAuth(t *testing.T) {
+	f, err := torrential.NewFakeService()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	auth := torrential.TokenAuth{"r3ad": torrential.ScopeRead}
+	srv := httptest.NewServer(torrential.Handler("/", f, torrential.Auth(auth)))
+	defer srv.Close()
+
+	tests := []struct {
+		method, path, token string
+		status              int
+	}{
+		{"GET", "/torrents", "", http.StatusUnauthorized},
+		{"GET", "/torrents", "wrong", http.StatusUnauthorized},
+		{"GET", "/torrents", "r3ad", http.StatusOK},
+		{"GET", "/webhooks", "r3ad", http.StatusForbidden},
+		{"POST", "/torrents", "r3ad", http.StatusForbidden},
+	}
+	for _, test := range tests {
+		req, err := http.NewRequest(test.method, srv.URL+test.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.token != "" {
+			req.Header.Set("Authorization", "Bearer "+test.token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		assert.Equal(t, test.status, resp.StatusCode, "%s %s", test.method, test.path)
+		if test.status == http.StatusUnauthorized {
+			assert.Equal(t, `Bearer realm="torrential"`, resp.Header.Get("WWW-Authenticate"))
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package cache
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	archiveManifest = "manifest.json"
+	archiveVersion  = 1
+)
+
+// archiveManifestFile is the first file of an archive. It lists the torrents
+// in the archive with the checksums of their metainfo files.
+type archiveManifestFile struct {
+	Version  int            `json:"version"`
+	Created  time.Time      `json:"created"`
+	Torrents []archiveEntry `json:"torrents"`
+}
+
+type archiveEntry struct {
+	InfoHash string        `json:"infoHash"`
+	File     string        `json:"file"`
+	SHA256   string        `json:"sha256"`
+	State    *TorrentState `json:"state,omitempty"`
+}
+
+// Export writes all torrents in the cache, which must implement
+// MetainfoCache, to w as a gzipped tar archive that can be read by Import.
+// The archive holds the metainfo file of each torrent as it was stored and a
+// manifest with their checksums and, if the cache stores state, their state.
+// Entries that can't be parsed are skipped. It returns the number of exported
+// torrents.
+func Export(c Cache, w io.Writer) (int, error) {
+	mc, err := metainfoCache(c)
+	if err != nil {
+		return 0, err
+	}
+	files, _, err := mc.Metainfos()
+	if err != nil {
+		return 0, errors.Wrap(err, "could not load cache")
+	}
+
+	manifest := archiveManifestFile{
+		Version: archiveVersion,
+		Created: time.Now().UTC(),
+	}
+	for _, infoHash := range sortedKeys(files) {
+		sum := sha256.Sum256(files[infoHash])
+		entry := archiveEntry{
+			InfoHash: infoHash,
+			File:     fmt.Sprintf("torrents/%s.torrent", infoHash),
+			SHA256:   hex.EncodeToString(sum[:]),
+		}
+		if sc, ok := c.(StateCache); ok {
+			if entry.State, err = sc.State(infoHash); err != nil {
+				return 0, errors.Wrapf(err, "could not load state of torrent %s", infoHash)
+			}
+		}
+		manifest.Torrents = append(manifest.Torrents, entry)
+	}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return 0, err
+	}
+
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	if err := writeTarFile(tw, archiveManifest, data); err != nil {
+		return 0, err
+	}
+	for _, entry := range manifest.Torrents {
+		if err := writeTarFile(tw, entry.File, files[entry.InfoHash]); err != nil {
+			return 0, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return 0, err
+	}
+	if err := gw.Close(); err != nil {
+		return 0, err
+	}
+	return len(manifest.Torrents), nil
+}
+
+func writeTarFile(tw *tar.Writer, name string, data []byte) error {
+	hdr := &tar.Header{
+		Name:    name,
+		Mode:    0640,
+		Size:    int64(len(data)),
+		ModTime: time.Now(),
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
+
+// Import reads an archive written by Export and saves its torrents to the
+// cache, which must implement MetainfoCache. The whole archive is checked
+// against its manifest before anything is saved, and the cache is loaded again
+// afterwards to verify the import. It returns the number of imported
+// torrents.
+func Import(c Cache, r io.Reader) (int, error) {
+	mc, err := metainfoCache(c)
+	if err != nil {
+		return 0, err
+	}
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return 0, errors.Wrap(err, "could not read archive")
+	}
+	defer gr.Close()
+	tr := tar.NewReader(gr)
+
+	var manifest *archiveManifestFile
+	files := make(map[string][]byte)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, errors.Wrap(err, "could not read archive")
+		}
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return 0, errors.Wrapf(err, "could not read %s from archive", hdr.Name)
+		}
+		if hdr.Name == archiveManifest {
+			manifest = &archiveManifestFile{}
+			if err := json.Unmarshal(data, manifest); err != nil {
+				return 0, errors.Wrap(err, "could not parse archive manifest")
+			}
+			continue
+		}
+		files[hdr.Name] = data
+	}
+	if manifest == nil {
+		return 0, errors.New("archive has no manifest")
+	}
+	if manifest.Version != archiveVersion {
+		return 0, errors.Errorf("unsupported archive version %d", manifest.Version)
+	}
+
+	want := make(map[string][]byte, len(manifest.Torrents))
+	for _, entry := range manifest.Torrents {
+		data, ok := files[entry.File]
+		if !ok {
+			return 0, errors.Errorf("torrent %s is missing from archive", entry.InfoHash)
+		}
+		sum := sha256.Sum256(data)
+		if hex.EncodeToString(sum[:]) != entry.SHA256 {
+			return 0, errors.Errorf("torrent %s does not match its checksum", entry.InfoHash)
+		}
+		mi, err := parseMetainfo(data)
+		if err != nil {
+			return 0, errors.Wrapf(err, "could not parse torrent %s", entry.InfoHash)
+		}
+		if mi.HashInfoBytes().HexString() != entry.InfoHash {
+			return 0, errors.Errorf("torrent %s does not match its info hash", entry.InfoHash)
+		}
+		want[entry.InfoHash] = data
+	}
+
+	sc, hasState := c.(StateCache)
+	for _, entry := range manifest.Torrents {
+		if err := mc.SaveMetainfo(want[entry.InfoHash]); err != nil {
+			return 0, errors.Wrapf(err, "could not save torrent %s", entry.InfoHash)
+		}
+		if hasState && entry.State != nil {
+			if err := sc.SetState(entry.InfoHash, *entry.State); err != nil {
+				return 0, errors.Wrapf(err, "could not save state of torrent %s", entry.InfoHash)
+			}
+		}
+	}
+	if err := verify(mc, want); err != nil {
+		return 0, err
+	}
+	return len(manifest.Torrents), nil
+}
@@ -0,0 +1,279 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/boltdb/bolt"
+	"github.com/pkg/errors"
+)
+
+var (
+	boltMetainfoBucket   = []byte("metainfo")
+	boltStateBucket      = []byte("state")
+	boltQuarantineBucket = []byte("quarantine")
+	boltBlobBucket       = []byte("blobs")
+)
+
+// Bolt is a Cache that stores torrents in a BoltDB database. The metainfo and
+// state of each torrent are written in a single transaction, so the database
+// never holds one without the other.
+type Bolt struct {
+	db *bolt.DB
+}
+
+var (
+	_ StateCache    = &Bolt{}
+	_ TorrentLoader = &Bolt{}
+	_ MetainfoCache = &Bolt{}
+)
+
+// NewBolt opens the BoltDB database at path, creating it if it does not exist.
+// Only one process can have the database open at a time.
+func NewBolt(path string) (*Bolt, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(path, 0660, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, errors.Wrap(err, "could not open bolt database")
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{boltMetainfoBucket, boltStateBucket, boltQuarantineBucket, boltBlobBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, errors.Wrap(err, "could not create bolt buckets")
+	}
+	return &Bolt{db: db}, nil
+}
+
+func (c *Bolt) SaveTorrent(t *torrent.Torrent) error {
+	data, err := torrentMetainfo(t)
+	if err != nil {
+		return err
+	}
+	return c.SaveMetainfo(data)
+}
+
+func (c *Bolt) SaveMetainfo(data []byte) error {
+	mi, err := parseMetainfo(data)
+	if err != nil {
+		return err
+	}
+	info, err := mi.UnmarshalInfo()
+	if err != nil {
+		return err
+	}
+	key := []byte(mi.HashInfoBytes().HexString())
+	return c.db.Update(func(tx *bolt.Tx) error {
+		now := time.Now()
+		state := TorrentState{Added: now}
+		if data := tx.Bucket(boltStateBucket).Get(key); data != nil {
+			var old TorrentState
+			if err := json.Unmarshal(data, &old); err == nil {
+				state = old
+			}
+		}
+		state.Name, state.Updated = info.Name, now
+		stateData, err := json.Marshal(state)
+		if err != nil {
+			return err
+		}
+		if err := tx.Bucket(boltMetainfoBucket).Put(key, data); err != nil {
+			return err
+		}
+		return tx.Bucket(boltStateBucket).Put(key, stateData)
+	})
+}
+
+func (c *Bolt) LoadTorrents() ([]torrent.TorrentSpec, *LoadReport, error) {
+	var specs []torrent.TorrentSpec
+	report := &LoadReport{}
+	err := c.db.Update(func(tx *bolt.Tx) error {
+		metainfos := tx.Bucket(boltMetainfoBucket)
+		var bad [][]byte
+		err := metainfos.ForEach(func(k, v []byte) error {
+			mi, err := metainfo.Load(bytes.NewReader(v))
+			if err != nil {
+				report.quarantine(string(k), string(boltQuarantineBucket), err)
+				bad = append(bad, k)
+				return nil
+			}
+			specs = append(specs, *torrent.TorrentSpecFromMetaInfo(mi))
+			report.Loaded++
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		// Keys can't be deleted while iterating, so the bad entries are
+		// moved to the quarantine bucket afterwards.
+		quarantine := tx.Bucket(boltQuarantineBucket)
+		for _, k := range bad {
+			qk := quarantineName(string(k), func(name string) bool {
+				return quarantine.Get([]byte(name)) != nil
+			})
+			if err := quarantine.Put([]byte(qk), metainfos.Get(k)); err != nil {
+				return err
+			}
+			if err := metainfos.Delete(k); err != nil {
+				return err
+			}
+			if err := tx.Bucket(boltStateBucket).Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return specs, report, nil
+}
+
+func (c *Bolt) Metainfos() (map[string][]byte, *LoadReport, error) {
+	files := make(map[string][]byte)
+	report := &LoadReport{}
+	err := c.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltMetainfoBucket).ForEach(func(k, v []byte) error {
+			// Values are only valid for the life of the transaction.
+			report.addMetainfo(files, string(k), append([]byte(nil), v...))
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return files, report, nil
+}
+
+func (c *Bolt) LoadTorrent(infoHash string) (*torrent.TorrentSpec, error) {
+	var data []byte
+	err := c.db.View(func(tx *bolt.Tx) error {
+		// Values are only valid for the life of the transaction.
+		data = append([]byte(nil), tx.Bucket(boltMetainfoBucket).Get([]byte(infoHash))...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, notFound(infoHash)
+	}
+	mi, err := parseMetainfo(data)
+	if err != nil {
+		return nil, err
+	}
+	return torrent.TorrentSpecFromMetaInfo(mi), nil
+}
+
+func (c *Bolt) DeleteTorrent(t *torrent.Torrent) error {
+	key := []byte(t.InfoHash().HexString())
+	return c.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(boltMetainfoBucket).Delete(key); err != nil {
+			return err
+		}
+		return tx.Bucket(boltStateBucket).Delete(key)
+	})
+}
+
+// State returns the stored state of the torrent with the given info hash.
+func (c *Bolt) State(infoHash string) (*TorrentState, error) {
+	var state *TorrentState
+	err := c.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(boltStateBucket).Get([]byte(infoHash))
+		if data == nil {
+			return nil
+		}
+		state = &TorrentState{}
+		return json.Unmarshal(data, state)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if state == nil {
+		return nil, notFound(infoHash)
+	}
+	return state, nil
+}
+
+// SetState replaces the stored state of the torrent with the given info hash,
+// which must already be in the cache.
+func (c *Bolt) SetState(infoHash string, state TorrentState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	key := []byte(infoHash)
+	return c.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(boltMetainfoBucket).Get(key) == nil {
+			return notFound(infoHash)
+		}
+		return tx.Bucket(boltStateBucket).Put(key, data)
+	})
+}
+
+func (c *Bolt) PutBlob(name string, data []byte) error {
+	if err := checkBlobName(name); err != nil {
+		return err
+	}
+	return c.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBlobBucket).Put([]byte(name), data)
+	})
+}
+
+func (c *Bolt) GetBlob(name string) ([]byte, error) {
+	var data []byte
+	err := c.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(boltBlobBucket).Get([]byte(name))
+		if v == nil {
+			return ErrNotFound
+		}
+		// Values are only valid for the life of the transaction.
+		data = append([]byte(nil), v...)
+		return nil
+	})
+	return data, err
+}
+
+func (c *Bolt) DeleteBlob(name string) error {
+	return c.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBlobBucket).Delete([]byte(name))
+	})
+}
+
+func (c *Bolt) ListBlobs(suffix string) ([]string, error) {
+	var names []string
+	err := c.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBlobBucket).ForEach(func(k, _ []byte) error {
+			if strings.HasSuffix(string(k), suffix) {
+				names = append(names, string(k))
+			}
+			return nil
+		})
+	})
+	return names, err
+}
+
+// Completion returns a PieceCompletion that is stored in the same database.
+func (c *Bolt) Completion() (*BoltCompletion, error) {
+	return newBoltCompletion(c.db)
+}
+
+// Close closes the database.
+func (c *Bolt) Close() error {
+	return c.db.Close()
+}
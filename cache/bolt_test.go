@@ -0,0 +1,87 @@
+package cache_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/joelanford/torrential/cache"
+)
+
+func TestBolt(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "cache.db")
+
+	c, err := cache.NewBolt(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, c.SaveMetainfo(loadSample(t)))
+	state, err := c.State(sampleInfoHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.Labels = []string{"movies"}
+	assert.NoError(t, c.SetState(sampleInfoHash, *state))
+	assert.NoError(t, c.PutBlob("a.pending", []byte("placeholder")))
+
+	// The completion shares the database, and closing it leaves the cache
+	// open.
+	completion, err := c.Completion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk := metainfo.PieceKey{InfoHash: metainfo.NewHashFromHex(sampleInfoHash), Index: 0}
+	assert.NoError(t, completion.Set(pk, true))
+	assert.NoError(t, completion.Close())
+	_, err = c.State(sampleInfoHash)
+	assert.NoError(t, err)
+	assert.NoError(t, c.Close())
+
+	// Everything is still there after reopening the database.
+	c, err = cache.NewBolt(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	specs, report, err := c.LoadTorrents()
+	if assert.NoError(t, err) && assert.Len(t, specs, 1) {
+		assert.Equal(t, sampleInfoHash, specs[0].InfoHash.HexString())
+		assert.Equal(t, 1, report.Loaded)
+	}
+	state, err = c.State(sampleInfoHash)
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"movies"}, state.Labels)
+	}
+	spec, err := c.LoadTorrent(sampleInfoHash)
+	if assert.NoError(t, err) {
+		assert.Equal(t, sampleInfoHash, spec.InfoHash.HexString())
+	}
+	_, err = c.LoadTorrent("0000000000000000000000000000000000000000")
+	assert.Equal(t, cache.ErrNotFound, errors.Cause(err))
+
+	names, err := c.ListBlobs(".pending")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a.pending"}, names)
+	data, err := c.GetBlob("a.pending")
+	assert.NoError(t, err)
+	assert.Equal(t, "placeholder", string(data))
+	assert.NoError(t, c.DeleteBlob("a.pending"))
+	_, err = c.GetBlob("a.pending")
+	assert.Equal(t, cache.ErrNotFound, errors.Cause(err))
+
+	completion, err = c.Completion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cn, err := completion.Get(pk)
+	if assert.NoError(t, err) {
+		assert.True(t, cn.Ok)
+		assert.True(t, cn.Complete)
+	}
+}
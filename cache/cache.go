@@ -1,11 +1,182 @@
 package cache
 
 import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+
 	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/pkg/errors"
 )
 
 type Cache interface {
 	SaveTorrent(*torrent.Torrent) error
-	LoadTorrents() ([]torrent.TorrentSpec, error)
+	LoadTorrents() ([]torrent.TorrentSpec, *LoadReport, error)
 	DeleteTorrent(*torrent.Torrent) error
 }
+
+// MetainfoCache is implemented by caches that Migrate, Export and Import can
+// copy torrents from and to without running them. The metainfo files are
+// copied as they were stored, so that no fields are lost.
+type MetainfoCache interface {
+	// Metainfos returns the stored metainfo file of each torrent by info
+	// hash. Unlike LoadTorrents, it never quarantines entries: entries that
+	// can't be parsed are left in place and listed in the report.
+	Metainfos() (map[string][]byte, *LoadReport, error)
+
+	// SaveMetainfo saves the metainfo file of a torrent as it is.
+	SaveMetainfo(data []byte) error
+}
+
+// TorrentLoader is implemented by caches that can load a single torrent, which
+// Leases need to take torrents over. LoadTorrent returns an error with
+// ErrNotFound as its cause if the torrent is not in the cache. Unlike
+// LoadTorrents, it never quarantines the entry.
+type TorrentLoader interface {
+	LoadTorrent(infoHash string) (*torrent.TorrentSpec, error)
+}
+
+// StateCache is implemented by caches that store state alongside the metainfo
+// of each torrent. The state is carried over by Migrate, Export and Import.
+// State and SetState return an error with ErrNotFound as its cause for
+// torrents that are not in the cache. Saving the metainfo of a torrent that
+// is already in the cache keeps its state.
+type StateCache interface {
+	State(infoHash string) (*TorrentState, error)
+	SetState(infoHash string, state TorrentState) error
+}
+
+// BlobStore stores opaque blobs by name. It is implemented by the Directory,
+// Minio and Bolt caches, so that wrappers like Encrypted can store data that
+// is not a torrent file in them. The Directory and Minio caches store blobs
+// next to their torrent files, so blob names must not end in .torrent, and
+// PutBlob rejects names that do.
+type BlobStore interface {
+	PutBlob(name string, data []byte) error
+
+	// GetBlob returns the data of the named blob, or ErrNotFound if there is
+	// no such blob.
+	GetBlob(name string) ([]byte, error)
+	DeleteBlob(name string) error
+
+	// ListBlobs returns the names of the blobs that end in suffix.
+	ListBlobs(suffix string) ([]string, error)
+}
+
+// ErrNotFound is returned by BlobStore.GetBlob for blobs that don't exist, and
+// is the cause of the errors of StateCache for torrents that aren't cached.
+var ErrNotFound = errors.New("not found")
+
+// TorrentState is the state that is stored alongside each torrent's metainfo.
+type TorrentState struct {
+	Name    string    `json:"name"`
+	Added   time.Time `json:"added"`
+	Updated time.Time `json:"updated"`
+
+	// Labels are the labels of the torrent, so that they survive a restart.
+	Labels []string `json:"labels,omitempty"`
+
+	// Paused and Priority are the download state of the torrent.
+	Paused   bool   `json:"paused,omitempty"`
+	Priority string `json:"priority,omitempty"`
+}
+
+// notFound returns an error for a torrent that is not in the cache.
+func notFound(infoHash string) error {
+	return errors.Wrapf(ErrNotFound, "torrent %s", infoHash)
+}
+
+// LoadReport describes the outcome of loading a cache. Entries that can't be
+// parsed are moved out of the way, so that they are not loaded again, and are
+// listed in Quarantined.
+type LoadReport struct {
+	Loaded      int                `json:"loaded"`
+	Quarantined []QuarantinedEntry `json:"quarantined,omitempty"`
+}
+
+// QuarantinedEntry is a cache entry that could not be loaded.
+type QuarantinedEntry struct {
+	// Name is the name of the entry in the cache, e.g. its file name.
+	Name string `json:"name"`
+
+	// Location is where the entry was moved to. It is empty if the entry
+	// could not be moved, or was left in place.
+	Location string `json:"location,omitempty"`
+
+	Error string `json:"error"`
+}
+
+// parseError is an error parsing the metainfo of a cache entry. Only entries
+// that can't be parsed are quarantined. Errors reading entries are returned by
+// LoadTorrents instead, since they are often temporary.
+type parseError struct {
+	error
+}
+
+// parseMetainfo parses the metainfo of a cache entry, returning a parseError
+// if it is invalid.
+func parseMetainfo(data []byte) (*metainfo.MetaInfo, error) {
+	mi, err := metainfo.Load(bytes.NewReader(data))
+	if err != nil {
+		return nil, parseError{err}
+	}
+	return mi, nil
+}
+
+// checkBlobName returns an error if the blob name could be mistaken for a
+// torrent file of the cache.
+func checkBlobName(name string) error {
+	if strings.HasSuffix(name, ".torrent") {
+		return errors.Errorf("invalid blob name %q: blob names must not end in .torrent", name)
+	}
+	return nil
+}
+
+// quarantineName returns the name that an entry is quarantined under. If an
+// entry with the same name was quarantined before, a number is appended to
+// the name, so that the earlier entry is kept.
+func quarantineName(name string, exists func(name string) bool) string {
+	unique := name
+	for i := 1; exists(unique); i++ {
+		unique = fmt.Sprintf("%s.%d", name, i)
+	}
+	return unique
+}
+
+// torrentMetainfo waits for the info of a torrent and returns its metainfo
+// file.
+func torrentMetainfo(t *torrent.Torrent) ([]byte, error) {
+	select {
+	case <-t.GotInfo():
+		mi := t.Metainfo()
+		var buf bytes.Buffer
+		if err := mi.Write(&buf); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case <-t.Closed():
+		return nil, errors.New("torrent closed before info ready")
+	}
+}
+
+// addMetainfo adds a stored metainfo file to files by info hash, or lists it
+// in the report, without moving it, if it can't be parsed.
+func (r *LoadReport) addMetainfo(files map[string][]byte, name string, data []byte) {
+	mi, err := parseMetainfo(data)
+	if err != nil {
+		r.quarantine(name, "", err)
+		return
+	}
+	files[mi.HashInfoBytes().HexString()] = data
+	r.Loaded++
+}
+
+func (r *LoadReport) quarantine(name, location string, err error) {
+	r.Quarantined = append(r.Quarantined, QuarantinedEntry{
+		Name:     name,
+		Location: location,
+		Error:    err.Error(),
+	})
+}
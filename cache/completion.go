@@ -0,0 +1,289 @@
+package cache
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/anacrolix/torrent/storage"
+	"github.com/boltdb/bolt"
+	"github.com/pkg/errors"
+)
+
+// PieceCompletion is a storage.PieceCompletion that persists piece completion
+// across restarts, so that torrents don't have to be hashed again when they
+// are loaded from the cache.
+type PieceCompletion interface {
+	storage.PieceCompletion
+
+	// Check compares the stored completion of a torrent with its files in
+	// dataDir. If files are missing, shorter than the completed pieces or
+	// modified after the completion was stored or recorded, the completion of
+	// the pieces in those files is discarded so that the client verifies
+	// them, and Check returns false.
+	Check(infoHash metainfo.Hash, info *metainfo.Info, dataDir string) (bool, error)
+
+	// Record stores the modification times of the files of a torrent in
+	// dataDir, so that files that were written after the last piece was
+	// completed, e.g. by a download that was stopped, aren't considered
+	// modified by Check. It should be called when the torrent data is no
+	// longer written.
+	Record(infoHash metainfo.Hash, info *metainfo.Info, dataDir string) error
+
+	// Forget discards the stored completion of a torrent.
+	Forget(infoHash metainfo.Hash) error
+}
+
+var (
+	boltCompletionBucket = []byte("completion")
+	boltUpdatedKey       = []byte("updated")
+	boltFilesKey         = []byte("files")
+)
+
+// completionSlack allows for file systems with coarse modification times.
+const completionSlack = 2 * time.Second
+
+// BoltCompletion is a PieceCompletion that is stored in a BoltDB database.
+// Each torrent has a bucket with a key per piece, the time of the last change
+// and the recorded modification times of its files.
+type BoltCompletion struct {
+	db     *bolt.DB
+	closes bool
+}
+
+var _ PieceCompletion = &BoltCompletion{}
+
+// NewBoltCompletion opens the BoltDB database at path, creating it if it does
+// not exist.
+func NewBoltCompletion(path string) (*BoltCompletion, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(path, 0660, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, errors.Wrap(err, "could not open bolt database")
+	}
+	c, err := newBoltCompletion(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	c.closes = true
+	return c, nil
+}
+
+func newBoltCompletion(db *bolt.DB) (*BoltCompletion, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltCompletionBucket)
+		return err
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "could not create bolt buckets")
+	}
+	return &BoltCompletion{db: db}, nil
+}
+
+func pieceKey(index int) []byte {
+	k := make([]byte, 4)
+	binary.BigEndian.PutUint32(k, uint32(index))
+	return k
+}
+
+func (c *BoltCompletion) Get(pk metainfo.PieceKey) (storage.Completion, error) {
+	var cn storage.Completion
+	err := c.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltCompletionBucket).Bucket(pk.InfoHash.Bytes())
+		if b == nil {
+			return nil
+		}
+		if v := b.Get(pieceKey(pk.Index)); v != nil {
+			cn.Ok = true
+			cn.Complete = v[0] != 0
+		}
+		return nil
+	})
+	return cn, err
+}
+
+func (c *BoltCompletion) Set(pk metainfo.PieceKey, complete bool) error {
+	return c.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.Bucket(boltCompletionBucket).CreateBucketIfNotExists(pk.InfoHash.Bytes())
+		if err != nil {
+			return err
+		}
+		v := []byte{0}
+		if complete {
+			v[0] = 1
+		}
+		if err := b.Put(pieceKey(pk.Index), v); err != nil {
+			return err
+		}
+		updated, err := time.Now().MarshalBinary()
+		if err != nil {
+			return err
+		}
+		return b.Put(boltUpdatedKey, updated)
+	})
+}
+
+func (c *BoltCompletion) Check(infoHash metainfo.Hash, info *metainfo.Info, dataDir string) (bool, error) {
+	var (
+		complete []bool
+		updated  time.Time
+		recorded []time.Time
+	)
+	err := c.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltCompletionBucket).Bucket(infoHash.Bytes())
+		if b == nil {
+			return nil
+		}
+		if v := b.Get(boltUpdatedKey); v == nil || updated.UnmarshalBinary(v) != nil {
+			updated = time.Time{}
+		}
+		v := b.Get(boltFilesKey)
+		for i := 0; i+8 <= len(v); i += 8 {
+			recorded = append(recorded, time.Unix(0, int64(binary.BigEndian.Uint64(v[i:]))))
+		}
+		complete = make([]bool, len(info.Pieces)/20)
+		return b.ForEach(func(k, v []byte) error {
+			if len(k) != 4 {
+				return nil
+			}
+			if i := int(binary.BigEndian.Uint32(k)); i < len(complete) {
+				complete[i] = v[0] != 0
+			}
+			return nil
+		})
+	})
+	if err != nil || complete == nil {
+		return true, err
+	}
+	if updated.IsZero() {
+		// Without the time of the last change, none of the completion can
+		// be trusted.
+		return false, c.Forget(infoHash)
+	}
+	stale := stalePieces(info, dataDir, complete, updated, recorded)
+	if len(stale) == 0 {
+		return true, nil
+	}
+	return false, c.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltCompletionBucket).Bucket(infoHash.Bytes())
+		if b == nil {
+			return nil
+		}
+		for _, i := range stale {
+			if err := b.Delete(pieceKey(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (c *BoltCompletion) Record(infoHash metainfo.Hash, info *metainfo.Info, dataDir string) error {
+	files := torrentFiles(info, dataDir)
+	v := make([]byte, 8*len(files))
+	for i, f := range files {
+		if fi, err := os.Stat(f.path); err == nil {
+			binary.BigEndian.PutUint64(v[8*i:], uint64(fi.ModTime().UnixNano()))
+		}
+	}
+	return c.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltCompletionBucket).Bucket(infoHash.Bytes())
+		if b == nil {
+			// Nothing is stored for torrents without completion.
+			return nil
+		}
+		return b.Put(boltFilesKey, v)
+	})
+}
+
+type torrentFile struct {
+	path   string
+	offset int64
+	length int64
+}
+
+func torrentFiles(info *metainfo.Info, dataDir string) []torrentFile {
+	if len(info.Files) == 0 {
+		return []torrentFile{{filepath.Join(dataDir, info.Name), 0, info.Length}}
+	}
+	var (
+		files  []torrentFile
+		offset int64
+	)
+	for _, fi := range info.Files {
+		path := filepath.Join(append([]string{dataDir, info.Name}, fi.Path...)...)
+		files = append(files, torrentFile{path, offset, fi.Length})
+		offset += fi.Length
+	}
+	return files
+}
+
+// stalePieces returns the complete pieces that overlap files that are missing,
+// too short to hold them, or modified after both the time the completion was
+// updated and the time that was recorded for the file.
+func stalePieces(info *metainfo.Info, dataDir string, complete []bool, updated time.Time, recorded []time.Time) []int {
+	stale := make([]bool, len(complete))
+	for j, f := range torrentFiles(info, dataDir) {
+		if f.length == 0 {
+			continue
+		}
+		// The file must be long enough to hold the last complete piece that
+		// overlaps it.
+		var need int64
+		first := int(f.offset / info.PieceLength)
+		last := int((f.offset + f.length - 1) / info.PieceLength)
+		for i := first; i <= last && i < len(complete); i++ {
+			if complete[i] {
+				end := int64(i+1)*info.PieceLength - f.offset
+				if end > f.length {
+					end = f.length
+				}
+				need = end
+			}
+		}
+		if need == 0 {
+			continue
+		}
+		changed := updated
+		if j < len(recorded) && recorded[j].After(changed) {
+			changed = recorded[j]
+		}
+		fi, err := os.Stat(f.path)
+		if err == nil && fi.Size() >= need && !fi.ModTime().After(changed.Add(completionSlack)) {
+			continue
+		}
+		for i := first; i <= last && i < len(complete); i++ {
+			stale[i] = stale[i] || complete[i]
+		}
+	}
+	var pieces []int
+	for i, s := range stale {
+		if s {
+			pieces = append(pieces, i)
+		}
+	}
+	return pieces
+}
+
+func (c *BoltCompletion) Forget(infoHash metainfo.Hash) error {
+	return c.db.Update(func(tx *bolt.Tx) error {
+		err := tx.Bucket(boltCompletionBucket).DeleteBucket(infoHash.Bytes())
+		if err == bolt.ErrBucketNotFound {
+			return nil
+		}
+		return err
+	})
+}
+
+// Close closes the database, unless it is shared with a Bolt cache.
+func (c *BoltCompletion) Close() error {
+	if !c.closes {
+		return nil
+	}
+	return c.db.Close()
+}
@@ -0,0 +1,100 @@
+package cache_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/boltdb/bolt"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/joelanford/torrential/cache"
+)
+
+func TestBoltCompletion(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	dbPath := filepath.Join(dir, "completion.db")
+	dataDir := filepath.Join(dir, "data")
+
+	// Three pieces of 4 bytes over two files of 6 bytes, so the second piece
+	// overlaps both files.
+	info := &metainfo.Info{
+		Name:        "sample",
+		PieceLength: 4,
+		Pieces:      make([]byte, 3*20),
+		Files: []metainfo.FileInfo{
+			{Path: []string{"a"}, Length: 6},
+			{Path: []string{"b"}, Length: 6},
+		},
+	}
+	var infoHash metainfo.Hash
+	copy(infoHash[:], "completion test hash")
+	assert.NoError(t, os.MkdirAll(filepath.Join(dataDir, "sample"), 0750))
+	for _, name := range []string{"a", "b"} {
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(dataDir, "sample", name), make([]byte, 6), 0660))
+	}
+
+	c, err := cache.NewBoltCompletion(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setAll := func() {
+		for i := 0; i < 3; i++ {
+			assert.NoError(t, c.Set(metainfo.PieceKey{InfoHash: infoHash, Index: i}, true))
+		}
+	}
+	completed := func() (pieces []bool) {
+		for i := 0; i < 3; i++ {
+			cn, err := c.Get(metainfo.PieceKey{InfoHash: infoHash, Index: i})
+			assert.NoError(t, err)
+			pieces = append(pieces, cn.Ok && cn.Complete)
+		}
+		return pieces
+	}
+	setAll()
+	fresh, err := c.Check(infoHash, info, dataDir)
+	assert.NoError(t, err)
+	assert.True(t, fresh)
+
+	// Data written after the last completed piece, e.g. by a stopped
+	// download, doesn't make the completion stale once it was recorded.
+	later := time.Now().Add(time.Hour)
+	assert.NoError(t, os.Chtimes(filepath.Join(dataDir, "sample", "a"), later, later))
+	assert.NoError(t, c.Record(infoHash, info, dataDir))
+	fresh, err = c.Check(infoHash, info, dataDir)
+	assert.NoError(t, err)
+	assert.True(t, fresh)
+
+	// Only the pieces of a file that changed afterwards are discarded.
+	later = later.Add(time.Hour)
+	assert.NoError(t, os.Chtimes(filepath.Join(dataDir, "sample", "b"), later, later))
+	fresh, err = c.Check(infoHash, info, dataDir)
+	assert.NoError(t, err)
+	assert.False(t, fresh)
+	assert.Equal(t, []bool{true, false, false}, completed())
+
+	// Completion without the time of the last change is discarded.
+	setAll()
+	assert.NoError(t, c.Close())
+	db, err := bolt.Open(dbPath, 0660, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("completion")).Bucket(infoHash.Bytes()).Delete([]byte("updated"))
+	}))
+	assert.NoError(t, db.Close())
+	c, err = cache.NewBoltCompletion(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	fresh, err = c.Check(infoHash, info, dataDir)
+	assert.NoError(t, err)
+	assert.False(t, fresh)
+	assert.Equal(t, []bool{false, false, false}, completed())
+}
@@ -12,10 +12,19 @@ import (
 	"github.com/pkg/errors"
 )
 
+// quarantineDir is the subdirectory (or object prefix) that entries that could
+// not be loaded are moved to.
+const quarantineDir = "quarantine"
+
 type Directory struct {
 	Directory string
 }
 
+var (
+	_ TorrentLoader = &Directory{}
+	_ MetainfoCache = &Directory{}
+)
+
 func NewDirectory(dir string) *Directory {
 	return &Directory{
 		Directory: dir,
@@ -23,50 +32,185 @@ func NewDirectory(dir string) *Directory {
 }
 
 func (c *Directory) SaveTorrent(t *torrent.Torrent) error {
-	select {
-	case <-t.GotInfo():
-		filename := filepath.Join(c.Directory, fmt.Sprintf("%s.torrent", t.InfoHash().HexString()))
-		f, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0660)
-		if err != nil {
-			return err
-		}
-		defer f.Close()
-		return t.Metainfo().Write(f)
-	case <-t.Closed():
-		return errors.New("torrent closed before info ready")
+	data, err := torrentMetainfo(t)
+	if err != nil {
+		return err
+	}
+	return c.SaveMetainfo(data)
+}
+
+func (c *Directory) SaveMetainfo(data []byte) error {
+	mi, err := parseMetainfo(data)
+	if err != nil {
+		return err
 	}
+	return c.PutBlob(fmt.Sprintf("%s.torrent", mi.HashInfoBytes().HexString()), data)
 }
 
-func (c *Directory) LoadTorrents() ([]torrent.TorrentSpec, error) {
+func (c *Directory) LoadTorrents() ([]torrent.TorrentSpec, *LoadReport, error) {
 	err := os.MkdirAll(c.Directory, 0750)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	entries, err := ioutil.ReadDir(c.Directory)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	var specs []torrent.TorrentSpec
+	report := &LoadReport{}
 	for _, e := range entries {
 		if strings.HasSuffix(e.Name(), ".torrent") && !e.IsDir() {
-			f, err := os.Open(filepath.Join(c.Directory, e.Name()))
-			if err != nil {
-				return nil, err
+			mi, err := c.loadFile(e.Name())
+			if os.IsNotExist(err) {
+				// The file was removed since the directory was read.
+				continue
+			}
+			if _, ok := err.(parseError); ok {
+				report.quarantine(e.Name(), c.quarantine(e.Name()), err)
+				continue
 			}
-			defer f.Close()
-
-			mi, err := metainfo.Load(f)
 			if err != nil {
-				return nil, err
+				return nil, nil, errors.Wrapf(err, "could not read %s", e.Name())
 			}
 			spec := torrent.TorrentSpecFromMetaInfo(mi)
 			specs = append(specs, *spec)
+			report.Loaded++
 		}
 	}
-	return specs, nil
+	return specs, report, nil
+}
+
+func (c *Directory) Metainfos() (map[string][]byte, *LoadReport, error) {
+	entries, err := ioutil.ReadDir(c.Directory)
+	if os.IsNotExist(err) {
+		return map[string][]byte{}, &LoadReport{}, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	files := make(map[string][]byte)
+	report := &LoadReport{}
+	for _, e := range entries {
+		if !strings.HasSuffix(e.Name(), ".torrent") || e.IsDir() {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(c.Directory, e.Name()))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "could not read %s", e.Name())
+		}
+		report.addMetainfo(files, e.Name(), data)
+	}
+	return files, report, nil
+}
+
+func (c *Directory) LoadTorrent(infoHash string) (*torrent.TorrentSpec, error) {
+	mi, err := c.loadFile(fmt.Sprintf("%s.torrent", infoHash))
+	if os.IsNotExist(err) {
+		return nil, notFound(infoHash)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return torrent.TorrentSpecFromMetaInfo(mi), nil
+}
+
+// loadFile reads and parses a torrent file. Errors parsing the file are
+// returned as a parseError.
+func (c *Directory) loadFile(name string) (*metainfo.MetaInfo, error) {
+	data, err := ioutil.ReadFile(filepath.Join(c.Directory, name))
+	if err != nil {
+		return nil, err
+	}
+	return parseMetainfo(data)
 }
+
+// quarantine moves the file to the quarantine subdirectory, returning its new
+// path, or an empty string if it could not be moved.
+func (c *Directory) quarantine(name string) string {
+	dir := filepath.Join(c.Directory, quarantineDir)
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return ""
+	}
+	path := filepath.Join(dir, quarantineName(name, func(name string) bool {
+		_, err := os.Lstat(filepath.Join(dir, name))
+		return err == nil
+	}))
+	if err := os.Rename(filepath.Join(c.Directory, name), path); err != nil {
+		return ""
+	}
+	return path
+}
+
 func (c *Directory) DeleteTorrent(t *torrent.Torrent) error {
 	filename := filepath.Join(c.Directory, fmt.Sprintf("%s.torrent", t.InfoHash().HexString()))
 	return os.Remove(filename)
 }
+
+// PutBlob writes the blob to a temporary file first, and renames it once it is
+// written, so that neither a crash nor another process reading the directory
+// ever sees a partially written blob.
+func (c *Directory) PutBlob(name string, data []byte) error {
+	if err := checkBlobName(name); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(c.Directory, 0750); err != nil {
+		return err
+	}
+	// Other processes sharing the directory may write the same blob, so each
+	// write has its own temporary file.
+	f, err := ioutil.TempFile(c.Directory, name+".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0660)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), filepath.Join(c.Directory, name))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+func (c *Directory) GetBlob(name string) ([]byte, error) {
+	data, err := ioutil.ReadFile(filepath.Join(c.Directory, name))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return data, err
+}
+
+func (c *Directory) DeleteBlob(name string) error {
+	err := os.Remove(filepath.Join(c.Directory, name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (c *Directory) ListBlobs(suffix string) ([]string, error) {
+	entries, err := ioutil.ReadDir(c.Directory)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if strings.HasSuffix(e.Name(), suffix) && !e.IsDir() {
+			names = append(names, e.Name())
+		}
+	}
+	return names, nil
+}
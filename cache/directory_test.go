@@ -0,0 +1,83 @@
+package cache_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/joelanford/torrential/cache"
+)
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "torrential-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func copyFile(t *testing.T, src, dst string) {
+	data, err := ioutil.ReadFile(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(dst, data, 0660); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDirectoryQuarantine(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	copyFile(t, "../testdata/sample.torrent", filepath.Join(dir, "d0d14c926e6e99761a2fdcff27b403d96376eff6.torrent"))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "bad.torrent"), []byte("not bencode"), 0660))
+
+	c := cache.NewDirectory(dir)
+	specs, report, err := c.LoadTorrents()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assert.Len(t, specs, 1) {
+		assert.Equal(t, "d0d14c926e6e99761a2fdcff27b403d96376eff6", specs[0].InfoHash.HexString())
+	}
+	assert.Equal(t, 1, report.Loaded)
+	if assert.Len(t, report.Quarantined, 1) {
+		q := report.Quarantined[0]
+		assert.Equal(t, "bad.torrent", q.Name)
+		assert.Equal(t, filepath.Join(dir, "quarantine", "bad.torrent"), q.Location)
+		assert.NotEmpty(t, q.Error)
+	}
+	_, err = os.Stat(filepath.Join(dir, "bad.torrent"))
+	assert.True(t, os.IsNotExist(err))
+
+	// A second bad entry with the same name doesn't replace the first.
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "bad.torrent"), []byte("still not bencode"), 0660))
+	specs, report, err = c.LoadTorrents()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, specs, 1)
+	if assert.Len(t, report.Quarantined, 1) {
+		assert.Equal(t, filepath.Join(dir, "quarantine", "bad.torrent.1"), report.Quarantined[0].Location)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "quarantine", "bad.torrent"))
+	assert.NoError(t, err)
+	assert.Equal(t, "not bencode", string(data))
+}
+
+func TestDirectoryBlobNames(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	c := cache.NewDirectory(dir)
+
+	// Blobs are stored next to the torrent files, so they can't look like
+	// one.
+	assert.Error(t, c.PutBlob("d0d14c926e6e99761a2fdcff27b403d96376eff6.torrent", []byte("not a torrent")))
+	assert.NoError(t, c.PutBlob("a.pending", []byte("placeholder")))
+	names, err := c.ListBlobs(".pending")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a.pending"}, names)
+}
@@ -0,0 +1,307 @@
+package cache
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/pkg/errors"
+)
+
+const (
+	sealedSuffix = ".sealed"
+	sealedMagic  = "TSC1"
+)
+
+// Encrypted is a Cache that seals the metainfo and state of each torrent with
+// AES-GCM before storing it in a BlobStore, such as a Directory or Minio
+// cache. Blob names are keyed hashes of the info hashes, so the store does not
+// reveal which torrents are cached.
+type Encrypted struct {
+	store BlobStore
+	keys  *Keyring
+
+	// PollInterval is how often Watch lists the store. It defaults to 30
+	// seconds.
+	PollInterval time.Duration
+}
+
+var (
+	_ StateCache    = &Encrypted{}
+	_ TorrentLoader = &Encrypted{}
+	_ MetainfoCache = &Encrypted{}
+)
+
+// sealedEntry is the plaintext of a sealed blob.
+type sealedEntry struct {
+	InfoHash string       `json:"infoHash"`
+	Metainfo []byte       `json:"metainfo"`
+	State    TorrentState `json:"state"`
+}
+
+func NewEncrypted(store BlobStore, keys *Keyring) *Encrypted {
+	return &Encrypted{
+		store: store,
+		keys:  keys,
+	}
+}
+
+func (c *Encrypted) SaveTorrent(t *torrent.Torrent) error {
+	data, err := torrentMetainfo(t)
+	if err != nil {
+		return err
+	}
+	return c.SaveMetainfo(data)
+}
+
+func (c *Encrypted) SaveMetainfo(data []byte) error {
+	mi, err := parseMetainfo(data)
+	if err != nil {
+		return err
+	}
+	info, err := mi.UnmarshalInfo()
+	if err != nil {
+		return err
+	}
+	infoHash := mi.HashInfoBytes().HexString()
+
+	now := time.Now()
+	entry := sealedEntry{
+		InfoHash: infoHash,
+		Metainfo: data,
+		State:    TorrentState{Name: info.Name, Added: now, Updated: now},
+	}
+	old, oldID, err := c.find(infoHash)
+	if err != nil {
+		return err
+	}
+	if old != nil {
+		entry.State = old.State
+		entry.State.Name, entry.State.Updated = info.Name, now
+	}
+	return c.put(&entry, oldID)
+}
+
+func (c *Encrypted) LoadTorrents() ([]torrent.TorrentSpec, *LoadReport, error) {
+	names, err := c.store.ListBlobs(sealedSuffix)
+	if err != nil {
+		return nil, nil, err
+	}
+	var specs []torrent.TorrentSpec
+	report := &LoadReport{}
+	for _, name := range names {
+		entry, _, err := c.get(name)
+		if err == nil {
+			var mi *metainfo.MetaInfo
+			if mi, err = metainfo.Load(bytes.NewReader(entry.Metainfo)); err == nil {
+				specs = append(specs, *torrent.TorrentSpecFromMetaInfo(mi))
+				report.Loaded++
+				continue
+			}
+		}
+		// Sealed entries are left in place, since they most often fail to
+		// load because their key is missing from the keyring.
+		report.quarantine(name, "", err)
+	}
+	return specs, report, nil
+}
+
+func (c *Encrypted) Metainfos() (map[string][]byte, *LoadReport, error) {
+	names, err := c.store.ListBlobs(sealedSuffix)
+	if err != nil {
+		return nil, nil, err
+	}
+	files := make(map[string][]byte)
+	report := &LoadReport{}
+	for _, name := range names {
+		entry, _, err := c.get(name)
+		if err == ErrNotFound {
+			continue
+		}
+		if err != nil {
+			report.quarantine(name, "", err)
+			continue
+		}
+		report.addMetainfo(files, name, entry.Metainfo)
+	}
+	return files, report, nil
+}
+
+func (c *Encrypted) LoadTorrent(infoHash string) (*torrent.TorrentSpec, error) {
+	entry, _, err := c.find(infoHash)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, notFound(infoHash)
+	}
+	mi, err := parseMetainfo(entry.Metainfo)
+	if err != nil {
+		return nil, err
+	}
+	return torrent.TorrentSpecFromMetaInfo(mi), nil
+}
+
+func (c *Encrypted) DeleteTorrent(t *torrent.Torrent) error {
+	infoHash := t.InfoHash().HexString()
+	for _, id := range c.keys.ids {
+		if err := c.store.DeleteBlob(c.blobName(id, infoHash)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Encrypted) State(infoHash string) (*TorrentState, error) {
+	entry, _, err := c.find(infoHash)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, notFound(infoHash)
+	}
+	return &entry.State, nil
+}
+
+func (c *Encrypted) SetState(infoHash string, state TorrentState) error {
+	entry, id, err := c.find(infoHash)
+	if err != nil {
+		return err
+	}
+	if entry == nil {
+		return notFound(infoHash)
+	}
+	entry.State = state
+	return c.put(entry, id)
+}
+
+// Rotate seals every entry that is not sealed with the primary key again with
+// the primary key. Once it returns, the other keys can be removed from the
+// keyring. It returns the number of entries that were sealed again.
+func (c *Encrypted) Rotate() (int, error) {
+	names, err := c.store.ListBlobs(sealedSuffix)
+	if err != nil {
+		return 0, err
+	}
+	rotated := 0
+	for _, name := range names {
+		entry, id, err := c.get(name)
+		if err != nil {
+			return rotated, errors.Wrapf(err, "could not open %s", name)
+		}
+		if id == c.keys.primary {
+			continue
+		}
+		if err := c.put(entry, id); err != nil {
+			return rotated, errors.Wrapf(err, "could not seal torrent %s", entry.InfoHash)
+		}
+		rotated++
+	}
+	return rotated, nil
+}
+
+// Close closes the underlying store, if it can be closed.
+func (c *Encrypted) Close() error {
+	if closer, ok := c.store.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// blobName returns the name of the blob of a torrent sealed with the given key.
+func (c *Encrypted) blobName(id, infoHash string) string {
+	mac := hmac.New(sha256.New, c.keys.keys[id].name)
+	mac.Write([]byte(infoHash))
+	return hex.EncodeToString(mac.Sum(nil)) + sealedSuffix
+}
+
+// find returns the entry of a torrent and the ID of the key it is sealed
+// with, or a nil entry if the torrent is not in the cache. The keys are tried
+// in keyring order, so an entry sealed with the primary key is found first.
+func (c *Encrypted) find(infoHash string) (*sealedEntry, string, error) {
+	for _, id := range c.keys.ids {
+		entry, sealedWith, err := c.get(c.blobName(id, infoHash))
+		if errors.Cause(err) == ErrNotFound {
+			continue
+		}
+		return entry, sealedWith, err
+	}
+	return nil, "", nil
+}
+
+// put seals the entry with the primary key and stores it. If the entry was
+// sealed with another key, given by old, the blob sealed with that key is
+// deleted.
+func (c *Encrypted) put(entry *sealedEntry, old string) error {
+	plaintext, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	id := c.keys.primary
+	aead, err := c.keys.aead(id)
+	if err != nil {
+		return err
+	}
+	header := append([]byte(sealedMagic), byte(len(id)))
+	header = append(header, id...)
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+	data := append(header, nonce...)
+	data = aead.Seal(data, nonce, plaintext, header)
+	if err := c.store.PutBlob(c.blobName(id, entry.InfoHash), data); err != nil {
+		return err
+	}
+	if old == "" || old == id {
+		return nil
+	}
+	return c.store.DeleteBlob(c.blobName(old, entry.InfoHash))
+}
+
+// get opens the named blob, returning its entry and the ID of the key it is
+// sealed with.
+func (c *Encrypted) get(name string) (*sealedEntry, string, error) {
+	data, err := c.store.GetBlob(name)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(data) < len(sealedMagic)+1 || string(data[:len(sealedMagic)]) != sealedMagic {
+		return nil, "", errors.New("not a sealed entry")
+	}
+	idLen := int(data[len(sealedMagic)])
+	headerLen := len(sealedMagic) + 1 + idLen
+	if len(data) < headerLen {
+		return nil, "", errors.New("truncated sealed entry")
+	}
+	header, id := data[:headerLen], string(data[len(sealedMagic)+1:headerLen])
+	aead, err := c.keys.aead(id)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(data) < headerLen+aead.NonceSize() {
+		return nil, "", errors.New("truncated sealed entry")
+	}
+	nonce := data[headerLen : headerLen+aead.NonceSize()]
+	plaintext, err := aead.Open(nil, nonce, data[headerLen+aead.NonceSize():], header)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "could not open sealed entry")
+	}
+	var entry sealedEntry
+	if err := json.Unmarshal(plaintext, &entry); err != nil {
+		return nil, "", err
+	}
+	// The blob name binds the entry to its info hash, so that entries can't
+	// be swapped between names.
+	if c.blobName(id, entry.InfoHash) != name {
+		return nil, "", errors.New("sealed entry does not match its name")
+	}
+	return &entry, id, nil
+}
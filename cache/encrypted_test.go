@@ -0,0 +1,142 @@
+package cache_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/joelanford/torrential/cache"
+)
+
+const sampleInfoHash = "d0d14c926e6e99761a2fdcff27b403d96376eff6"
+
+func loadSample(t *testing.T) []byte {
+	data, err := ioutil.ReadFile("../testdata/sample.torrent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func keyring(t *testing.T, keys ...string) *cache.Keyring {
+	var entries []string
+	for _, id := range keys {
+		key := base64.StdEncoding.EncodeToString([]byte(strings.Repeat(id[:1], 32)))
+		entries = append(entries, id+":"+key)
+	}
+	kr, err := cache.ParseKeyring(strings.Join(entries, "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return kr
+}
+
+func sealedBlobs(t *testing.T, store cache.BlobStore) []string {
+	names, err := store.ListBlobs(".sealed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return names
+}
+
+func TestEncrypted(t *testing.T) {
+	store := cache.NewMemory()
+	c := cache.NewEncrypted(store, keyring(t, "old"))
+	assert.NoError(t, c.SaveMetainfo(loadSample(t)))
+
+	names := sealedBlobs(t, store)
+	if assert.Len(t, names, 1) {
+		assert.NotContains(t, names[0], sampleInfoHash)
+		data, err := store.GetBlob(names[0])
+		assert.NoError(t, err)
+		assert.False(t, bytes.Contains(data, []byte("sample.txt")), "blob is not sealed")
+	}
+
+	spec, err := c.LoadTorrent(sampleInfoHash)
+	if assert.NoError(t, err) {
+		assert.Equal(t, sampleInfoHash, spec.InfoHash.HexString())
+	}
+	state, err := c.State(sampleInfoHash)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "sample.txt", state.Name)
+	}
+	state.Labels = []string{"movies"}
+	assert.NoError(t, c.SetState(sampleInfoHash, *state))
+
+	// A cache with an unrelated key can't find or open the entry.
+	other := cache.NewEncrypted(store, keyring(t, "other"))
+	_, err = other.State(sampleInfoHash)
+	assert.Equal(t, cache.ErrNotFound, errors.Cause(err))
+	specs, report, err := other.LoadTorrents()
+	assert.NoError(t, err)
+	assert.Empty(t, specs)
+	assert.Len(t, report.Quarantined, 1)
+
+	// After adding a new primary key, entries sealed with the old key can
+	// still be opened, and are sealed with the new key when they are saved.
+	rotated := cache.NewEncrypted(store, keyring(t, "new", "old"))
+	state, err = rotated.State(sampleInfoHash)
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"movies"}, state.Labels)
+	}
+	assert.NoError(t, rotated.SaveMetainfo(loadSample(t)))
+	assert.Len(t, sealedBlobs(t, store), 1)
+	n, err := rotated.Rotate()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	state, err = cache.NewEncrypted(store, keyring(t, "new")).State(sampleInfoHash)
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"movies"}, state.Labels)
+	}
+}
+
+func TestEncryptedRotate(t *testing.T) {
+	store := cache.NewMemory()
+	assert.NoError(t, cache.NewEncrypted(store, keyring(t, "old")).SaveMetainfo(loadSample(t)))
+
+	c := cache.NewEncrypted(store, keyring(t, "new", "old"))
+	n, err := c.Rotate()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Len(t, sealedBlobs(t, store), 1)
+
+	specs, report, err := cache.NewEncrypted(store, keyring(t, "new")).LoadTorrents()
+	assert.NoError(t, err)
+	assert.Len(t, specs, 1)
+	assert.Empty(t, report.Quarantined)
+
+	_, err = cache.NewEncrypted(store, keyring(t, "old")).State(sampleInfoHash)
+	assert.Equal(t, cache.ErrNotFound, errors.Cause(err))
+}
+
+func TestParseKeyring(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString(make([]byte, 16))
+
+	kr, err := cache.ParseKeyring("# keys\nb:" + key + "\n\na:" + key)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "b", kr.Primary())
+	}
+	kr, err = cache.ParseKeyring("b:" + key + ",a:" + key)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "b", kr.Primary())
+	}
+
+	for _, s := range []string{
+		"",
+		"# no keys",
+		key,
+		"a:not base64",
+		"a:" + base64.StdEncoding.EncodeToString(make([]byte, 10)),
+		"a:" + key + "\na:" + key,
+		strings.Repeat("a", 256) + ":" + key,
+	} {
+		_, err := cache.ParseKeyring(s)
+		assert.Error(t, err, "%q", s)
+	}
+}
@@ -0,0 +1,129 @@
+package cache
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/hkdf"
+)
+
+// Keyring holds the keys of an Encrypted cache. New entries are sealed with
+// the primary key, and entries sealed with any key in the ring can be opened,
+// which allows keys to be rotated: add a new primary key, run Rotate, then
+// remove the old key.
+type Keyring struct {
+	primary string
+
+	// ids are the key IDs in the order they were parsed, so the primary key
+	// comes first.
+	ids  []string
+	keys map[string]*cacheKey
+}
+
+// cacheKey holds the subkeys that are derived from a key of the keyring with
+// HKDF, so that the same key material is never used for both sealing entries
+// and naming blobs.
+type cacheKey struct {
+	seal []byte
+	name []byte
+}
+
+func newCacheKey(key []byte) (*cacheKey, error) {
+	seal, err := deriveKey(key, "torrential cache seal", len(key))
+	if err != nil {
+		return nil, err
+	}
+	name, err := deriveKey(key, "torrential cache name", sha256.Size)
+	if err != nil {
+		return nil, err
+	}
+	return &cacheKey{seal: seal, name: name}, nil
+}
+
+func deriveKey(key []byte, info string, size int) ([]byte, error) {
+	derived := make([]byte, size)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, key, nil, []byte(info)), derived); err != nil {
+		return nil, err
+	}
+	return derived, nil
+}
+
+// ParseKeyring parses a keyring from lines or comma-separated entries of the
+// form id:key, where key is a base64-encoded 16, 24 or 32 byte AES key. The
+// first entry is the primary key. Empty lines and lines starting with # are
+// ignored.
+func ParseKeyring(s string) (*Keyring, error) {
+	kr := &Keyring{keys: make(map[string]*cacheKey)}
+	scanner := bufio.NewScanner(strings.NewReader(strings.Replace(s, ",", "\n", -1)))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.Index(line, ":")
+		if i <= 0 {
+			return nil, errors.New("invalid key: expected id:key")
+		}
+		id := line[:i]
+		if len(id) > 255 {
+			return nil, errors.Errorf("invalid key %s: id is too long", id)
+		}
+		key, err := base64.StdEncoding.DecodeString(line[i+1:])
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid key %s", id)
+		}
+		if _, err := aes.NewCipher(key); err != nil {
+			return nil, errors.Wrapf(err, "invalid key %s", id)
+		}
+		if _, ok := kr.keys[id]; ok {
+			return nil, errors.Errorf("duplicate key %s", id)
+		}
+		if kr.primary == "" {
+			kr.primary = id
+		}
+		if kr.keys[id], err = newCacheKey(key); err != nil {
+			return nil, errors.Wrapf(err, "invalid key %s", id)
+		}
+		kr.ids = append(kr.ids, id)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if kr.primary == "" {
+		return nil, errors.New("keyring has no keys")
+	}
+	return kr, nil
+}
+
+// LoadKeyring reads a keyring file in the format of ParseKeyring.
+func LoadKeyring(path string) (*Keyring, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseKeyring(string(data))
+}
+
+// Primary returns the ID of the primary key.
+func (kr *Keyring) Primary() string {
+	return kr.primary
+}
+
+func (kr *Keyring) aead(id string) (cipher.AEAD, error) {
+	key, ok := kr.keys[id]
+	if !ok {
+		return nil, errors.Errorf("unknown key %s", id)
+	}
+	block, err := aes.NewCipher(key.seal)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,203 @@
+package cache
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const leaseSuffix = ".lease"
+
+// Lease records which instance owns a torrent, and until when.
+type Lease struct {
+	InfoHash string    `json:"infoHash"`
+	Owner    string    `json:"owner"`
+	Expires  time.Time `json:"expires"`
+}
+
+// Expired reports whether the lease has expired at the given time.
+func (l *Lease) Expired(now time.Time) bool {
+	return now.After(l.Expires)
+}
+
+// Leases coordinates the instances that share a cache, so that each torrent
+// is owned by a single instance. Leases are stored as blobs next to the
+// cached torrents and must be renewed before they expire; the torrents of an
+// instance that stops renewing its leases are taken over by the others.
+//
+// Blob stores like S3 have no compare-and-swap, so a lease is acquired by
+// writing it, waiting for Settle, and reading it back. If two instances race
+// for the same lease, the one that wrote last wins, and the other sees the
+// winner's lease when it reads it back. This only holds if every write is
+// visible to all instances within Settle: a write that takes longer can land
+// after the other instance has read back its own lease, and both instances
+// then run the torrent until the next renewal, when the one that lost the
+// lease drops it. Settle should therefore be well above the write latency of
+// the store, and the store must have read-after-write consistency.
+//
+// Instances only take over torrents that have a lease, so torrents must be
+// added through an instance, or be in the cache when an instance starts.
+// Leases can't be shared through a Bolt or Memory cache, since those can only
+// be used by a single process. Leases are stored unencrypted as blobs named
+// <info hash>.lease, so they should not share the store of an Encrypted cache,
+// whose blob names hide the info hashes.
+type Leases struct {
+	store BlobStore
+	owner string
+
+	// TTL is how long a lease is valid without being renewed.
+	TTL time.Duration
+
+	// Settle is how long to wait before reading back a written lease.
+	Settle time.Duration
+}
+
+// NewLeases returns Leases for the given instance, which must have an ID that
+// is unique among the instances sharing the store.
+func NewLeases(store BlobStore, owner string) *Leases {
+	return &Leases{
+		store:  store,
+		owner:  owner,
+		TTL:    30 * time.Second,
+		Settle: time.Second,
+	}
+}
+
+// Owner returns the ID of this instance.
+func (l *Leases) Owner() string {
+	return l.owner
+}
+
+// Get returns the lease of a torrent, or nil if it has none.
+func (l *Leases) Get(infoHash string) (*Lease, error) {
+	data, err := l.store.GetBlob(infoHash + leaseSuffix)
+	if err == ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var lease Lease
+	if err := json.Unmarshal(data, &lease); err != nil {
+		return nil, errors.Wrapf(err, "invalid lease of torrent %s", infoHash)
+	}
+	return &lease, nil
+}
+
+// List returns the leases of all torrents.
+func (l *Leases) List() ([]Lease, error) {
+	names, err := l.store.ListBlobs(leaseSuffix)
+	if err != nil {
+		return nil, err
+	}
+	var leases []Lease
+	for _, name := range names {
+		lease, err := l.Get(strings.TrimSuffix(name, leaseSuffix))
+		if err != nil {
+			return nil, err
+		}
+		if lease != nil {
+			leases = append(leases, *lease)
+		}
+	}
+	return leases, nil
+}
+
+// Acquire takes the lease of a torrent if it is free, expired or already
+// owned by this instance, and reports whether this instance owns it.
+func (l *Leases) Acquire(infoHash string) (bool, error) {
+	owned, err := l.AcquireAll([]string{infoHash})
+	return len(owned) > 0, err
+}
+
+// AcquireAll is like Acquire for many torrents, but only waits for Settle
+// once. It returns the info hashes of the torrents this instance owns.
+func (l *Leases) AcquireAll(infoHashes []string) ([]string, error) {
+	var owned, written []string
+	for _, infoHash := range infoHashes {
+		lease, err := l.Get(infoHash)
+		if err != nil {
+			return owned, err
+		}
+		if lease != nil && lease.Owner != l.owner && !lease.Expired(time.Now()) {
+			continue
+		}
+		if err := l.put(infoHash); err != nil {
+			return owned, err
+		}
+		if lease != nil && lease.Owner == l.owner {
+			owned = append(owned, infoHash)
+		} else {
+			written = append(written, infoHash)
+		}
+	}
+	if len(written) == 0 {
+		return owned, nil
+	}
+
+	time.Sleep(l.Settle)
+	for _, infoHash := range written {
+		lease, err := l.Get(infoHash)
+		if err != nil {
+			return owned, err
+		}
+		if lease != nil && lease.Owner == l.owner {
+			owned = append(owned, infoHash)
+		}
+	}
+	return owned, nil
+}
+
+// Renew extends the lease of a torrent owned by this instance. It reports
+// whether this instance still owns the torrent; if another instance has
+// taken over the lease, it is not renewed.
+func (l *Leases) Renew(infoHash string) (bool, error) {
+	lease, err := l.Get(infoHash)
+	if err != nil {
+		return false, err
+	}
+	if lease != nil && lease.Owner != l.owner {
+		return false, nil
+	}
+	return true, l.put(infoHash)
+}
+
+// Expire gives up the lease of a torrent if it is owned by this instance, so
+// that another instance takes the torrent over when it next checks the
+// leases, without waiting for the lease to expire.
+func (l *Leases) Expire(infoHash string) error {
+	lease, err := l.Get(infoHash)
+	if err != nil || lease == nil || lease.Owner != l.owner {
+		return err
+	}
+	lease.Expires = time.Now()
+	data, err := json.Marshal(lease)
+	if err != nil {
+		return err
+	}
+	return l.store.PutBlob(infoHash+leaseSuffix, data)
+}
+
+// Release removes the lease of a torrent if it is owned by this instance. It
+// is used when the torrent is dropped, so that no instance takes it over.
+func (l *Leases) Release(infoHash string) error {
+	lease, err := l.Get(infoHash)
+	if err != nil || lease == nil || lease.Owner != l.owner {
+		return err
+	}
+	return l.store.DeleteBlob(infoHash + leaseSuffix)
+}
+
+func (l *Leases) put(infoHash string) error {
+	data, err := json.Marshal(Lease{
+		InfoHash: infoHash,
+		Owner:    l.owner,
+		Expires:  time.Now().Add(l.TTL),
+	})
+	if err != nil {
+		return err
+	}
+	return l.store.PutBlob(infoHash+leaseSuffix, data)
+}
@@ -0,0 +1,86 @@
+package cache_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/joelanford/torrential/cache"
+)
+
+const leaseHash = "d0d14c926e6e99761a2fdcff27b403d96376eff6"
+
+func newLeases(store cache.BlobStore, owner string) *cache.Leases {
+	l := cache.NewLeases(store, owner)
+	l.TTL = 50 * time.Millisecond
+	l.Settle = time.Millisecond
+	return l
+}
+
+func TestLeases(t *testing.T) {
+	store := cache.NewMemory()
+	a := newLeases(store, "a")
+	b := newLeases(store, "b")
+
+	owned, err := a.Acquire(leaseHash)
+	assert.NoError(t, err)
+	assert.True(t, owned)
+	owned, err = b.Acquire(leaseHash)
+	assert.NoError(t, err)
+	assert.False(t, owned, "lease of a is still valid")
+
+	leases, err := b.List()
+	assert.NoError(t, err)
+	if assert.Len(t, leases, 1) {
+		assert.Equal(t, "a", leases[0].Owner)
+		assert.False(t, leases[0].Expired(time.Now()))
+	}
+
+	// Once a stops renewing its lease, b takes it over, and a finds out
+	// when it tries to renew it.
+	time.Sleep(2 * a.TTL)
+	owned, err = b.Acquire(leaseHash)
+	assert.NoError(t, err)
+	assert.True(t, owned, "lease of a has expired")
+	owned, err = a.Renew(leaseHash)
+	assert.NoError(t, err)
+	assert.False(t, owned, "lease was taken over")
+	owned, err = b.Renew(leaseHash)
+	assert.NoError(t, err)
+	assert.True(t, owned)
+
+	// Only the owner can release a lease.
+	assert.NoError(t, a.Release(leaseHash))
+	lease, err := a.Get(leaseHash)
+	assert.NoError(t, err)
+	if assert.NotNil(t, lease) {
+		assert.Equal(t, "b", lease.Owner)
+	}
+	assert.NoError(t, b.Release(leaseHash))
+	lease, err = a.Get(leaseHash)
+	assert.NoError(t, err)
+	assert.Nil(t, lease)
+}
+
+func TestLeasesExpire(t *testing.T) {
+	store := cache.NewMemory()
+	a := newLeases(store, "a")
+	b := newLeases(store, "b")
+	a.TTL = time.Hour
+
+	owned, err := a.AcquireAll([]string{leaseHash})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{leaseHash}, owned)
+
+	// An expired lease can be taken over right away.
+	assert.NoError(t, a.Expire(leaseHash))
+	leases, err := b.List()
+	assert.NoError(t, err)
+	if assert.Len(t, leases, 1) {
+		assert.True(t, leases[0].Expired(time.Now()))
+	}
+	owned, err = b.AcquireAll([]string{leaseHash})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{leaseHash}, owned)
+}
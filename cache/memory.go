@@ -0,0 +1,179 @@
+package cache
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// Memory is a Cache that keeps torrents in memory, for tests and ephemeral
+// deployments. Its contents are lost when the process exits.
+type Memory struct {
+	metainfos map[string][]byte
+	states    map[string]TorrentState
+	blobs     map[string][]byte
+	mu        sync.RWMutex
+}
+
+var (
+	_ StateCache    = &Memory{}
+	_ BlobStore     = &Memory{}
+	_ TorrentLoader = &Memory{}
+	_ MetainfoCache = &Memory{}
+)
+
+func NewMemory() *Memory {
+	return &Memory{
+		metainfos: make(map[string][]byte),
+		states:    make(map[string]TorrentState),
+		blobs:     make(map[string][]byte),
+	}
+}
+
+func (c *Memory) SaveTorrent(t *torrent.Torrent) error {
+	data, err := torrentMetainfo(t)
+	if err != nil {
+		return err
+	}
+	return c.SaveMetainfo(data)
+}
+
+func (c *Memory) SaveMetainfo(data []byte) error {
+	mi, err := parseMetainfo(data)
+	if err != nil {
+		return err
+	}
+	info, err := mi.UnmarshalInfo()
+	if err != nil {
+		return err
+	}
+	infoHash := mi.HashInfoBytes().HexString()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	state := TorrentState{Added: now}
+	if old, ok := c.states[infoHash]; ok {
+		state = old
+	}
+	state.Name, state.Updated = info.Name, now
+	c.metainfos[infoHash] = append([]byte(nil), data...)
+	c.states[infoHash] = state
+	return nil
+}
+
+func (c *Memory) LoadTorrents() ([]torrent.TorrentSpec, *LoadReport, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	var specs []torrent.TorrentSpec
+	report := &LoadReport{}
+	for infoHash, data := range c.metainfos {
+		mi, err := metainfo.Load(bytes.NewReader(data))
+		if err != nil {
+			report.quarantine(infoHash, "", err)
+			continue
+		}
+		specs = append(specs, *torrent.TorrentSpecFromMetaInfo(mi))
+		report.Loaded++
+	}
+	return specs, report, nil
+}
+
+func (c *Memory) Metainfos() (map[string][]byte, *LoadReport, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	files := make(map[string][]byte, len(c.metainfos))
+	report := &LoadReport{}
+	for infoHash, data := range c.metainfos {
+		report.addMetainfo(files, infoHash, append([]byte(nil), data...))
+	}
+	return files, report, nil
+}
+
+func (c *Memory) LoadTorrent(infoHash string) (*torrent.TorrentSpec, error) {
+	c.mu.RLock()
+	data, ok := c.metainfos[infoHash]
+	c.mu.RUnlock()
+	if !ok {
+		return nil, notFound(infoHash)
+	}
+	mi, err := parseMetainfo(data)
+	if err != nil {
+		return nil, err
+	}
+	return torrent.TorrentSpecFromMetaInfo(mi), nil
+}
+
+func (c *Memory) DeleteTorrent(t *torrent.Torrent) error {
+	infoHash := t.InfoHash().HexString()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.metainfos, infoHash)
+	delete(c.states, infoHash)
+	return nil
+}
+
+func (c *Memory) State(infoHash string) (*TorrentState, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	state, ok := c.states[infoHash]
+	if !ok {
+		return nil, notFound(infoHash)
+	}
+	return &state, nil
+}
+
+func (c *Memory) SetState(infoHash string, state TorrentState) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.metainfos[infoHash]; !ok {
+		return notFound(infoHash)
+	}
+	c.states[infoHash] = state
+	return nil
+}
+
+func (c *Memory) PutBlob(name string, data []byte) error {
+	if err := checkBlobName(name); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.blobs[name] = append([]byte(nil), data...)
+	return nil
+}
+
+func (c *Memory) GetBlob(name string) ([]byte, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	data, ok := c.blobs[name]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return append([]byte(nil), data...), nil
+}
+
+func (c *Memory) DeleteBlob(name string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.blobs, name)
+	return nil
+}
+
+func (c *Memory) ListBlobs(suffix string) ([]string, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	var names []string
+	for name := range c.blobs {
+		if strings.HasSuffix(name, suffix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
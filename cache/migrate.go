@@ -0,0 +1,104 @@
+package cache
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// MigrateReport describes the outcome of a migration.
+type MigrateReport struct {
+	// Copied is the number of torrents copied to the destination cache.
+	Copied int `json:"copied"`
+
+	// Source is the load report of the source cache. Entries that can't be
+	// parsed are left in place in the source cache and are not copied.
+	Source *LoadReport `json:"source"`
+}
+
+// Migrate copies all torrents from one cache to another. Both caches must
+// implement MetainfoCache. Once the torrents are copied, the metainfo files in
+// the destination cache are compared with the source to verify the copy.
+// Torrents are not deleted from the source cache.
+func Migrate(from, to Cache) (*MigrateReport, error) {
+	src, err := metainfoCache(from)
+	if err != nil {
+		return nil, err
+	}
+	dst, err := metainfoCache(to)
+	if err != nil {
+		return nil, err
+	}
+	files, report, err := src.Metainfos()
+	if err != nil {
+		return nil, errors.Wrap(err, "could not load source cache")
+	}
+	for _, infoHash := range sortedKeys(files) {
+		if err := dst.SaveMetainfo(files[infoHash]); err != nil {
+			return nil, errors.Wrapf(err, "could not copy torrent %s", infoHash)
+		}
+		if err := copyState(from, to, infoHash); err != nil {
+			return nil, err
+		}
+	}
+	if err := verify(dst, files); err != nil {
+		return nil, err
+	}
+	return &MigrateReport{Copied: len(files), Source: report}, nil
+}
+
+func metainfoCache(c Cache) (MetainfoCache, error) {
+	mc, ok := c.(MetainfoCache)
+	if !ok {
+		return nil, errors.Errorf("cache %T can't be copied", c)
+	}
+	return mc, nil
+}
+
+func sortedKeys(files map[string][]byte) []string {
+	keys := make([]string, 0, len(files))
+	for k := range files {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// copyState copies the state of a torrent if both caches store state.
+func copyState(from, to Cache, infoHash string) error {
+	src, ok := from.(StateCache)
+	if !ok {
+		return nil
+	}
+	dst, ok := to.(StateCache)
+	if !ok {
+		return nil
+	}
+	state, err := src.State(infoHash)
+	if err != nil {
+		return errors.Wrapf(err, "could not load state of torrent %s", infoHash)
+	}
+	if err := dst.SetState(infoHash, *state); err != nil {
+		return errors.Wrapf(err, "could not copy state of torrent %s", infoHash)
+	}
+	return nil
+}
+
+// verify checks that the cache holds each of the metainfo files unchanged.
+func verify(c MetainfoCache, want map[string][]byte) error {
+	have, _, err := c.Metainfos()
+	if err != nil {
+		return errors.Wrap(err, "could not load destination cache")
+	}
+	for infoHash, data := range want {
+		stored, ok := have[infoHash]
+		if !ok {
+			return errors.Errorf("verification failed: torrent %s is missing from destination cache", infoHash)
+		}
+		if !bytes.Equal(stored, data) {
+			return errors.Errorf("verification failed: torrent %s differs in destination cache", infoHash)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package cache_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/joelanford/torrential/cache"
+)
+
+func TestMigrate(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "bad.torrent"), []byte("not bencode"), 0660))
+
+	src := cache.NewMemory()
+	sample := loadSample(t)
+	assert.NoError(t, src.SaveMetainfo(sample))
+	state, err := src.State(sampleInfoHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.Labels = []string{"movies"}
+	assert.NoError(t, src.SetState(sampleInfoHash, *state))
+
+	// Memory to a directory, which doesn't store state, and back.
+	report, err := cache.Migrate(src, cache.NewDirectory(dir))
+	if assert.NoError(t, err) {
+		assert.Equal(t, 1, report.Copied)
+	}
+	dst := cache.NewMemory()
+	report, err = cache.Migrate(cache.NewDirectory(dir), dst)
+	if assert.NoError(t, err) {
+		assert.Equal(t, 1, report.Copied)
+		assert.Len(t, report.Source.Quarantined, 1)
+	}
+
+	// Entries that can't be parsed are left in place in the source cache.
+	_, err = os.Stat(filepath.Join(dir, "bad.torrent"))
+	assert.NoError(t, err)
+
+	files, _, err := dst.Metainfos()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]byte{sampleInfoHash: sample}, files)
+
+	// Between caches that store state, the state is copied too.
+	enc := cache.NewEncrypted(cache.NewMemory(), keyring(t, "key"))
+	_, err = cache.Migrate(src, enc)
+	assert.NoError(t, err)
+	copied, err := enc.State(sampleInfoHash)
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"movies"}, copied.Labels)
+	}
+}
+
+func TestExportImport(t *testing.T) {
+	src := cache.NewMemory()
+	sample := loadSample(t)
+	assert.NoError(t, src.SaveMetainfo(sample))
+	state, err := src.State(sampleInfoHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.Labels = []string{"movies"}
+	assert.NoError(t, src.SetState(sampleInfoHash, *state))
+
+	var archive bytes.Buffer
+	n, err := cache.Export(src, &archive)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	dst := cache.NewMemory()
+	n, err = cache.Import(dst, bytes.NewReader(archive.Bytes()))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	files, _, err := dst.Metainfos()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]byte{sampleInfoHash: sample}, files)
+	imported, err := dst.State(sampleInfoHash)
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"movies"}, imported.Labels)
+	}
+
+	// A truncated archive is rejected without saving anything.
+	dst = cache.NewMemory()
+	_, err = cache.Import(dst, bytes.NewReader(archive.Bytes()[:archive.Len()/2]))
+	assert.Error(t, err)
+	files, _, err = dst.Metainfos()
+	assert.NoError(t, err)
+	assert.Empty(t, files)
+}
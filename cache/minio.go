@@ -3,7 +3,10 @@ package cache
 import (
 	"bytes"
 	"fmt"
+	"io/ioutil"
+	"path"
 	"strings"
+	"time"
 
 	"github.com/anacrolix/torrent"
 	"github.com/anacrolix/torrent/metainfo"
@@ -15,8 +18,19 @@ type Minio struct {
 	client *minio.Client
 	region string
 	bucket string
+
+	// PollInterval is how often Watch lists the bucket. It defaults to 30
+	// seconds.
+	PollInterval time.Duration
 }
 
+const defaultPollInterval = 30 * time.Second
+
+var (
+	_ TorrentLoader = &Minio{}
+	_ MetainfoCache = &Minio{}
+)
+
 func NewMinio(client *minio.Client, bucket string) *Minio {
 	return &Minio{
 		client: client,
@@ -34,41 +48,31 @@ func NewMinioWithRegion(client *minio.Client, bucket, region string) *Minio {
 }
 
 func (c *Minio) SaveTorrent(t *torrent.Torrent) error {
-	select {
-	case <-t.GotInfo():
-		exists, err := c.client.BucketExists(c.bucket)
-		if err != nil {
-			return err
-		}
-
-		if !exists {
-			if err := c.client.MakeBucket(c.bucket, c.region); err != nil {
-				return err
-			}
-		}
-
-		var buf bytes.Buffer
-		if err := t.Metainfo().Write(&buf); err != nil {
-			return err
-		}
+	data, err := torrentMetainfo(t)
+	if err != nil {
+		return err
+	}
+	return c.SaveMetainfo(data)
+}
 
-		filename := fmt.Sprintf("%s.torrent", t.InfoHash().HexString())
-		_, err = c.client.PutObject(c.bucket, filename, &buf, int64(buf.Len()), minio.PutObjectOptions{})
+func (c *Minio) SaveMetainfo(data []byte) error {
+	mi, err := parseMetainfo(data)
+	if err != nil {
 		return err
-	case <-t.Closed():
-		return errors.New("torrent closed before info ready")
 	}
+	return c.PutBlob(fmt.Sprintf("%s.torrent", mi.HashInfoBytes().HexString()), data)
 }
 
-func (c *Minio) LoadTorrents() ([]torrent.TorrentSpec, error) {
+func (c *Minio) LoadTorrents() ([]torrent.TorrentSpec, *LoadReport, error) {
 	exists, err := c.client.BucketExists(c.bucket)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	var specs []torrent.TorrentSpec
+	report := &LoadReport{}
 	if !exists {
-		return specs, nil
+		return specs, report, nil
 	}
 
 	doneCh := make(chan struct{})
@@ -77,26 +81,162 @@ func (c *Minio) LoadTorrents() ([]torrent.TorrentSpec, error) {
 	objectsChan := c.client.ListObjectsV2(c.bucket, "", false, doneCh)
 	for info := range objectsChan {
 		if info.Err != nil {
-			return nil, info.Err
+			return nil, nil, info.Err
 		}
 		if !strings.HasSuffix(info.Key, ".torrent") {
 			continue
 		}
-		obj, err := c.client.GetObject(c.bucket, info.Key, minio.GetObjectOptions{})
-		if err != nil {
-			return nil, err
+		mi, err := c.loadObject(info.Key)
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			// The object was removed since the bucket was listed.
+			continue
+		}
+		if _, ok := err.(parseError); ok {
+			report.quarantine(info.Key, c.quarantine(info.Key), err)
+			continue
 		}
-		mi, err := metainfo.Load(obj)
 		if err != nil {
-			return nil, err
+			return nil, nil, errors.Wrapf(err, "could not read %s", info.Key)
 		}
 		spec := torrent.TorrentSpecFromMetaInfo(mi)
 		specs = append(specs, *spec)
+		report.Loaded++
+	}
+	return specs, report, nil
+}
+
+func (c *Minio) Metainfos() (map[string][]byte, *LoadReport, error) {
+	files := make(map[string][]byte)
+	report := &LoadReport{}
+	exists, err := c.client.BucketExists(c.bucket)
+	if err != nil || !exists {
+		return files, report, err
+	}
+
+	doneCh := make(chan struct{})
+	defer close(doneCh)
+
+	for info := range c.client.ListObjectsV2(c.bucket, "", false, doneCh) {
+		if info.Err != nil {
+			return nil, nil, info.Err
+		}
+		if !strings.HasSuffix(info.Key, ".torrent") {
+			continue
+		}
+		data, err := c.GetBlob(info.Key)
+		if err == ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "could not read %s", info.Key)
+		}
+		report.addMetainfo(files, info.Key, data)
+	}
+	return files, report, nil
+}
+
+func (c *Minio) LoadTorrent(infoHash string) (*torrent.TorrentSpec, error) {
+	mi, err := c.loadObject(fmt.Sprintf("%s.torrent", infoHash))
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return nil, notFound(infoHash)
+	}
+	if err != nil {
+		return nil, err
 	}
-	return specs, nil
+	return torrent.TorrentSpecFromMetaInfo(mi), nil
+}
+
+// loadObject reads and parses a torrent object. Errors parsing the object are
+// returned as a parseError.
+func (c *Minio) loadObject(key string) (*metainfo.MetaInfo, error) {
+	obj, err := c.client.GetObject(c.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Close()
+	data, err := ioutil.ReadAll(obj)
+	if err != nil {
+		return nil, err
+	}
+	return parseMetainfo(data)
+}
+
+// quarantine moves the object under the quarantine prefix, returning its new
+// key, or an empty string if it could not be moved.
+func (c *Minio) quarantine(key string) string {
+	dst := path.Join(quarantineDir, quarantineName(key, func(name string) bool {
+		_, err := c.client.StatObject(c.bucket, path.Join(quarantineDir, name), minio.StatObjectOptions{})
+		return err == nil
+	}))
+	dstInfo, err := minio.NewDestinationInfo(c.bucket, dst, nil, nil)
+	if err != nil {
+		return ""
+	}
+	if err := c.client.CopyObject(dstInfo, minio.NewSourceInfo(c.bucket, key, nil)); err != nil {
+		return ""
+	}
+	if err := c.client.RemoveObject(c.bucket, key); err != nil {
+		return ""
+	}
+	return dst
 }
 
 func (c *Minio) DeleteTorrent(t *torrent.Torrent) error {
 	filename := fmt.Sprintf("%s.torrent", t.InfoHash().HexString())
 	return c.client.RemoveObject(c.bucket, filename)
 }
+
+func (c *Minio) PutBlob(name string, data []byte) error {
+	if err := checkBlobName(name); err != nil {
+		return err
+	}
+	exists, err := c.client.BucketExists(c.bucket)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		if err := c.client.MakeBucket(c.bucket, c.region); err != nil {
+			return err
+		}
+	}
+	_, err = c.client.PutObject(c.bucket, name, bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{})
+	return err
+}
+
+func (c *Minio) GetBlob(name string) ([]byte, error) {
+	obj, err := c.client.GetObject(c.bucket, name, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Close()
+	data, err := ioutil.ReadAll(obj)
+	if err != nil && minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return nil, ErrNotFound
+	}
+	return data, err
+}
+
+func (c *Minio) DeleteBlob(name string) error {
+	return c.client.RemoveObject(c.bucket, name)
+}
+
+func (c *Minio) ListBlobs(suffix string) ([]string, error) {
+	exists, err := c.client.BucketExists(c.bucket)
+	if err != nil || !exists {
+		return nil, err
+	}
+
+	doneCh := make(chan struct{})
+	defer close(doneCh)
+
+	var names []string
+	for info := range c.client.ListObjectsV2(c.bucket, "", false, doneCh) {
+		if info.Err != nil {
+			return nil, info.Err
+		}
+		if strings.HasSuffix(info.Key, suffix) {
+			names = append(names, info.Key)
+		}
+	}
+	return names, nil
+}
@@ -0,0 +1,291 @@
+package cache
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/fsnotify/fsnotify"
+	minio "github.com/minio/minio-go"
+)
+
+// Watcher is implemented by caches that can report entries that are added or
+// removed by other processes, such as tools that write torrent files straight
+// into the cache.
+type Watcher interface {
+	// Watch sends a Change for each entry that is added to or removed from
+	// the cache, until done is closed. Changes made by the cache itself are
+	// reported too.
+	Watch(done <-chan struct{}) (<-chan Change, error)
+}
+
+type ChangeType int
+
+const (
+	EntryAdded ChangeType = iota
+	EntryRemoved
+)
+
+func (t ChangeType) String() string {
+	switch t {
+	case EntryAdded:
+		return "added"
+	case EntryRemoved:
+		return "removed"
+	default:
+		return "unknown"
+	}
+}
+
+// Change is an entry that was added to or removed from a cache.
+type Change struct {
+	Type     ChangeType
+	InfoHash metainfo.Hash
+
+	// Spec is the torrent spec of added entries.
+	Spec *torrent.TorrentSpec
+}
+
+var _ Watcher = &Directory{}
+
+// Watch watches the directory for torrent files with fsnotify. Files are
+// loaded when they are created or written, so files that are still being
+// written are reported once they can be parsed.
+func (c *Directory) Watch(done <-chan struct{}) (<-chan Change, error) {
+	if err := os.MkdirAll(c.Directory, 0750); err != nil {
+		return nil, err
+	}
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	if err := w.Add(c.Directory); err != nil {
+		w.Close()
+		return nil, err
+	}
+
+	// Removed files can't be read, so remember the info hash of each file.
+	names := make(map[string]metainfo.Hash)
+	if entries, err := c.ListBlobs(".torrent"); err == nil {
+		for _, name := range entries {
+			if mi, err := c.loadFile(name); err == nil {
+				names[name] = mi.HashInfoBytes()
+			}
+		}
+	}
+
+	changes := make(chan Change)
+	go func() {
+		defer close(changes)
+		defer w.Close()
+		for {
+			select {
+			case <-done:
+				return
+			case err := <-w.Errors:
+				log.Printf("error watching cache directory %s: %s", c.Directory, err)
+			case e := <-w.Events:
+				name := filepath.Base(e.Name)
+				if !strings.HasSuffix(name, ".torrent") {
+					continue
+				}
+				var change Change
+				if e.Op&(fsnotify.Create|fsnotify.Write) != 0 {
+					mi, err := c.loadFile(name)
+					if err != nil {
+						continue
+					}
+					names[name] = mi.HashInfoBytes()
+					change = Change{Type: EntryAdded, InfoHash: mi.HashInfoBytes(), Spec: torrent.TorrentSpecFromMetaInfo(mi)}
+				} else if e.Op&(fsnotify.Remove|fsnotify.Rename) != 0 {
+					h, ok := names[name]
+					if !ok {
+						continue
+					}
+					delete(names, name)
+					change = Change{Type: EntryRemoved, InfoHash: h}
+				} else {
+					continue
+				}
+				select {
+				case changes <- change:
+				case <-done:
+					return
+				}
+			}
+		}
+	}()
+	return changes, nil
+}
+
+var _ Watcher = &Minio{}
+
+// Watch polls the bucket for torrent objects every PollInterval, since S3 has
+// no portable change notifications.
+func (c *Minio) Watch(done <-chan struct{}) (<-chan Change, error) {
+	interval := c.PollInterval
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+
+	type object struct {
+		etag string
+		hash metainfo.Hash
+	}
+	objects := make(map[string]object)
+
+	// poll lists the bucket and returns the changes since the last poll.
+	poll := func(report bool) []Change {
+		doneCh := make(chan struct{})
+		defer close(doneCh)
+
+		var changes []Change
+		seen := make(map[string]bool)
+		for info := range c.client.ListObjectsV2(c.bucket, "", false, doneCh) {
+			if info.Err != nil {
+				if minio.ToErrorResponse(info.Err).Code != "NoSuchBucket" {
+					log.Printf("error polling cache bucket %s: %s", c.bucket, info.Err)
+				}
+				return nil
+			}
+			if !strings.HasSuffix(info.Key, ".torrent") {
+				continue
+			}
+			seen[info.Key] = true
+			if o, ok := objects[info.Key]; ok && o.etag == info.ETag {
+				continue
+			}
+			mi, err := c.loadObject(info.Key)
+			if err != nil {
+				continue
+			}
+			objects[info.Key] = object{info.ETag, mi.HashInfoBytes()}
+			changes = append(changes, Change{Type: EntryAdded, InfoHash: mi.HashInfoBytes(), Spec: torrent.TorrentSpecFromMetaInfo(mi)})
+		}
+		for key, o := range objects {
+			if !seen[key] {
+				delete(objects, key)
+				changes = append(changes, Change{Type: EntryRemoved, InfoHash: o.hash})
+			}
+		}
+		if !report {
+			return nil
+		}
+		return changes
+	}
+	poll(false)
+
+	changes := make(chan Change)
+	go func() {
+		defer close(changes)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				for _, change := range poll(true) {
+					select {
+					case changes <- change:
+					case <-done:
+						return
+					}
+				}
+			}
+		}
+	}()
+	return changes, nil
+}
+
+var _ Watcher = &Encrypted{}
+
+// Watch polls the store for sealed blobs every PollInterval. The watchers of
+// the store can't be used, since they only report torrent files and blob names
+// don't reveal info hashes. Only blobs that appear are opened, and a torrent
+// is only reported as removed once none of its blobs are left, so rotating
+// keys doesn't remove torrents.
+func (c *Encrypted) Watch(done <-chan struct{}) (<-chan Change, error) {
+	interval := c.PollInterval
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+	hashes := make(map[string]metainfo.Hash)
+
+	// poll lists the store and returns the changes since the last poll.
+	poll := func() ([]Change, error) {
+		names, err := c.store.ListBlobs(sealedSuffix)
+		if err != nil {
+			return nil, err
+		}
+		var changes []Change
+		seen := make(map[string]bool)
+		for _, name := range names {
+			seen[name] = true
+			if _, ok := hashes[name]; ok {
+				continue
+			}
+			// Blobs that can't be opened yet, e.g. because they are still
+			// being written, are tried again on the next poll.
+			entry, _, err := c.get(name)
+			if err != nil {
+				continue
+			}
+			mi, err := parseMetainfo(entry.Metainfo)
+			if err != nil {
+				continue
+			}
+			hashes[name] = mi.HashInfoBytes()
+			changes = append(changes, Change{Type: EntryAdded, InfoHash: mi.HashInfoBytes(), Spec: torrent.TorrentSpecFromMetaInfo(mi)})
+		}
+		current := make(map[metainfo.Hash]bool)
+		for name, h := range hashes {
+			if seen[name] {
+				current[h] = true
+			}
+		}
+		for name, h := range hashes {
+			if !seen[name] {
+				delete(hashes, name)
+				if !current[h] {
+					current[h] = true
+					changes = append(changes, Change{Type: EntryRemoved, InfoHash: h})
+				}
+			}
+		}
+		return changes, nil
+	}
+	if _, err := poll(); err != nil {
+		return nil, err
+	}
+
+	changes := make(chan Change)
+	go func() {
+		defer close(changes)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				polled, err := poll()
+				if err != nil {
+					log.Printf("error polling encrypted cache: %s", err)
+				}
+				for _, change := range polled {
+					select {
+					case changes <- change:
+					case <-done:
+						return
+					}
+				}
+			}
+		}
+	}()
+	return changes, nil
+}
@@ -0,0 +1,89 @@
+package cache_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/joelanford/torrential/cache"
+)
+
+func nextChange(t *testing.T, changes <-chan cache.Change) cache.Change {
+	select {
+	case change, ok := <-changes:
+		if !ok {
+			t.Fatal("changes closed")
+		}
+		return change
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for change")
+	}
+	return cache.Change{}
+}
+
+func TestDirectoryWatch(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	done := make(chan struct{})
+	changes, err := cache.NewDirectory(dir).Watch(done)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, sampleInfoHash+".torrent")
+	copyFile(t, "../testdata/sample.torrent", path)
+	change := nextChange(t, changes)
+	assert.Equal(t, cache.EntryAdded, change.Type)
+	assert.Equal(t, sampleInfoHash, change.InfoHash.HexString())
+	if assert.NotNil(t, change.Spec) {
+		assert.Equal(t, "sample.txt", change.Spec.DisplayName)
+	}
+
+	// The file may be reported again while it is written, until it is
+	// removed.
+	assert.NoError(t, os.Remove(path))
+	for change.Type != cache.EntryRemoved {
+		change = nextChange(t, changes)
+	}
+	assert.Equal(t, sampleInfoHash, change.InfoHash.HexString())
+
+	close(done)
+	for range changes {
+	}
+}
+
+func TestEncryptedWatch(t *testing.T) {
+	store := cache.NewMemory()
+	c := cache.NewEncrypted(store, keyring(t, "old"))
+	c.PollInterval = 10 * time.Millisecond
+	done := make(chan struct{})
+	defer close(done)
+	changes, err := c.Watch(done)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.NoError(t, c.SaveMetainfo(loadSample(t)))
+	change := nextChange(t, changes)
+	assert.Equal(t, cache.EntryAdded, change.Type)
+	assert.Equal(t, sampleInfoHash, change.InfoHash.HexString())
+
+	// Rotating the key replaces the blob, which isn't a removal.
+	c2 := cache.NewEncrypted(store, keyring(t, "new", "old"))
+	_, err = c2.Rotate()
+	assert.NoError(t, err)
+	change = nextChange(t, changes)
+	assert.Equal(t, cache.EntryAdded, change.Type)
+
+	names, err := store.ListBlobs(".sealed")
+	if assert.NoError(t, err) && assert.Len(t, names, 1) {
+		assert.NoError(t, store.DeleteBlob(names[0]))
+	}
+	change = nextChange(t, changes)
+	assert.Equal(t, cache.EntryRemoved, change.Type)
+	assert.Equal(t, sampleInfoHash, change.InfoHash.HexString())
+}
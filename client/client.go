@@ -0,0 +1,230 @@
+// Package client is a client for the REST API served by torrential.Handler.
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/joelanford/torrential"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultReconnectDelay    = time.Second
+	defaultMaxReconnectDelay = 30 * time.Second
+)
+
+// Client calls the API of a torrential server. Its methods mirror those of
+// torrential.Service.
+type Client struct {
+	baseURL *url.URL
+
+	// HTTPClient is used for API requests. It defaults to
+	// http.DefaultClient.
+	HTTPClient *http.Client
+
+	// Token is sent as a bearer token with each request, for servers that
+	// use torrential.TokenAuth. For servers that use torrential.BasicAuth,
+	// the user and password can be set in the base URL instead.
+	Token string
+
+	// Dialer is used to open event streams. It defaults to
+	// websocket.DefaultDialer.
+	Dialer *websocket.Dialer
+
+	// ReconnectDelay is the delay before an event stream reconnects after
+	// its connection is lost. It doubles after each failed attempt, up to
+	// MaxReconnectDelay.
+	ReconnectDelay    time.Duration
+	MaxReconnectDelay time.Duration
+
+	// StreamError is called with the errors of event streams, like failed
+	// reconnects and events that can't be decoded. If nil, they are logged.
+	StreamError func(error)
+}
+
+// New returns a client for the torrential API at baseURL, which is the URL of
+// the server including the base path of torrential.Handler, e.g.
+// http://localhost:8080/.
+func New(baseURL string) (*Client, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid base URL")
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, errors.Errorf("invalid base URL %q: scheme must be http or https", baseURL)
+	}
+	return &Client{
+		baseURL:           u,
+		HTTPClient:        http.DefaultClient,
+		Dialer:            websocket.DefaultDialer,
+		ReconnectDelay:    defaultReconnectDelay,
+		MaxReconnectDelay: defaultMaxReconnectDelay,
+	}, nil
+}
+
+func (c *Client) Torrents() ([]torrential.Torrent, error) {
+	var res torrentsResult
+	if err := c.do("GET", c.url(nil, "torrents"), "", nil, &res, nil); err != nil {
+		return nil, err
+	}
+	return res.Torrents, nil
+}
+
+func (c *Client) Torrent(infoHash string) (*torrential.Torrent, error) {
+	var res torrentResult
+	if err := c.do("GET", c.url(nil, "torrents", infoHash), "", nil, &res, nil); err != nil {
+		return nil, err
+	}
+	return res.Torrent, nil
+}
+
+func (c *Client) AddTorrentReader(torrentReader io.Reader, labels ...string) (*torrential.Torrent, error) {
+	return c.addTorrent("application/x-bittorrent", torrentReader, labels)
+}
+
+func (c *Client) AddTorrentURL(torrentURL string, labels ...string) (*torrential.Torrent, error) {
+	return c.addTorrent("application/x-url", strings.NewReader(torrentURL), labels)
+}
+
+func (c *Client) AddMagnetURI(magnetURI string, labels ...string) (*torrential.Torrent, error) {
+	return c.addTorrent("x-scheme-handler/magnet", strings.NewReader(magnetURI), labels)
+}
+
+func (c *Client) addTorrent(contentType string, body io.Reader, labels []string) (*torrential.Torrent, error) {
+	var res torrentResult
+	u := c.url(url.Values{"label": labels}, "torrents")
+	if err := c.do("POST", u, contentType, body, &res, addTorrentError); err != nil {
+		return nil, err
+	}
+	return res.Torrent, nil
+}
+
+func (c *Client) Labels(infoHash string) ([]string, error) {
+	var res labelsResult
+	if err := c.do("GET", c.url(nil, "torrents", infoHash, "labels"), "", nil, &res, nil); err != nil {
+		return nil, err
+	}
+	return res.Labels, nil
+}
+
+func (c *Client) SetLabels(infoHash string, labels []string) error {
+	if labels == nil {
+		labels = []string{}
+	}
+	data, err := json.Marshal(labelsResult{labels})
+	if err != nil {
+		return err
+	}
+	return c.do("PUT", c.url(nil, "torrents", infoHash, "labels"), "application/json", bytes.NewReader(data), nil, nil)
+}
+
+func (c *Client) Pause(infoHash string) (*torrential.Torrent, error) {
+	var res torrentResult
+	if err := c.do("POST", c.url(nil, "torrents", infoHash, "pause"), "", nil, &res, nil); err != nil {
+		return nil, err
+	}
+	return res.Torrent, nil
+}
+
+func (c *Client) Resume(infoHash string) (*torrential.Torrent, error) {
+	var res torrentResult
+	if err := c.do("POST", c.url(nil, "torrents", infoHash, "resume"), "", nil, &res, nil); err != nil {
+		return nil, err
+	}
+	return res.Torrent, nil
+}
+
+func (c *Client) SetPriority(infoHash string, priority torrential.Priority) (*torrential.Torrent, error) {
+	data, err := json.Marshal(priorityResult{priority})
+	if err != nil {
+		return nil, err
+	}
+	var res torrentResult
+	if err := c.do("PUT", c.url(nil, "torrents", infoHash, "priority"), "application/json", bytes.NewReader(data), &res, nil); err != nil {
+		return nil, err
+	}
+	return res.Torrent, nil
+}
+
+func (c *Client) Drop(infoHash string, deleteFiles bool) error {
+	var query url.Values
+	if deleteFiles {
+		query = url.Values{"deleteFiles": {"true"}}
+	}
+	return c.do("DELETE", c.url(query, "torrents", infoHash), "", nil, nil, deleteError)
+}
+
+// url returns the URL of the API path made of the given elements.
+func (c *Client) url(query url.Values, elem ...string) *url.URL {
+	u := *c.baseURL
+	for i := range elem {
+		elem[i] = url.PathEscape(elem[i])
+	}
+	u.Path = path.Join(append([]string{"/", u.Path}, elem...)...)
+	u.RawPath = ""
+	u.RawQuery = query.Encode()
+	return &u
+}
+
+// do sends a request and decodes the result into out, if it is not nil.
+// Error responses are decoded and mapped to typed errors with statusError.
+func (c *Client) do(method string, u *url.URL, contentType string, body io.Reader, out interface{}, serverErr func(error) error) error {
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return err
+	}
+	req.Header = c.header()
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "%s %s", method, u.Path)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return decodeError(resp, serverErr)
+	}
+	if out == nil {
+		io.Copy(ioutil.Discard, resp.Body)
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return errors.Wrap(err, "could not decode response")
+	}
+	return nil
+}
+
+// header returns the headers that are sent with each request.
+func (c *Client) header() http.Header {
+	h := make(http.Header)
+	if c.Token != "" {
+		h.Set("Authorization", "Bearer "+c.Token)
+	}
+	return h
+}
+
+// decodeError returns the error of an error response. Responses that are not
+// an errorResult, such as those of unsupported methods, are described by their
+// body or status.
+func decodeError(resp *http.Response, serverErr func(error) error) error {
+	data, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	var res errorResult
+	msg := strings.TrimSpace(string(data))
+	if err := json.Unmarshal(data, &res); err == nil && res.Error != "" {
+		msg = res.Error
+	}
+	return statusError(resp.StatusCode, msg, serverErr)
+}
@@ -0,0 +1,122 @@
+package client_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/joelanford/torrential"
+	"github.com/joelanford/torrential/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClient(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/api/torrents":
+			w.Write([]byte(`{"torrents":[{"infoHash":"abc","name":"sample.txt","files":[{"path":"sample.txt","length":20}]}]}`))
+		case r.Method == "GET" && r.URL.Path == "/api/torrents/secret":
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":"invalid or missing credentials"}`))
+		case r.Method == "GET" && r.URL.Path == "/api/torrents/missing":
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":"torrent not found"}`))
+		case r.Method == "POST" && r.URL.Path == "/api/torrents":
+			body, _ := ioutil.ReadAll(r.Body)
+			assert.Equal(t, "x-scheme-handler/magnet", r.Header.Get("Content-Type"))
+			assert.Equal(t, "magnet:?xt=urn:btih:abc", string(body))
+			assert.Equal(t, []string{"a", "b"}, r.URL.Query()["label"])
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte(`{"error":"torrent already exists"}`))
+		case r.Method == "DELETE" && r.URL.Path == "/api/torrents/abc":
+			assert.Equal(t, "true", r.URL.Query().Get("deleteFiles"))
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`{"error":"could not delete files"}`))
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+	defer srv.Close()
+
+	c, err := client.New(srv.URL + "/api/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	torrents, err := c.Torrents()
+	assert.NoError(t, err)
+	if assert.Len(t, torrents, 1) {
+		assert.Equal(t, "abc", torrents[0].InfoHash)
+		assert.Equal(t, 20, torrents[0].Files[0].Length)
+	}
+
+	_, err = c.Torrent("missing")
+	assert.True(t, client.IsNotFound(err))
+	assert.EqualError(t, err, "torrent not found")
+
+	_, err = c.Torrent("secret")
+	assert.True(t, client.IsUnauthorized(err))
+
+	_, err = c.AddMagnetURI("magnet:?xt=urn:btih:abc", "a", "b")
+	assert.True(t, client.IsExists(err))
+
+	err = c.Drop("abc", true)
+	if e, ok := err.(interface {
+		IsDeleteError() bool
+	}); assert.True(t, ok) {
+		assert.True(t, e.IsDeleteError())
+	}
+}
+
+func TestEventsReconnect(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	var connections int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/torrents/missing") {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":"torrent not found"}`))
+			return
+		}
+		atomic.AddInt32(&connections, 1)
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+		// Each connection sends one event and then drops.
+		ws.WriteMessage(websocket.TextMessage, []byte(`{"event":{"type":"added","torrent":{"infoHash":"abc"}}}`))
+	}))
+	defer srv.Close()
+
+	c, err := client.New(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.ReconnectDelay = time.Millisecond
+
+	done := make(chan struct{})
+	events := c.Events(done)
+	for i := 0; i < 3; i++ {
+		select {
+		case e := <-events:
+			assert.Equal(t, torrential.Added, e.Type)
+			assert.Equal(t, "abc", e.Torrent.InfoHash)
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for event")
+		}
+	}
+	close(done)
+	for range events {
+	}
+	assert.True(t, atomic.LoadInt32(&connections) >= 3)
+
+	// Streams of dropped torrents end instead of reconnecting.
+	_, ok := <-c.TorrentEvents("missing", nil)
+	assert.False(t, ok)
+}
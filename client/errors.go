@@ -0,0 +1,123 @@
+package client
+
+import (
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+// The error types mirror those of the torrential package, so that errors
+// returned by the client can be checked the same way as errors returned by
+// torrential.Service, e.g. with an IsNotFound method.
+
+type notFoundErr struct {
+	error
+}
+type existsErr struct {
+	error
+}
+type parseErr struct {
+	error
+}
+type addTorrentErr struct {
+	error
+}
+type deleteErr struct {
+	error
+}
+type tooLargeErr struct {
+	error
+}
+type forbiddenErr struct {
+	error
+}
+type unauthorizedErr struct {
+	error
+}
+
+func (e notFoundErr) IsNotFound() bool {
+	return true
+}
+func (e existsErr) IsExists() bool {
+	return true
+}
+func (e parseErr) IsParseError() bool {
+	return true
+}
+func (e addTorrentErr) IsAddTorrentError() bool {
+	return true
+}
+func (e deleteErr) IsDeleteError() bool {
+	return true
+}
+func (e tooLargeErr) IsTooLarge() bool {
+	return true
+}
+func (e forbiddenErr) IsForbidden() bool {
+	return true
+}
+func (e unauthorizedErr) IsUnauthorized() bool {
+	return true
+}
+
+// IsNotFound reports whether err is caused by a torrent or other resource
+// that does not exist.
+func IsNotFound(err error) bool {
+	e, ok := errors.Cause(err).(interface {
+		IsNotFound() bool
+	})
+	return ok && e.IsNotFound()
+}
+
+// IsExists reports whether err is caused by a torrent that already exists.
+func IsExists(err error) bool {
+	e, ok := errors.Cause(err).(interface {
+		IsExists() bool
+	})
+	return ok && e.IsExists()
+}
+
+// IsUnauthorized reports whether err is caused by missing or invalid
+// credentials, e.g. a Client without the Token the server requires.
+func IsUnauthorized(err error) bool {
+	e, ok := errors.Cause(err).(interface {
+		IsUnauthorized() bool
+	})
+	return ok && e.IsUnauthorized()
+}
+
+// statusError returns the error for a response with the given status code and
+// error message. Server errors are wrapped with serverErr, since the status
+// code alone doesn't tell what failed.
+func statusError(code int, msg string, serverErr func(error) error) error {
+	if msg == "" {
+		msg = http.StatusText(code)
+	}
+	err := errors.New(msg)
+	switch {
+	case code == http.StatusNotFound:
+		return notFoundErr{err}
+	case code == http.StatusConflict:
+		return existsErr{err}
+	case code == http.StatusBadRequest:
+		return parseErr{err}
+	case code == http.StatusRequestEntityTooLarge:
+		return tooLargeErr{err}
+	case code == http.StatusForbidden:
+		return forbiddenErr{err}
+	case code == http.StatusUnauthorized:
+		return unauthorizedErr{err}
+	case code >= 500 && serverErr != nil:
+		return serverErr(err)
+	default:
+		return errors.Errorf("%s (status %d)", msg, code)
+	}
+}
+
+func addTorrentError(err error) error {
+	return addTorrentErr{err}
+}
+
+func deleteError(err error) error {
+	return deleteErr{err}
+}
@@ -0,0 +1,120 @@
+package client
+
+import (
+	"encoding/json"
+	"log"
+	"net/url"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/pkg/errors"
+)
+
+// Events streams the events of all torrents until done is closed. The stream
+// reconnects when its connection is lost, so events sent while it is
+// disconnected are missed.
+func (c *Client) Events(done <-chan struct{}) <-chan Event {
+	return c.stream(done, "torrents", "events")
+}
+
+// TorrentEvents streams the events of the given torrent until done is closed
+// or the torrent is dropped. Like Events, it reconnects when its connection is
+// lost.
+func (c *Client) TorrentEvents(infoHash string, done <-chan struct{}) <-chan Event {
+	return c.stream(done, "torrents", infoHash, "events")
+}
+
+func (c *Client) stream(done <-chan struct{}, elem ...string) <-chan Event {
+	u := c.url(url.Values{"format": {"json"}}, elem...)
+	if u.Scheme == "https" {
+		u.Scheme = "wss"
+	} else {
+		u.Scheme = "ws"
+	}
+
+	events := make(chan Event)
+	go func() {
+		defer close(events)
+		delay := c.ReconnectDelay
+		for {
+			connected, err := c.streamOnce(u, events, done)
+			if IsNotFound(err) {
+				// The torrent was dropped, so there is nothing to reconnect to.
+				return
+			}
+			if err != nil {
+				c.streamError(errors.Wrapf(err, "error streaming events from %s", u.Path))
+			}
+			if connected {
+				delay = c.ReconnectDelay
+			}
+			select {
+			case <-done:
+				return
+			case <-time.After(delay):
+			}
+			if delay *= 2; delay > c.MaxReconnectDelay {
+				delay = c.MaxReconnectDelay
+			}
+		}
+	}()
+	return events
+}
+
+func (c *Client) streamError(err error) {
+	if c.StreamError != nil {
+		c.StreamError(err)
+		return
+	}
+	log.Print(err)
+}
+
+// streamOnce connects to the event stream and sends its events until the
+// connection is closed or done is closed. It reports whether it connected.
+func (c *Client) streamOnce(u *url.URL, events chan<- Event, done <-chan struct{}) (bool, error) {
+	ws, resp, err := c.Dialer.Dial(u.String(), c.header())
+	if err != nil {
+		if err == websocket.ErrBadHandshake && resp != nil {
+			defer resp.Body.Close()
+			return false, decodeError(resp, nil)
+		}
+		return false, err
+	}
+
+	// Closing the connection unblocks ReadMessage when done is closed.
+	closed := make(chan struct{})
+	defer close(closed)
+	go func() {
+		select {
+		case <-done:
+			ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+		case <-closed:
+		}
+		ws.Close()
+	}()
+
+	for {
+		_, data, err := ws.ReadMessage()
+		if err != nil {
+			select {
+			case <-done:
+				return true, nil
+			default:
+			}
+			if websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+				return true, nil
+			}
+			return true, err
+		}
+		var res eventResult
+		if err := json.Unmarshal(data, &res); err != nil {
+			c.streamError(errors.Wrapf(err, "error decoding event %s", data))
+			continue
+		}
+		select {
+		case events <- res.Event:
+		case <-done:
+			return true, nil
+		}
+	}
+}
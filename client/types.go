@@ -0,0 +1,43 @@
+package client
+
+import (
+	"github.com/joelanford/torrential"
+	"github.com/joelanford/torrential/cache"
+)
+
+// Event is a torrent event as sent by the torrential event streams. Unlike a
+// torrential.Event, its Torrent is nil for events that are not about a
+// torrent.
+type Event struct {
+	Type    torrential.EventType `json:"type"`
+	Torrent *torrential.Torrent  `json:"torrent"`
+	File    *torrential.File     `json:"file,omitempty"`
+	Piece   *int                 `json:"piece,omitempty"`
+
+	// Report is the cache load report, for CacheLoaded events.
+	Report *cache.LoadReport `json:"report,omitempty"`
+}
+
+type torrentResult struct {
+	Torrent *torrential.Torrent `json:"torrent"`
+}
+
+type torrentsResult struct {
+	Torrents []torrential.Torrent `json:"torrents"`
+}
+
+type eventResult struct {
+	Event Event `json:"event"`
+}
+
+type labelsResult struct {
+	Labels []string `json:"labels"`
+}
+
+type priorityResult struct {
+	Priority torrential.Priority `json:"priority"`
+}
+
+type errorResult struct {
+	Error string `json:"error"`
+}
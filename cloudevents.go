@@ -0,0 +1,120 @@
+package torrential
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	uuid "github.com/satori/go.uuid"
+)
+
+// EventFormat selects how events are encoded for webhooks and event streams.
+type EventFormat string
+
+const (
+	// FormatJSON encodes events as {"event": {...}}.
+	FormatJSON EventFormat = "json"
+
+	// FormatCloudEvents encodes events as CloudEvents 1.0 envelopes, using
+	// the structured content mode for webhooks.
+	FormatCloudEvents EventFormat = "cloudevents"
+
+	// FormatCloudEventsBinary encodes webhook events in the CloudEvents 1.0
+	// binary content mode, where the attributes are sent as ce-* headers and
+	// the body is the event data. Event streams have no binary mode, so they
+	// use the structured mode instead.
+	FormatCloudEventsBinary EventFormat = "cloudevents-binary"
+)
+
+const (
+	cloudEventsSpecVersion = "1.0"
+	cloudEventsTypePrefix  = "io.torrential."
+	cloudEventsMediaType   = "application/cloudevents+json"
+
+	defaultEventSource = "/torrential"
+)
+
+// ParseEventFormat returns the EventFormat with the given name. An empty name
+// returns FormatJSON.
+func ParseEventFormat(name string) (EventFormat, error) {
+	switch f := EventFormat(name); f {
+	case "":
+		return FormatJSON, nil
+	case FormatJSON, FormatCloudEvents, FormatCloudEventsBinary:
+		return f, nil
+	default:
+		return "", parseErr{errors.Errorf("unknown event format %q", name)}
+	}
+}
+
+func (f EventFormat) isCloudEvents() bool {
+	return f == FormatCloudEvents || f == FormatCloudEventsBinary
+}
+
+// CloudEvent is a CloudEvents 1.0 envelope for an Event.
+type CloudEvent struct {
+	SpecVersion     string          `json:"specversion"`
+	Type            string          `json:"type"`
+	Source          string          `json:"source"`
+	ID              string          `json:"id"`
+	Time            time.Time       `json:"time"`
+	Subject         string          `json:"subject,omitempty"`
+	DataContentType string          `json:"datacontenttype"`
+	Data            json.RawMessage `json:"data"`
+}
+
+// NewCloudEvent wraps the event in a CloudEvents envelope. The type is the
+// event type prefixed with "io.torrential.", e.g. io.torrential.downloadDone,
+// and the subject is the torrent info hash, if the event has a torrent.
+func NewCloudEvent(e Event, source, id string, t time.Time) (*CloudEvent, error) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+	return &CloudEvent{
+		SpecVersion:     cloudEventsSpecVersion,
+		Type:            cloudEventsTypePrefix + e.Type.String(),
+		Source:          source,
+		ID:              id,
+		Time:            t.UTC(),
+		Subject:         e.InfoHash(),
+		DataContentType: "application/json",
+		Data:            data,
+	}, nil
+}
+
+// binaryHeaders returns the headers that carry the event attributes in the
+// binary content mode.
+func (ce *CloudEvent) binaryHeaders() http.Header {
+	h := http.Header{}
+	h.Set("ce-specversion", ce.SpecVersion)
+	h.Set("ce-type", ce.Type)
+	h.Set("ce-source", ce.Source)
+	h.Set("ce-id", ce.ID)
+	h.Set("ce-time", ce.Time.Format(time.RFC3339Nano))
+	if ce.Subject != "" {
+		h.Set("ce-subject", ce.Subject)
+	}
+	return h
+}
+
+// EncodeEvent encodes an event in the given format. CloudEvents formats are
+// always encoded in the structured content mode, with a random ID.
+func EncodeEvent(e Event, format EventFormat, source string) ([]byte, error) {
+	_, data, err := encodeEvent(e, format, source)
+	return data, err
+}
+
+func encodeEvent(e Event, format EventFormat, source string) (string, []byte, error) {
+	if !format.isCloudEvents() {
+		data, err := json.Marshal(eventResult{e})
+		return "", data, err
+	}
+	ce, err := NewCloudEvent(e, source, uuid.NewV4().String(), time.Now())
+	if err != nil {
+		return "", nil, err
+	}
+	data, err := json.Marshal(ce)
+	return ce.ID, data, err
+}
@@ -0,0 +1,50 @@
+package torrential_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/joelanford/torrential"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewCloudEvent(t *testing.T) {
+	tor, err := c.AddTorrentFromFile("testdata/sample.torrent")
+	assert.NoError(t, err)
+	defer tor.Drop()
+
+	now := time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC)
+	ce, err := torrential.NewCloudEvent(torrential.Event{Type: torrential.DownloadDone, Torrent: torrential.NewTorrent(tor)}, "/torrential", "event-1", now)
+	assert.NoError(t, err)
+	assert.Equal(t, "1.0", ce.SpecVersion)
+	assert.Equal(t, "io.torrential.downloadDone", ce.Type)
+	assert.Equal(t, "/torrential", ce.Source)
+	assert.Equal(t, "event-1", ce.ID)
+	assert.Equal(t, now, ce.Time)
+	assert.Equal(t, "d0d14c926e6e99761a2fdcff27b403d96376eff6", ce.Subject)
+	assert.Equal(t, "application/json", ce.DataContentType)
+
+	var data struct {
+		Type    string `json:"type"`
+		Torrent struct {
+			InfoHash string `json:"infoHash"`
+		} `json:"torrent"`
+	}
+	assert.NoError(t, json.Unmarshal(ce.Data, &data))
+	assert.Equal(t, "downloadDone", data.Type)
+	assert.Equal(t, "d0d14c926e6e99761a2fdcff27b403d96376eff6", data.Torrent.InfoHash)
+}
+
+func TestParseEventFormat(t *testing.T) {
+	f, err := torrential.ParseEventFormat("")
+	assert.NoError(t, err)
+	assert.Equal(t, torrential.FormatJSON, f)
+
+	f, err = torrential.ParseEventFormat("cloudevents-binary")
+	assert.NoError(t, err)
+	assert.Equal(t, torrential.FormatCloudEventsBinary, f)
+
+	_, err = torrential.ParseEventFormat("xml")
+	assert.Error(t, err)
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/joelanford/torrential/cache"
+)
+
+const cacheUsage = `Usage: torrential cache <command> [flags]
+
+Commands:
+  migrate  Copy all torrents from one cache to another
+  export   Write all torrents in a cache to an archive
+  import   Read the torrents in an archive into a cache
+  rotate   Seal the entries of an encrypted cache with its primary key
+
+Caches are given as [dir:]path, bolt:path or
+minio:http[s]://[access:secret@]host/bucket. Caches with a key file are
+encrypted with the keys in the file. The export, import and rotate commands
+use the keys in $TORRENTIAL_CACHE_KEY if no key file is given; migrate only
+uses key files, since it opens two caches.
+`
+
+// runCache runs the cache subcommands.
+func runCache(args []string) {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, cacheUsage)
+		os.Exit(2)
+	}
+	switch args[0] {
+	case "migrate":
+		cacheMigrate(args[1:])
+	case "export":
+		cacheExport(args[1:])
+	case "import":
+		cacheImport(args[1:])
+	case "rotate":
+		cacheRotate(args[1:])
+	default:
+		fmt.Fprint(os.Stderr, cacheUsage)
+		os.Exit(2)
+	}
+}
+
+func cacheMigrate(args []string) {
+	fs := flag.NewFlagSet("cache migrate", flag.ExitOnError)
+	from := fs.String("from", "", "Cache to copy torrents from")
+	to := fs.String("to", "", "Cache to copy torrents to")
+	fromKeyFile := fs.String("from-key-file", "", "Key file of the cache to copy torrents from, if it is encrypted")
+	toKeyFile := fs.String("to-key-file", "", "Key file of the cache to copy torrents to, to encrypt it")
+	fs.Parse(args)
+	if *from == "" || *to == "" {
+		log.Fatal("both --from and --to are required")
+	}
+
+	src := mustOpenCache(*from, *fromKeyFile, "")
+	defer closeCache(src)
+	dst := mustOpenCache(*to, *toKeyFile, "")
+	defer closeCache(dst)
+
+	report, err := cache.Migrate(src, dst)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, q := range report.Source.Quarantined {
+		log.Printf("skipped entry %s that can't be parsed: %s", q.Name, q.Error)
+	}
+	log.Printf("migrated %d torrents from %s to %s", report.Copied, *from, *to)
+}
+
+func cacheExport(args []string) {
+	fs := flag.NewFlagSet("cache export", flag.ExitOnError)
+	from := fs.String("from", "", "Cache to export torrents from")
+	file := fs.String("file", "-", "Archive to write, or - for stdout")
+	keyFile := fs.String("key-file", "", "Key file of the cache, if it is encrypted")
+	fs.Parse(args)
+	if *from == "" {
+		log.Fatal("--from is required")
+	}
+
+	c := mustOpenCache(*from, *keyFile, cacheKeyEnv)
+	defer closeCache(c)
+
+	if *file == "-" {
+		n, err := cache.Export(c, os.Stdout)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("exported %d torrents from %s", n, *from)
+		return
+	}
+
+	// The archive is written to a temporary file that is only renamed once
+	// the export succeeds, so that a failed export doesn't leave a partial
+	// archive behind or replace an earlier one.
+	f, err := ioutil.TempFile(filepath.Dir(*file), filepath.Base(*file)+".tmp")
+	if err != nil {
+		log.Fatal(err)
+	}
+	n, err := cache.Export(c, f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), *file)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		log.Fatal(err)
+	}
+	log.Printf("exported %d torrents from %s", n, *from)
+}
+
+func cacheImport(args []string) {
+	fs := flag.NewFlagSet("cache import", flag.ExitOnError)
+	to := fs.String("to", "", "Cache to import torrents into")
+	file := fs.String("file", "-", "Archive to read, or - for stdin")
+	keyFile := fs.String("key-file", "", "Key file of the cache, if it is encrypted")
+	fs.Parse(args)
+	if *to == "" {
+		log.Fatal("--to is required")
+	}
+
+	c := mustOpenCache(*to, *keyFile, cacheKeyEnv)
+	defer closeCache(c)
+
+	var r io.Reader = os.Stdin
+	if *file != "-" {
+		f, err := os.Open(*file)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		r = f
+	}
+	n, err := cache.Import(c, r)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("imported %d torrents into %s", n, *to)
+}
+
+func cacheRotate(args []string) {
+	fs := flag.NewFlagSet("cache rotate", flag.ExitOnError)
+	spec := fs.String("cache", "", "Encrypted cache to rotate")
+	keyFile := fs.String("key-file", "", "Key file of the cache, with the new primary key first (defaults to the keys in $TORRENTIAL_CACHE_KEY)")
+	fs.Parse(args)
+	if *spec == "" {
+		log.Fatal("--cache is required")
+	}
+
+	c := mustOpenCache(*spec, *keyFile, cacheKeyEnv)
+	defer closeCache(c)
+
+	encrypted, ok := c.(*cache.Encrypted)
+	if !ok {
+		log.Fatal("--key-file or $TORRENTIAL_CACHE_KEY is required")
+	}
+	n, err := encrypted.Rotate()
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("sealed %d entries of %s with the primary key", n, *spec)
+}
+
+// mustOpenCache opens the cache, encrypted with the keys of the key file or,
+// if it is empty, the environment variable env.
+func mustOpenCache(spec, keyFile, env string) cache.Cache {
+	c, err := openCache(spec)
+	if err != nil {
+		log.Fatal(err)
+	}
+	keys, err := loadKeyring(keyFile, env)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if c, err = encryptCache(c, keys); err != nil {
+		log.Fatal(err)
+	}
+	return c
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/joelanford/torrential"
+	"github.com/joelanford/torrential/client"
+)
+
+const clientUsage = `Usage: torrential <command> [flags] [args]
+
+Commands:
+  add    Add torrents from files, URLs or magnet links
+  ls     List active torrents
+  info   Show a torrent and its files
+  rm     Drop torrents
+  watch  Print torrent events as they happen
+  tui    Show a live dashboard of torrents
+
+The commands talk to the torrential instance at --server, which defaults to
+$TORRENTIAL_URL or http://localhost:8080/. If the server requires
+authentication, pass a token with --token or $TORRENTIAL_TOKEN, or a user and
+password in the server URL. Run torrential with no command to start a server.
+`
+
+// clientOptions are the flags shared by the client subcommands.
+type clientOptions struct {
+	server string
+	token  string
+	output string
+}
+
+func (o *clientOptions) register(fs *flag.FlagSet) {
+	server := os.Getenv("TORRENTIAL_URL")
+	if server == "" {
+		server = "http://localhost:8080/"
+	}
+	fs.StringVar(&o.server, "server", server, "URL of the torrential instance, including its HTTP base path")
+	fs.StringVar(&o.token, "token", os.Getenv("TORRENTIAL_TOKEN"), "Bearer token of the torrential instance")
+	fs.StringVar(&o.output, "output", "table", "Output format (table or json)")
+}
+
+func (o *clientOptions) client() *client.Client {
+	if o.output != "table" && o.output != "json" {
+		log.Fatalf("invalid output format %q", o.output)
+	}
+	c, err := client.New(o.server)
+	if err != nil {
+		log.Fatal(err)
+	}
+	c.Token = o.token
+	return c
+}
+
+// isClientCommand reports whether name is a client subcommand.
+func isClientCommand(name string) bool {
+	switch name {
+	case "add", "ls", "info", "rm", "watch", "help":
+		return true
+	}
+	return false
+}
+
+// runClient runs the client subcommands.
+func runClient(name string, args []string) {
+	log.SetFlags(0)
+	switch name {
+	case "add":
+		clientAdd(args)
+	case "ls":
+		clientList(args)
+	case "info":
+		clientInfo(args)
+	case "rm":
+		clientRemove(args)
+	case "watch":
+		clientWatch(args)
+	default:
+		fmt.Fprint(os.Stderr, clientUsage)
+		os.Exit(2)
+	}
+}
+
+func clientAdd(args []string) {
+	var opts clientOptions
+	var labels labelsFlag
+	fs := flag.NewFlagSet("add", flag.ExitOnError)
+	opts.register(fs)
+	fs.Var(&labels, "label", "Label of the added torrents (may be repeated)")
+	args = parseArgs(fs, args)
+	if len(args) == 0 {
+		log.Fatal("usage: torrential add [flags] <file|url|magnet>...")
+	}
+	c := opts.client()
+
+	// Torrents that can't be added are reported, and the others are added
+	// anyway.
+	added := []torrential.Torrent{}
+	failed := false
+	for _, arg := range args {
+		t, err := addTorrent(c, arg, labels)
+		if err != nil {
+			log.Printf("could not add %s: %s", arg, err)
+			failed = true
+			continue
+		}
+		added = append(added, *t)
+	}
+	if opts.output == "json" {
+		printJSON(added)
+	} else if len(added) > 0 {
+		printTorrents(added)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// parseArgs parses the flags of a subcommand, which may come before, between
+// or after its arguments, and returns the arguments. Arguments after -- are
+// not parsed as flags.
+func parseArgs(fs *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		fs.Parse(args)
+		rest := fs.Args()
+		if n := len(args) - len(rest); n > 0 && args[n-1] == "--" {
+			return append(positional, rest...)
+		}
+		if len(rest) == 0 {
+			return positional
+		}
+		// Parse stops at the first argument that isn't a flag.
+		positional = append(positional, rest[0])
+		args = rest[1:]
+	}
+}
+
+// addTorrent adds a torrent from a magnet link, an HTTP URL, a file, or
+// stdin if arg is -.
+func addTorrent(c *client.Client, arg string, labels []string) (*torrential.Torrent, error) {
+	switch {
+	case strings.HasPrefix(arg, "magnet:"):
+		return c.AddMagnetURI(arg, labels...)
+	case strings.HasPrefix(arg, "http://"), strings.HasPrefix(arg, "https://"):
+		return c.AddTorrentURL(arg, labels...)
+	case arg == "-":
+		return c.AddTorrentReader(os.Stdin, labels...)
+	}
+	f, err := os.Open(arg)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return c.AddTorrentReader(f, labels...)
+}
+
+func clientList(args []string) {
+	var opts clientOptions
+	fs := flag.NewFlagSet("ls", flag.ExitOnError)
+	opts.register(fs)
+	if len(parseArgs(fs, args)) > 0 {
+		log.Fatal("usage: torrential ls [flags]")
+	}
+	c := opts.client()
+
+	torrents, err := c.Torrents()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if opts.output == "json" {
+		if torrents == nil {
+			torrents = []torrential.Torrent{}
+		}
+		printJSON(torrents)
+		return
+	}
+	printTorrents(torrents)
+}
+
+func clientInfo(args []string) {
+	var opts clientOptions
+	fs := flag.NewFlagSet("info", flag.ExitOnError)
+	opts.register(fs)
+	args = parseArgs(fs, args)
+	if len(args) != 1 {
+		log.Fatal("usage: torrential info [flags] <hash>")
+	}
+	c := opts.client()
+
+	t, err := c.Torrent(args[0])
+	if err != nil {
+		log.Fatal(err)
+	}
+	if opts.output == "json" {
+		printJSON(t)
+		return
+	}
+	labels, err := c.Labels(t.InfoHash)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "Name:\t%s\n", t.Name)
+	fmt.Fprintf(w, "Info hash:\t%s\n", t.InfoHash)
+	fmt.Fprintf(w, "Labels:\t%s\n", strings.Join(labels, ", "))
+	fmt.Fprintf(w, "Progress:\t%s\n", progress(*t))
+	fmt.Fprintf(w, "Size:\t%s\n", formatBytes(int64(t.Length)))
+	fmt.Fprintf(w, "Pieces:\t%d\n", t.NumPieces)
+	fmt.Fprintf(w, "Peers:\t%d active, %d total\n", t.Stats.ActivePeers, t.Stats.TotalPeers)
+	fmt.Fprintf(w, "Seeding:\t%t\n", t.Seeding)
+	fmt.Fprintf(w, "Magnet:\t%s\n", t.MagnetLink)
+	w.Flush()
+
+	if len(t.Files) > 0 {
+		fmt.Println()
+		w = tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "FILE\tSIZE")
+		for _, f := range t.Files {
+			fmt.Fprintf(w, "%s\t%s\n", f.DisplayPath, formatBytes(int64(f.Length)))
+		}
+		w.Flush()
+	}
+}
+
+func clientRemove(args []string) {
+	var opts clientOptions
+	fs := flag.NewFlagSet("rm", flag.ExitOnError)
+	opts.register(fs)
+	deleteFiles := fs.Bool("delete-files", false, "Also delete the downloaded data of the torrents")
+	args = parseArgs(fs, args)
+	if len(args) == 0 {
+		log.Fatal("usage: torrential rm [flags] <hash>...")
+	}
+	c := opts.client()
+
+	// Like add, torrents that can't be dropped are reported on stderr, and
+	// the JSON output lists the dropped ones.
+	dropped := []string{}
+	failed := false
+	for _, infoHash := range args {
+		if err := c.Drop(infoHash, *deleteFiles); err != nil {
+			log.Printf("could not drop %s: %s", infoHash, err)
+			failed = true
+			continue
+		}
+		dropped = append(dropped, infoHash)
+		if opts.output == "table" {
+			fmt.Printf("dropped %s\n", infoHash)
+		}
+	}
+	if opts.output == "json" {
+		printJSON(dropped)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func clientWatch(args []string) {
+	var opts clientOptions
+	fs := flag.NewFlagSet("watch", flag.ExitOnError)
+	opts.register(fs)
+	args = parseArgs(fs, args)
+	if len(args) > 1 {
+		log.Fatal("usage: torrential watch [flags] [hash]")
+	}
+	c := opts.client()
+
+	var events <-chan client.Event
+	if len(args) == 1 {
+		events = c.TorrentEvents(args[0], nil)
+	} else {
+		events = c.Events(nil)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for e := range events {
+		if opts.output == "json" {
+			enc.Encode(e)
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", time.Now().Format("15:04:05"), e.Type, eventTorrent(e), eventDetail(e))
+		w.Flush()
+	}
+}
+
+// eventTorrent describes the torrent of an event.
+func eventTorrent(e client.Event) string {
+	if e.Torrent == nil {
+		return "-"
+	}
+	if e.Torrent.Name == "" {
+		return e.Torrent.InfoHash
+	}
+	return e.Torrent.InfoHash + " " + e.Torrent.Name
+}
+
+// eventDetail describes the file, piece or cache report of an event.
+func eventDetail(e client.Event) string {
+	switch {
+	case e.File != nil:
+		return e.File.DisplayPath
+	case e.Piece != nil:
+		return fmt.Sprintf("piece %d", *e.Piece)
+	case e.Type == torrential.CacheLoaded && e.Report != nil:
+		return fmt.Sprintf("%d loaded, %d quarantined", e.Report.Loaded, len(e.Report.Quarantined))
+	}
+	return ""
+}
+
+func printTorrents(torrents []torrential.Torrent) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "HASH\tNAME\tPROGRESS\tSIZE\tPEERS\tSEEDING")
+	for _, t := range torrents {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%t\n", t.InfoHash, t.Name, progress(t), formatBytes(int64(t.Length)), t.Stats.ActivePeers, t.Seeding)
+	}
+	w.Flush()
+}
+
+func printJSON(v interface{}) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	os.Stdout.Write(append(data, '\n'))
+}
+
+// progress returns the completed percentage of a torrent, or "-" if its info
+// has not been received yet.
+func progress(t torrential.Torrent) string {
+	if !t.HasInfo || t.Length == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f%%", 100*float64(t.BytesCompleted)/float64(t.Length))
+}
+
+// formatBytes formats n as a number of bytes with a binary unit prefix.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"flag"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/joelanford/torrential"
+)
+
+func TestParseArgs(t *testing.T) {
+	fs := flag.NewFlagSet("rm", flag.ContinueOnError)
+	deleteFiles := fs.Bool("delete-files", false, "")
+	server := fs.String("server", "", "")
+	args := parseArgs(fs, []string{"abc", "--delete-files", "def", "--server", "http://example.com/", "--", "--ghi"})
+	assert.Equal(t, []string{"abc", "def", "--ghi"}, args)
+	assert.True(t, *deleteFiles)
+	assert.Equal(t, "http://example.com/", *server)
+}
+
+func TestFormatBytes(t *testing.T) {
+	for n, expected := range map[int64]string{
+		0:             "0 B",
+		1023:          "1023 B",
+		1024:          "1.0 KiB",
+		1536:          "1.5 KiB",
+		5 << 20:       "5.0 MiB",
+		3 << 40:       "3.0 TiB",
+		1<<60 + 1<<59: "1.5 EiB",
+	} {
+		assert.Equal(t, expected, formatBytes(n), "%d", n)
+	}
+}
+
+func TestProgress(t *testing.T) {
+	assert.Equal(t, "-", progress(torrential.Torrent{}))
+	assert.Equal(t, "-", progress(torrential.Torrent{HasInfo: true}))
+	assert.Equal(t, "25.0%", progress(torrential.Torrent{HasInfo: true, Length: 400, BytesCompleted: 100}))
+	assert.Equal(t, "100.0%", progress(torrential.Torrent{HasInfo: true, Length: 400, BytesCompleted: 400}))
+}
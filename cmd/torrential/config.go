@@ -0,0 +1,135 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// config sets the flags of a flag set from TORRENTIAL_* environment variables
+// and a YAML config file. Flags set on the command line take precedence over
+// environment variables, which take precedence over the config file.
+//
+// The keys of the config file are the flag names, e.g.
+//
+//	listen-addr: :8080
+//	seed-ratio: 2
+//	exec-hook:
+//	  - done=/usr/local/bin/notify
+//
+// and the environment variable of a flag is its upper-cased name with dashes
+// replaced by underscores, prefixed with TORRENTIAL_, e.g.
+// TORRENTIAL_SEED_RATIO.
+type config struct {
+	fs   *flag.FlagSet
+	path string
+
+	// explicit holds the names of the flags set on the command line.
+	explicit map[string]bool
+}
+
+// newConfig returns the config of fs, which must have been parsed. If path
+// is empty, only environment variables are used.
+func newConfig(fs *flag.FlagSet, path string) *config {
+	c := &config{fs: fs, path: path, explicit: make(map[string]bool)}
+	fs.Visit(func(f *flag.Flag) {
+		c.explicit[f.Name] = true
+	})
+	return c
+}
+
+// envName returns the environment variable of a flag.
+func envName(flagName string) string {
+	return "TORRENTIAL_" + strings.ToUpper(strings.Replace(flagName, "-", "_", -1))
+}
+
+// load sets the flags with the given names, or all flags if no names are
+// given, that were not set on the command line. If reset is set, flags that
+// are neither in the environment nor in the config file are reset to their
+// defaults, so that settings removed from the file are undone on reload.
+func (c *config) load(reset bool, names ...string) error {
+	file, err := c.readFile()
+	if err != nil {
+		return err
+	}
+	for key := range file {
+		if c.fs.Lookup(key) == nil || key == "config" {
+			return errors.Errorf("%s: unknown setting %q", c.path, key)
+		}
+	}
+
+	selected := make(map[string]bool)
+	for _, name := range names {
+		selected[name] = true
+	}
+	var setErr error
+	c.fs.VisitAll(func(f *flag.Flag) {
+		if setErr != nil || c.explicit[f.Name] || f.Name == "config" {
+			return
+		}
+		if len(names) > 0 && !selected[f.Name] {
+			return
+		}
+		if value, ok := os.LookupEnv(envName(f.Name)); ok {
+			if err := f.Value.Set(value); err != nil {
+				setErr = errors.Wrapf(err, "invalid value %q for %s", value, envName(f.Name))
+			}
+			return
+		}
+		if value, ok := file[f.Name]; ok {
+			if err := setValue(f, value); err != nil {
+				setErr = errors.Wrapf(err, "%s: invalid value for %s", c.path, f.Name)
+			}
+			return
+		}
+		if reset {
+			setErr = f.Value.Set(f.DefValue)
+		}
+	})
+	return setErr
+}
+
+func (c *config) readFile() (map[string]interface{}, error) {
+	if c.path == "" {
+		return nil, nil
+	}
+	data, err := ioutil.ReadFile(c.path)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read config file")
+	}
+	var file map[string]interface{}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, errors.Wrapf(err, "could not parse config file %s", c.path)
+	}
+	return file, nil
+}
+
+// setValue sets a flag from a config file value. Each item of a list is set
+// in turn, as if the flag was repeated.
+func setValue(f *flag.Flag, value interface{}) error {
+	switch v := value.(type) {
+	case []interface{}:
+		for _, item := range v {
+			if err := setValue(f, item); err != nil {
+				return err
+			}
+		}
+		return nil
+	case nil:
+		return f.Value.Set("")
+	case float64:
+		// Print whole numbers without an exponent, e.g. memory-limit:
+		// 1073741824.0.
+		if v == float64(int64(v)) {
+			return f.Value.Set(fmt.Sprint(int64(v)))
+		}
+	case map[string]interface{}, map[interface{}]interface{}:
+		return errors.New("expected a value or a list of values")
+	}
+	return f.Value.Set(fmt.Sprint(value))
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfig(t *testing.T) {
+	file, err := ioutil.TempFile("", "torrential-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString("listen-addr: :9000\nseed-ratio: 2\nmemory-limit: 1073741824\nexec-hook:\n  - one\n  - two\n")
+	file.Close()
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	listenAddr := fs.String("listen-addr", ":8080", "")
+	seedRatio := fs.Float64("seed-ratio", 1, "")
+	dropWhenDone := fs.Bool("drop-done", true, "")
+	memoryLimit := fs.Int64("memory-limit", 0, "")
+	var hooks execHooksFlag
+	fs.Var(&hooks, "exec-hook", "")
+	if err := fs.Parse([]string{"--listen-addr", ":7000"}); err != nil {
+		t.Fatal(err)
+	}
+
+	os.Setenv("TORRENTIAL_SEED_RATIO", "3")
+	defer os.Unsetenv("TORRENTIAL_SEED_RATIO")
+	os.Setenv("TORRENTIAL_DROP_DONE", "false")
+	defer os.Unsetenv("TORRENTIAL_DROP_DONE")
+
+	c := newConfig(fs, file.Name())
+	if assert.NoError(t, c.load(false)) {
+		assert.Equal(t, ":7000", *listenAddr)
+		assert.Equal(t, 3.0, *seedRatio)
+		assert.False(t, *dropWhenDone)
+		assert.Equal(t, int64(1073741824), *memoryLimit)
+		if assert.Len(t, hooks, 2) {
+			assert.Equal(t, "two", hooks[1].Command)
+		}
+	}
+
+	// Settings removed from the environment and the file are reset on
+	// reload.
+	os.Unsetenv("TORRENTIAL_SEED_RATIO")
+	ioutil.WriteFile(file.Name(), []byte("drop-done: true\n"), 0644)
+	if assert.NoError(t, c.load(true, "seed-ratio")) {
+		assert.Equal(t, 1.0, *seedRatio)
+	}
+
+	ioutil.WriteFile(file.Name(), []byte("seed-rate: 2\n"), 0644)
+	assert.Error(t, c.load(false))
+}
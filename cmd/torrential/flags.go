@@ -0,0 +1,372 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"unicode"
+
+	tstorage "github.com/anacrolix/torrent/storage"
+	"github.com/joelanford/torrential"
+	"github.com/joelanford/torrential/cache"
+	"github.com/joelanford/torrential/storage"
+	minio "github.com/minio/minio-go"
+	"github.com/pkg/errors"
+)
+
+// execHooksFlag is a flag.Value that collects exec hooks. Each value has the
+// form [event,...=]command [arg...], where the optional list of event types
+// limits the events the command is run for. Arguments are separated by spaces
+// and may be quoted with single or double quotes, as in a shell.
+type execHooksFlag []torrential.ExecHook
+
+func (f *execHooksFlag) String() string {
+	var hooks []string
+	for _, h := range *f {
+		hooks = append(hooks, strings.Join(append([]string{h.Command}, h.Args...), " "))
+	}
+	return strings.Join(hooks, " ")
+}
+
+func (f *execHooksFlag) Set(value string) error {
+	var hook torrential.ExecHook
+	// The value only starts with event types if the part before the first =
+	// holds nothing but names, so that commands and arguments may contain =.
+	if i := strings.Index(value, "="); i >= 0 && isEventList(value[:i]) {
+		for _, name := range strings.Split(value[:i], ",") {
+			t, err := torrential.ParseEventType(strings.TrimSpace(name))
+			if err != nil {
+				return err
+			}
+			hook.EventTypes = append(hook.EventTypes, t)
+		}
+		value = value[i+1:]
+	}
+	words, err := splitCommand(value)
+	if err != nil {
+		return err
+	}
+	if len(words) == 0 {
+		return errors.New("missing command")
+	}
+	hook.Command, hook.Args = words[0], words[1:]
+	*f = append(*f, hook)
+	return nil
+}
+
+func isEventList(s string) bool {
+	for _, c := range s {
+		if c != ',' && c != ' ' && !unicode.IsLetter(c) {
+			return false
+		}
+	}
+	return strings.TrimSpace(s) != ""
+}
+
+// splitCommand splits a command line into words at spaces. Single quotes keep
+// everything up to the closing quote in one word, and a backslash outside of
+// single quotes escapes the next character.
+func splitCommand(s string) ([]string, error) {
+	var (
+		words   []string
+		word    bytes.Buffer
+		inWord  bool
+		quote   rune
+		escaped bool
+	)
+	for _, c := range s {
+		switch {
+		case escaped:
+			word.WriteRune(c)
+			escaped = false
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote == '\'':
+			word.WriteRune(c)
+		case c == '\\':
+			escaped, inWord = true, true
+		case quote != 0:
+			word.WriteRune(c)
+		case c == '\'' || c == '"':
+			quote, inWord = c, true
+		case unicode.IsSpace(c):
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(c)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, errors.Errorf("unterminated %c quote in %q", quote, s)
+	}
+	if escaped {
+		return nil, errors.Errorf("trailing backslash in %q", s)
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// labelsFlag is a flag.Value that collects labels. Each value may hold
+// several comma-separated labels.
+type labelsFlag []string
+
+func (f *labelsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *labelsFlag) Set(value string) error {
+	for _, label := range strings.Split(value, ",") {
+		if label = strings.TrimSpace(label); label != "" {
+			*f = append(*f, label)
+		}
+	}
+	return nil
+}
+
+// fetchHeadersFlag is a flag.Value that collects headers of requests for
+// torrent files. Each value has the form host=Name: value.
+type fetchHeadersFlag map[string]http.Header
+
+func (f fetchHeadersFlag) String() string {
+	var headers []string
+	for host, header := range f {
+		for name, values := range header {
+			for _, value := range values {
+				headers = append(headers, fmt.Sprintf("%s=%s: %s", host, name, value))
+			}
+		}
+	}
+	return strings.Join(headers, " ")
+}
+
+func (f fetchHeadersFlag) Set(value string) error {
+	i := strings.Index(value, "=")
+	j := strings.Index(value, ":")
+	if i <= 0 || j < i {
+		return errors.Errorf("invalid header %q, expected host=Name: value", value)
+	}
+	host, name := value[:i], strings.TrimSpace(value[i+1:j])
+	if f[host] == nil {
+		f[host] = make(http.Header)
+	}
+	f[host].Add(name, strings.TrimSpace(value[j+1:]))
+	return nil
+}
+
+// fetchCookiesFlag is a flag.Value that collects cookies of requests for
+// torrent files. Each value has the form host=name=value.
+type fetchCookiesFlag map[string][]*http.Cookie
+
+func (f fetchCookiesFlag) String() string {
+	var cookies []string
+	for host, cs := range f {
+		for _, c := range cs {
+			cookies = append(cookies, host+"="+c.String())
+		}
+	}
+	return strings.Join(cookies, " ")
+}
+
+func (f fetchCookiesFlag) Set(value string) error {
+	parts := strings.SplitN(value, "=", 3)
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" {
+		return errors.Errorf("invalid cookie %q, expected host=name=value", value)
+	}
+	f[parts[0]] = append(f[parts[0]], &http.Cookie{Name: parts[1], Value: parts[2]})
+	return nil
+}
+
+// openCache returns the cache described by value, which has the form
+// [kind:]path. The kind is dir (the default), bolt, minio or memory. Values
+// that don't start with a known kind are directory paths, even if they
+// contain a colon. The path of a minio cache is a URL of the form
+// http[s]://[access:secret@]host/bucket, with an optional region query
+// parameter. If the URL has no credentials, they are read from
+// $MINIO_ACCESS_KEY and $MINIO_SECRET_KEY.
+func openCache(value string) (cache.Cache, error) {
+	kind, path := "dir", value
+	if i := strings.Index(value, ":"); i >= 0 {
+		switch value[:i] {
+		case "dir", "bolt", "minio", "memory":
+			kind, path = value[:i], value[i+1:]
+		}
+	}
+	if kind == "memory" {
+		return cache.NewMemory(), nil
+	}
+	if path == "" {
+		return nil, errors.Errorf("invalid cache %q: missing path", value)
+	}
+	switch kind {
+	case "dir":
+		return cache.NewDirectory(path), nil
+	case "bolt":
+		return cache.NewBolt(path)
+	case "minio":
+		return openMinioCache(path)
+	}
+	return nil, errors.Errorf("invalid cache %q: unknown kind %q", value, kind)
+}
+
+// encryptCache wraps the cache in a cache.Encrypted if a keyring is given.
+func encryptCache(c cache.Cache, keys *cache.Keyring) (cache.Cache, error) {
+	if keys == nil {
+		return c, nil
+	}
+	store, ok := c.(cache.BlobStore)
+	if !ok {
+		return nil, errors.Errorf("cache %T can't be encrypted", c)
+	}
+	return cache.NewEncrypted(store, keys), nil
+}
+
+// cacheKeyEnv is the environment variable with the keys of an encrypted cache.
+const cacheKeyEnv = "TORRENTIAL_CACHE_KEY"
+
+// loadKeyring loads the keyring from the key file or, if it is empty, from
+// the environment variable. It returns nil if neither is set.
+func loadKeyring(keyFile, env string) (*cache.Keyring, error) {
+	if keyFile != "" {
+		return cache.LoadKeyring(keyFile)
+	}
+	if env != "" {
+		if keys := os.Getenv(env); keys != "" {
+			return cache.ParseKeyring(keys)
+		}
+	}
+	return nil, nil
+}
+
+// openCompletion returns the piece completion store. A bolt cache stores the
+// completion in its own database; other caches use the database at path.
+func openCompletion(c cache.Cache, path string) (cache.PieceCompletion, error) {
+	if b, ok := c.(*cache.Bolt); ok {
+		return b.Completion()
+	}
+	if path == "" {
+		return nil, nil
+	}
+	return cache.NewBoltCompletion(path)
+}
+
+// openAuthenticators returns the authenticators of the API from a tokens file
+// and htpasswd files of admin and read-only users. Empty paths are skipped.
+func openAuthenticators(tokensFile, htpasswdFile, readHtpasswdFile string) ([]torrential.Authenticator, error) {
+	var authenticators []torrential.Authenticator
+	if tokensFile != "" {
+		f, err := os.Open(tokensFile)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		tokens, err := torrential.LoadTokenAuth(f)
+		if err != nil {
+			return nil, errors.Wrap(err, tokensFile)
+		}
+		authenticators = append(authenticators, tokens)
+	}
+	for _, h := range []struct {
+		path  string
+		scope torrential.Scope
+	}{
+		{htpasswdFile, torrential.ScopeAdmin},
+		{readHtpasswdFile, torrential.ScopeRead},
+	} {
+		if h.path == "" {
+			continue
+		}
+		f, err := os.Open(h.path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		basic, err := torrential.NewBasicAuth(f, h.scope)
+		if err != nil {
+			return nil, errors.Wrap(err, h.path)
+		}
+		authenticators = append(authenticators, basic)
+	}
+	return authenticators, nil
+}
+
+// defaultInstanceID returns an instance ID made of the host name and the
+// process ID.
+func defaultInstanceID() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "torrential"
+	}
+	return fmt.Sprintf("%s-%d", hostname, os.Getpid())
+}
+
+func openMinioCache(rawurl string) (cache.Cache, error) {
+	client, bucket, region, err := newMinioClient(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	return cache.NewMinioWithRegion(client, bucket, region), nil
+}
+
+// openStorage returns the payload storage described by value, which has the
+// form kind:path. The only kind is minio, with a path like the path of a
+// minio cache. It returns nil for an empty value, which selects the file
+// storage in the download directory.
+func openStorage(value string, files bool, bufferLimit int64) (tstorage.ClientImpl, error) {
+	if value == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(value, "minio:") {
+		return nil, errors.Errorf("invalid storage %q: unknown kind", value)
+	}
+	client, bucket, region, err := newMinioClient(strings.TrimPrefix(value, "minio:"))
+	if err != nil {
+		return nil, err
+	}
+	s := storage.NewMinioWithRegion(client, bucket, region)
+	s.Files = files
+	s.BufferLimit = bufferLimit
+	return s, nil
+}
+
+// newMinioClient returns a client for a URL of the form
+// http[s]://[access:secret@]host/bucket[?region=region].
+func newMinioClient(rawurl string) (*minio.Client, string, string, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, "", "", errors.Wrap(err, "invalid minio URL")
+	}
+	bucket := strings.Trim(u.Path, "/")
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || bucket == "" {
+		return nil, "", "", errors.Errorf("invalid minio URL %q", rawurl)
+	}
+	accessKey, secretKey := os.Getenv("MINIO_ACCESS_KEY"), os.Getenv("MINIO_SECRET_KEY")
+	if u.User != nil {
+		accessKey = u.User.Username()
+		secretKey, _ = u.User.Password()
+	}
+	client, err := minio.New(u.Host, accessKey, secretKey, u.Scheme == "https")
+	if err != nil {
+		return nil, "", "", errors.Wrap(err, "could not create minio client")
+	}
+	return client, bucket, u.Query().Get("region"), nil
+}
+
+// closeCache closes the cache if it holds resources, like an open database.
+func closeCache(c cache.Cache) {
+	if closer, ok := c.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			log.Printf("error closing cache: %s", err)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/joelanford/torrential"
+	"github.com/joelanford/torrential/cache"
+)
+
+func TestExecHooksFlag(t *testing.T) {
+	var hooks execHooksFlag
+	assert.NoError(t, hooks.Set("/usr/local/bin/notify"))
+	assert.NoError(t, hooks.Set("downloadDone, seedingDone=notify --to 'ops team' a\\ b"))
+	assert.NoError(t, hooks.Set(`curl -d name="$x" --header=X-Event:done`))
+	if assert.Len(t, hooks, 3) {
+		assert.Equal(t, torrential.ExecHook{Command: "/usr/local/bin/notify", Args: []string{}}, hooks[0])
+		assert.Equal(t, torrential.ExecHook{
+			Command:    "notify",
+			Args:       []string{"--to", "ops team", "a b"},
+			EventTypes: []torrential.EventType{torrential.DownloadDone, torrential.SeedingDone},
+		}, hooks[1])
+		assert.Equal(t, []string{"-d", "name=$x", "--header=X-Event:done"}, hooks[2].Args)
+	}
+
+	assert.Error(t, hooks.Set("doen=notify"))
+	assert.Error(t, hooks.Set("downloadDone="))
+	assert.Error(t, hooks.Set("notify 'unterminated"))
+}
+
+func TestOpenCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "torrential-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c, err := openCache("memory:")
+	if assert.NoError(t, err) {
+		assert.IsType(t, &cache.Memory{}, c)
+	}
+	c, err = openCache("bolt:" + filepath.Join(dir, "cache.db"))
+	if assert.NoError(t, err) && assert.IsType(t, &cache.Bolt{}, c) {
+		assert.NoError(t, c.(*cache.Bolt).Close())
+	}
+
+	// Only known kinds are prefixes, so other paths may contain colons.
+	for value, path := range map[string]string{
+		"dir:/var/cache":    "/var/cache",
+		"/var/cache":        "/var/cache",
+		"/var/cache/a:b":    "/var/cache/a:b",
+		"backup:2017/cache": "backup:2017/cache",
+	} {
+		c, err := openCache(value)
+		if assert.NoError(t, err, value) {
+			assert.Equal(t, cache.NewDirectory(path), c, value)
+		}
+	}
+
+	_, err = openCache("bolt:")
+	assert.Error(t, err)
+}
@@ -1,52 +1,415 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/anacrolix/torrent"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
 	"github.com/gorilla/mux"
 	"github.com/joelanford/torrential"
 	"github.com/joelanford/torrential/cache"
+	"github.com/joelanford/torrential/sink"
+	"github.com/joelanford/torrential/storage"
+	nats "github.com/nats-io/nats.go"
 )
 
 var (
+	configFile   string
 	listenAddr   string
 	downloadDir  string
 	torrentsDir  string
+	cacheSpec    string
+	cacheKeyFile string
+	completionDB string
+	storageSpec  string
+	storageFiles bool
+	storageLimit int64
+	leases       bool
+	watchCache   bool
+	instanceID   string
+	leaseTTL     time.Duration
+	ephemeral    bool
+	memoryLimit  int64
 	seedRatio    float64
 	dropWhenDone bool
 	webhookURL   string
 	httpBasePath string
+
+	eventFormat string
+	eventSource string
+
+	webhookSecret      string
+	webhookDir         string
+	webhookTimeout     time.Duration
+	webhookMaxAttempts int
+
+	execHooks       execHooksFlag
+	execTimeout     time.Duration
+	execConcurrency int
+
+	mqttBroker        string
+	mqttClientID      string
+	mqttTopicPrefix   string
+	mqttQoS           int
+	natsURL           string
+	natsSubjectPrefix string
+
+	shutdownTimeout time.Duration
+	fetchTimeout    time.Duration
+	maxTorrentSize  int64
+	infoTimeout     time.Duration
+
+	fetchSchemes      labelsFlag
+	fetchAllowHosts   labelsFlag
+	fetchDenyHosts    labelsFlag
+	fetchAllowPrivate bool
+	fetchMaxRedirects int
+	fetchHeaders      = fetchHeadersFlag{}
+	fetchCookies      = fetchCookiesFlag{}
+
+	authTokens       string
+	authHtpasswd     string
+	authHtpasswdRead string
+
+	peerListenAddr     string
+	noDHT              bool
+	disableTrackers    bool
+	disablePEX         bool
+	noUpload           bool
+	disableUTP         bool
+	disableTCP         bool
+	disableIPv6        bool
+	proxyURL           string
+	disableEncryption  bool
+	forceEncryption    bool
+	preferNoEncryption bool
 )
 
+// reloadedSettings are the settings that are reloaded from the environment
+// and the config file on SIGHUP.
+var reloadedSettings = []string{"seed-ratio", "drop-done"}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "cache" {
+		runCache(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "tui" {
+		runTUI(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && isClientCommand(os.Args[1]) {
+		runClient(os.Args[1], os.Args[2:])
+		return
+	}
+
+	flag.StringVar(&configFile, "config", "", "YAML config file whose keys are flag names (flags and TORRENTIAL_* environment variables take precedence)")
 	flag.StringVar(&listenAddr, "listen-addr", ":8080", "Address to listen on")
 	flag.StringVar(&downloadDir, "download-dir", "torrential-data/downloads", "Directory in which to download torrent data")
 	flag.StringVar(&torrentsDir, "torrents-dir", "torrential-data/torrents", "Directory in which to cache active torrent metadata files")
+	flag.StringVar(&cacheSpec, "cache", "", "Cache of active torrents, as [dir:]path, bolt:path, minio:http[s]://[access:secret@]host/bucket or memory: (defaults to the torrents directory)")
+	flag.StringVar(&cacheKeyFile, "cache-key-file", "", "File with the keys used to encrypt the cache (defaults to the keys in $TORRENTIAL_CACHE_KEY, if set)")
+	flag.StringVar(&completionDB, "completion-db", "torrential-data/completion.db", "Database in which to persist piece completion, unless the cache is a bolt cache (empty to disable)")
+	flag.StringVar(&storageSpec, "storage", "", "Storage of torrent data, as minio:http[s]://[access:secret@]host/bucket (defaults to the download directory)")
+	flag.BoolVar(&storageFiles, "storage-files", false, "Also write the files of completed torrents to the storage bucket")
+	flag.Int64Var(&storageLimit, "storage-buffer-limit", storage.DefaultMinioBufferLimit, "Maximum number of bytes of pieces to keep in memory while they are downloaded to the storage bucket")
+	flag.BoolVar(&watchCache, "watch-cache", false, "Add and drop torrents as other processes add them to or remove them from the cache (dir and minio caches, also with --cache-key-file)")
+	flag.BoolVar(&leases, "leases", false, "Share the cache with other instances, running only the torrents this instance holds leases for")
+	flag.StringVar(&instanceID, "instance-id", defaultInstanceID(), "ID of this instance, unique among the instances sharing the cache")
+	flag.DurationVar(&leaseTTL, "lease-ttl", 30*time.Second, "Time after which the torrents of an unresponsive instance are taken over")
+	flag.BoolVar(&ephemeral, "ephemeral", false, "Keep the cache and torrent data in memory, e.g. for CI jobs and tests (ignores the cache, storage and completion database flags)")
+	flag.Int64Var(&memoryLimit, "memory-limit", 1<<30, "Maximum number of bytes of torrent data to keep in memory in ephemeral mode (0 for no limit)")
 	flag.Float64Var(&seedRatio, "seed-ratio", 1.0, "Seed ratio of torrents that determines when seed ratio events and webhooks are invoked")
 	flag.BoolVar(&dropWhenDone, "drop-done", true, "Drop the torrent when the download completes (or when the seed ratio is met, if enabled)")
 	flag.StringVar(&webhookURL, "webhook-url", "", "Webhook to invoke for torrent events")
+	flag.StringVar(&eventFormat, "event-format", "json", "Encoding of webhook and event stream events (json, cloudevents or cloudevents-binary)")
+	flag.StringVar(&eventSource, "event-source", "/torrential", "CloudEvents source attribute of events")
+	flag.StringVar(&webhookSecret, "webhook-secret", "", "Shared secret used to sign webhook requests with HMAC-SHA256")
+	flag.StringVar(&webhookDir, "webhook-dir", "torrential-data/webhooks", "Directory in which to persist the webhook outbox and dead letters")
+	flag.DurationVar(&webhookTimeout, "webhook-timeout", 10*time.Second, "Timeout of each webhook request")
+	flag.IntVar(&webhookMaxAttempts, "webhook-max-attempts", 5, "Number of attempts before a webhook delivery is moved to the dead letters")
+	flag.Var(&execHooks, "exec-hook", "Command to run for torrent events, as [event,...=]command [arg...] (may be repeated, runs for downloadDone, seedingDone and closed events by default)")
+	flag.DurationVar(&execTimeout, "exec-timeout", 10*time.Minute, "Timeout of exec hook commands")
+	flag.IntVar(&execConcurrency, "exec-concurrency", 4, "Maximum number of exec hook commands to run at the same time")
+	flag.StringVar(&mqttBroker, "mqtt-broker", "", "MQTT broker to publish torrent events to, e.g. tcp://localhost:1883")
+	flag.StringVar(&mqttClientID, "mqtt-client-id", "", "Client ID of the MQTT connection, unique among the clients of the broker (default the instance ID)")
+	flag.StringVar(&mqttTopicPrefix, "mqtt-topic-prefix", "torrential", "Prefix of the MQTT topics that torrent events are published to")
+	flag.IntVar(&mqttQoS, "mqtt-qos", 0, "MQTT quality of service level of published torrent events")
+	flag.StringVar(&natsURL, "nats-url", "", "NATS server to publish torrent events to, e.g. nats://localhost:4222")
+	flag.StringVar(&natsSubjectPrefix, "nats-subject-prefix", "torrential", "Prefix of the NATS subjects that torrent events are published to")
 	flag.StringVar(&httpBasePath, "http-basepath", "/", "Base path of torrential HTTP handler")
+	flag.DurationVar(&fetchTimeout, "fetch-timeout", 30*time.Second, "Timeout of requests for torrent files added by URL")
+	flag.Int64Var(&maxTorrentSize, "max-torrent-size", 10<<20, "Maximum size in bytes of added torrent files")
+	flag.DurationVar(&infoTimeout, "info-timeout", time.Minute, "Time that adding a torrent waits for its info before returning it without")
+	flag.Var(&fetchSchemes, "fetch-scheme", "URL scheme that torrent files may be fetched with (may be repeated, defaults to http and https)")
+	flag.Var(&fetchAllowHosts, "fetch-allow-host", "Only host that torrent files may be fetched from, or *.domain for its subdomains (may be repeated)")
+	flag.Var(&fetchDenyHosts, "fetch-deny-host", "Host that torrent files may not be fetched from, or *.domain for its subdomains (may be repeated)")
+	flag.BoolVar(&fetchAllowPrivate, "fetch-allow-private", false, "Allow fetching torrent files from loopback and private addresses")
+	flag.IntVar(&fetchMaxRedirects, "fetch-max-redirects", 5, "Maximum number of redirects followed when fetching torrent files (0 to follow none)")
+	flag.Var(fetchHeaders, "fetch-header", "Header of requests for torrent files to a host, as host=Name: value (may be repeated)")
+	flag.Var(fetchCookies, "fetch-cookie", "Cookie of requests for torrent files to a host, as host=name=value (may be repeated)")
+	flag.StringVar(&authTokens, "auth-tokens", "", "File of bearer tokens that may use the API, with lines of the form \"scope token\" where scope is read or admin")
+	flag.StringVar(&authHtpasswd, "auth-htpasswd", "", "htpasswd file of users that may use the API with the admin scope (bcrypt or SHA-1 hashes)")
+	flag.StringVar(&authHtpasswdRead, "auth-htpasswd-read", "", "htpasswd file of users that may use the API with the read scope")
+	flag.DurationVar(&shutdownTimeout, "shutdown-timeout", 30*time.Second, "Time to wait for requests, webhooks and exec hooks to finish on SIGINT or SIGTERM")
+	flag.StringVar(&peerListenAddr, "peer-listen-addr", "", "Address to listen on for peer connections (defaults to the torrent client default)")
+	flag.BoolVar(&noDHT, "no-dht", false, "Disable the DHT")
+	flag.BoolVar(&disableTrackers, "disable-trackers", false, "Don't announce torrents to trackers")
+	flag.BoolVar(&disablePEX, "disable-pex", false, "Disable peer exchange")
+	flag.BoolVar(&noUpload, "no-upload", false, "Don't upload torrent data to peers")
+	flag.BoolVar(&disableUTP, "disable-utp", false, "Disable uTP peer connections")
+	flag.BoolVar(&disableTCP, "disable-tcp", false, "Disable TCP peer connections")
+	flag.BoolVar(&disableIPv6, "disable-ipv6", false, "Disable IPv6 peer connections")
+	flag.StringVar(&proxyURL, "proxy-url", "", "Proxy for peer and tracker connections, e.g. socks5://localhost:1080")
+	flag.BoolVar(&disableEncryption, "disable-encryption", false, "Disable encryption of peer connections")
+	flag.BoolVar(&forceEncryption, "force-encryption", false, "Only accept encrypted peer connections")
+	flag.BoolVar(&preferNoEncryption, "prefer-no-encryption", false, "Prefer unencrypted peer connections")
 
 	flag.Parse()
 
+	if configFile == "" {
+		configFile = os.Getenv(envName("config"))
+	}
+	conf := newConfig(flag.CommandLine, configFile)
+	if err := conf.load(false); err != nil {
+		log.Fatal(err)
+	}
+
+	if cacheSpec == "" {
+		cacheSpec = "dir:" + torrentsDir
+	}
+	if ephemeral {
+		cacheSpec, completionDB, storageSpec = "memory:", "", ""
+	}
+	torrentCache, err := openCache(cacheSpec)
+	if err != nil {
+		log.Fatal(err)
+	}
+	cacheKeys, err := loadKeyring(cacheKeyFile, cacheKeyEnv)
+	if err != nil {
+		log.Fatal(err)
+	}
+	completion, err := openCompletion(torrentCache, completionDB)
+	if err != nil {
+		log.Fatal(err)
+	}
+	payloadStorage, err := openStorage(storageSpec, storageFiles, storageLimit)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var cacheLeases *cache.Leases
+	if leases {
+		switch torrentCache.(type) {
+		case *cache.Bolt, *cache.Memory:
+			log.Fatalf("cache %s can't be shared between processes, use a dir or minio cache with --leases", cacheSpec)
+		}
+		// Leases are named after the info hashes of the torrents, which an
+		// encrypted cache hides.
+		if cacheKeys != nil {
+			log.Fatal("--leases can't be used with an encrypted cache, since leases would reveal the cached torrents")
+		}
+		store, ok := torrentCache.(cache.BlobStore)
+		if !ok {
+			log.Fatalf("cache %s does not support leases", cacheSpec)
+		}
+		cacheLeases = cache.NewLeases(store, instanceID)
+		cacheLeases.TTL = leaseTTL
+	}
+	if torrentCache, err = encryptCache(torrentCache, cacheKeys); err != nil {
+		log.Fatal(err)
+	}
+
+	var webhooks []torrential.Webhook
+	if webhookURL != "" {
+		webhooks = append(webhooks, torrential.Webhook{
+			ID:  "default",
+			URL: webhookURL,
+		})
+	}
+
+	// disconnects close the connections of the sinks once the service is
+	// closed, flushing the events they buffer.
+	var sinks []torrential.EventSink
+	var disconnects []func()
+	if mqttBroker != "" {
+		// Brokers disconnect clients whose ID is taken by another client, so
+		// instances must not share one.
+		if mqttClientID == "" {
+			mqttClientID = instanceID
+		}
+		client := mqtt.NewClient(mqtt.NewClientOptions().AddBroker(mqttBroker).SetClientID(mqttClientID))
+		if token := client.Connect(); token.Wait() && token.Error() != nil {
+			log.Fatal(token.Error())
+		}
+		s := sink.NewMQTT(client, sink.Options{
+			TopicPrefix: mqttTopicPrefix,
+			Format:      torrential.EventFormat(eventFormat),
+			Source:      eventSource,
+		})
+		s.QoS = byte(mqttQoS)
+		sinks = append(sinks, s)
+		disconnects = append(disconnects, func() { client.Disconnect(250) })
+	}
+	if natsURL != "" {
+		conn, err := nats.Connect(natsURL)
+		if err != nil {
+			log.Fatal(err)
+		}
+		sinks = append(sinks, sink.NewNATS(conn, sink.Options{
+			TopicPrefix: natsSubjectPrefix,
+			Format:      torrential.EventFormat(eventFormat),
+			Source:      eventSource,
+		}))
+		disconnects = append(disconnects, conn.Close)
+	}
+
+	var webhookStore torrential.WebhookStore
+	if !ephemeral {
+		webhookStore = torrential.NewWebhookDirectory(webhookDir)
+	}
+
+	clientConfig := &torrent.Config{
+		DataDir:         downloadDir,
+		DefaultStorage:  payloadStorage,
+		ListenAddr:      peerListenAddr,
+		NoDHT:           noDHT,
+		DisableTrackers: disableTrackers,
+		DisablePEX:      disablePEX,
+		NoUpload:        noUpload,
+		DisableUTP:      disableUTP,
+		DisableTCP:      disableTCP,
+		DisableIPv6:     disableIPv6,
+		ProxyURL:        proxyURL,
+	}
+	clientConfig.DisableEncryption = disableEncryption
+	clientConfig.ForceEncryption = forceEncryption
+	clientConfig.PreferNoEncryption = preferNoEncryption
+
+	// The flag follows no redirects at 0, while a zero FetchPolicy follows
+	// the default number.
+	maxRedirects := fetchMaxRedirects
+	if maxRedirects <= 0 {
+		maxRedirects = -1
+	}
+
 	svc, err := torrential.NewService(&torrential.Config{
-		ClientConfig: &torrent.Config{
-			DataDir: downloadDir,
-		},
-		Cache:        cache.NewDirectory(torrentsDir),
+		ClientConfig: clientConfig,
+		Cache:        torrentCache,
 		SeedRatio:    seedRatio,
 		DropWhenDone: dropWhenDone,
-		WebhookURL:   webhookURL,
+		Webhooks:     webhooks,
+		EventFormat:  torrential.EventFormat(eventFormat),
+		EventSource:  eventSource,
+		EventSinks:   sinks,
+
+		PieceCompletion:    completion,
+		MemoryStorage:      ephemeral,
+		MemoryStorageLimit: memoryLimit,
+		Leases:             cacheLeases,
+		WatchCache:         watchCache,
+
+		WebhookSecret:      webhookSecret,
+		WebhookStore:       webhookStore,
+		WebhookTimeout:     webhookTimeout,
+		WebhookMaxAttempts: webhookMaxAttempts,
+
+		ExecHooks:       execHooks,
+		ExecTimeout:     execTimeout,
+		ExecConcurrency: execConcurrency,
+
+		FetchTimeout:   fetchTimeout,
+		MaxTorrentSize: maxTorrentSize,
+		InfoTimeout:    infoTimeout,
+		FetchPolicy: torrential.FetchPolicy{
+			Schemes:      fetchSchemes,
+			AllowHosts:   fetchAllowHosts,
+			DenyHosts:    fetchDenyHosts,
+			AllowPrivate: fetchAllowPrivate,
+			MaxRedirects: maxRedirects,
+			Headers:      fetchHeaders,
+			Cookies:      fetchCookies,
+		},
 	})
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	go reloadOnHangup(conf, svc)
+
+	authenticators, err := openAuthenticators(authTokens, authHtpasswd, authHtpasswdRead)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var handlerOptions []torrential.HandlerOptionFunc
+	if len(authenticators) > 0 {
+		handlerOptions = append(handlerOptions, torrential.Auth(authenticators...))
+	}
+
 	router := mux.NewRouter()
-	router.PathPrefix(httpBasePath).Handler(torrential.Handler(httpBasePath, svc))
+	router.PathPrefix(httpBasePath).Handler(torrential.Handler(httpBasePath, svc, handlerOptions...))
+
+	server := &http.Server{Addr: listenAddr, Handler: router}
+	shutdown := shutdownOnSignal(server, svc)
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+	<-shutdown
+	for _, disconnect := range disconnects {
+		disconnect()
+	}
+}
 
-	log.Fatal(http.ListenAndServe(listenAddr, router))
+// shutdownOnSignal shuts down the server and closes the service when the
+// process receives SIGINT or SIGTERM. Both happen at the same time, because
+// the server waits for event streams that the service closes. The returned
+// channel is closed once both are done.
+func shutdownOnSignal(server *http.Server, svc *torrential.Service) <-chan struct{} {
+	shutdown := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		defer close(shutdown)
+		shutdownServer(server, svc, <-signals)
+	}()
+	return shutdown
+}
+
+func shutdownServer(server *http.Server, svc *torrential.Service, sig os.Signal) {
+	log.Printf("received %s, shutting down", sig)
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.Shutdown(ctx)
+	}()
+	if err := svc.Close(ctx); err != nil {
+		log.Printf("error closing service: %s", err)
+	}
+	if err := <-serverErr; err != nil {
+		log.Printf("error shutting down server: %s", err)
+	}
+}
+
+// reloadOnHangup reloads the reloaded settings and applies them to the
+// service when the process receives SIGHUP.
+func reloadOnHangup(conf *config, svc *torrential.Service) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		if err := conf.load(true, reloadedSettings...); err != nil {
+			log.Printf("could not reload config: %s", err)
+			continue
+		}
+		svc.SetSeedRatio(seedRatio)
+		svc.SetDropWhenDone(dropWhenDone)
+		log.Printf("reloaded config: seed-ratio=%g drop-done=%t", seedRatio, dropWhenDone)
+	}
 }
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/joelanford/torrential"
+	"github.com/joelanford/torrential/client"
+	termbox "github.com/nsf/termbox-go"
+)
+
+const tuiHelp = "↑/↓ select  p pause/resume  +/- priority  enter files  d drop  D drop and delete files  q quit"
+
+// runTUI runs the tui subcommand, a dashboard of the torrents of a running
+// instance.
+func runTUI(args []string) {
+	log.SetFlags(0)
+	var opts clientOptions
+	fs := flag.NewFlagSet("tui", flag.ExitOnError)
+	opts.register(fs)
+	interval := fs.Duration("interval", time.Second, "Interval at which torrents are refreshed")
+	fs.Parse(args)
+	c := opts.client()
+
+	// Fail before taking over the terminal if the server can't be reached.
+	torrents, err := c.Torrents()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := termbox.Init(); err != nil {
+		log.Fatal(err)
+	}
+	defer termbox.Close()
+
+	// Anything written to the terminal would garble the dashboard, so errors
+	// are shown in the status line instead.
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+	ui := &tui{
+		c:       c,
+		samples: make(map[string]sample),
+		rates:   make(map[string]rates),
+		status:  tuiHelp,
+		errs:    make(chan error, 1),
+	}
+	c.StreamError = func(err error) {
+		select {
+		case ui.errs <- err:
+		default:
+		}
+	}
+	ui.update(torrents, time.Now())
+	ui.run(*interval)
+}
+
+// sample is the transfer counters of a torrent at a point in time, from which
+// transfer rates are computed.
+type sample struct {
+	read    int
+	written int
+	at      time.Time
+}
+
+type rates struct {
+	down float64
+	up   float64
+}
+
+type tui struct {
+	c        *client.Client
+	torrents []torrential.Torrent
+	samples  map[string]sample
+	rates    map[string]rates
+	selected int
+
+	// files is set while the file list of the selected torrent is shown.
+	files bool
+
+	// confirm is the action waiting for the user to press y.
+	confirm func()
+	status  string
+
+	// errs receives the errors of the event stream.
+	errs chan error
+}
+
+func (ui *tui) run(interval time.Duration) {
+	keys := make(chan termbox.Event)
+	go func() {
+		for {
+			keys <- termbox.PollEvent()
+		}
+	}()
+
+	done := make(chan struct{})
+	defer close(done)
+	events := ui.c.Events(done)
+
+	type refresh struct {
+		torrents []torrential.Torrent
+		err      error
+	}
+	refreshes := make(chan refresh, 1)
+	refreshing := false
+	startRefresh := func() {
+		if refreshing {
+			return
+		}
+		refreshing = true
+		go func() {
+			torrents, err := ui.c.Torrents()
+			refreshes <- refresh{torrents, err}
+		}()
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		ui.draw()
+		select {
+		case ev := <-keys:
+			if ev.Type == termbox.EventKey && !ui.handleKey(ev) {
+				return
+			}
+		case e, ok := <-events:
+			if !ok {
+				events = nil
+				continue
+			}
+			ui.status = fmt.Sprintf("%s %s %s", time.Now().Format("15:04:05"), e.Type, eventTorrent(e))
+			startRefresh()
+		case err := <-ui.errs:
+			ui.status = err.Error()
+		case <-ticker.C:
+			startRefresh()
+		case r := <-refreshes:
+			refreshing = false
+			if r.err != nil {
+				ui.status = r.err.Error()
+				continue
+			}
+			ui.update(r.torrents, time.Now())
+		}
+	}
+}
+
+// update replaces the torrents and computes their transfer rates from the
+// previous samples.
+func (ui *tui) update(torrents []torrential.Torrent, now time.Time) {
+	sort.Slice(torrents, func(i, j int) bool {
+		return torrents[i].Name < torrents[j].Name
+	})
+	var selected string
+	if ui.selected < len(ui.torrents) {
+		selected = ui.torrents[ui.selected].InfoHash
+	}
+
+	samples := make(map[string]sample, len(torrents))
+	for i, t := range torrents {
+		s := sample{t.Stats.DataBytesRead, t.Stats.DataBytesWritten, now}
+		if prev, ok := ui.samples[t.InfoHash]; ok {
+			if secs := now.Sub(prev.at).Seconds(); secs > 0 {
+				ui.rates[t.InfoHash] = rates{
+					down: float64(s.read-prev.read) / secs,
+					up:   float64(s.written-prev.written) / secs,
+				}
+			}
+		}
+		samples[t.InfoHash] = s
+		if t.InfoHash == selected {
+			ui.selected = i
+		}
+	}
+	for infoHash := range ui.rates {
+		if _, ok := samples[infoHash]; !ok {
+			delete(ui.rates, infoHash)
+		}
+	}
+	ui.samples = samples
+	ui.torrents = torrents
+	if ui.selected >= len(torrents) {
+		ui.selected = len(torrents) - 1
+	}
+	if ui.selected < 0 {
+		ui.selected = 0
+	}
+}
+
+// handleKey handles a key press. It returns false if the UI should exit.
+func (ui *tui) handleKey(ev termbox.Event) bool {
+	if ui.confirm != nil {
+		if ev.Ch == 'y' || ev.Ch == 'Y' {
+			ui.confirm()
+		} else {
+			ui.status = tuiHelp
+		}
+		ui.confirm = nil
+		return true
+	}
+
+	switch {
+	case ev.Key == termbox.KeyCtrlC || ev.Ch == 'q':
+		if ui.files {
+			ui.files = false
+			return true
+		}
+		return false
+	case ev.Key == termbox.KeyEsc:
+		ui.files = false
+	case ev.Key == termbox.KeyArrowUp || ev.Ch == 'k':
+		if ui.selected > 0 {
+			ui.selected--
+		}
+	case ev.Key == termbox.KeyArrowDown || ev.Ch == 'j':
+		if ui.selected < len(ui.torrents)-1 {
+			ui.selected++
+		}
+	}
+
+	t := ui.current()
+	if t == nil {
+		return true
+	}
+	switch {
+	case ev.Key == termbox.KeyEnter || ev.Ch == 'f':
+		ui.files = !ui.files
+	case ev.Ch == 'p':
+		if t.Paused {
+			ui.apply(ui.c.Resume(t.InfoHash))
+		} else {
+			ui.apply(ui.c.Pause(t.InfoHash))
+		}
+	case ev.Ch == '+':
+		ui.apply(ui.c.SetPriority(t.InfoHash, torrential.PriorityHigh))
+	case ev.Ch == '-':
+		ui.apply(ui.c.SetPriority(t.InfoHash, torrential.PriorityNormal))
+	case ev.Ch == 'd' || ev.Ch == 'D':
+		deleteFiles := ev.Ch == 'D'
+		infoHash, name := t.InfoHash, t.Name
+		if deleteFiles {
+			ui.status = fmt.Sprintf("Drop %s and delete its files? (y/n)", name)
+		} else {
+			ui.status = fmt.Sprintf("Drop %s? (y/n)", name)
+		}
+		ui.confirm = func() {
+			if err := ui.c.Drop(infoHash, deleteFiles); err != nil {
+				ui.status = err.Error()
+				return
+			}
+			ui.status = fmt.Sprintf("Dropped %s", name)
+			ui.files = false
+			ui.remove(infoHash)
+		}
+	}
+	return true
+}
+
+// apply replaces the selected torrent with the result of a control request.
+func (ui *tui) apply(t *torrential.Torrent, err error) {
+	if err != nil {
+		ui.status = err.Error()
+		return
+	}
+	for i := range ui.torrents {
+		if ui.torrents[i].InfoHash == t.InfoHash {
+			ui.torrents[i] = *t
+		}
+	}
+}
+
+func (ui *tui) remove(infoHash string) {
+	for i := range ui.torrents {
+		if ui.torrents[i].InfoHash == infoHash {
+			ui.torrents = append(ui.torrents[:i], ui.torrents[i+1:]...)
+			break
+		}
+	}
+	if ui.selected >= len(ui.torrents) && ui.selected > 0 {
+		ui.selected--
+	}
+}
+
+func (ui *tui) current() *torrential.Torrent {
+	if ui.selected < 0 || ui.selected >= len(ui.torrents) {
+		return nil
+	}
+	return &ui.torrents[ui.selected]
+}
+
+func (ui *tui) draw() {
+	termbox.Clear(termbox.ColorDefault, termbox.ColorDefault)
+	width, height := termbox.Size()
+
+	if t := ui.current(); ui.files && t != nil {
+		ui.drawFiles(t, width, height)
+	} else {
+		ui.drawTorrents(width, height)
+	}
+	drawText(0, height-1, width, termbox.ColorBlack, termbox.ColorWhite, ui.status)
+	termbox.Flush()
+}
+
+func (ui *tui) drawTorrents(width, height int) {
+	const row = "%-40.40s %8s %10s %10s %5s %6s %-12s %-6s"
+	drawText(0, 0, width, termbox.AttrBold, termbox.ColorDefault,
+		fmt.Sprintf(row, "NAME", "PROGRESS", "DOWN", "UP", "PEERS", "RATIO", "STATE", "PRIO"))
+
+	// Scroll so that the selected torrent is visible.
+	rows := height - 2
+	first := 0
+	if ui.selected >= rows {
+		first = ui.selected - rows + 1
+	}
+	for i := first; i < len(ui.torrents) && i-first < rows; i++ {
+		t := ui.torrents[i]
+		r := ui.rates[t.InfoHash]
+		name := t.Name
+		if name == "" {
+			name = t.InfoHash
+		}
+		line := fmt.Sprintf(row, name, progress(t), formatRate(r.down), formatRate(r.up),
+			fmt.Sprint(t.Stats.ActivePeers), ratio(t), state(t), t.Priority)
+		fg, bg := termbox.ColorDefault, termbox.ColorDefault
+		if i == ui.selected {
+			fg, bg = termbox.ColorBlack, termbox.ColorCyan
+		}
+		drawText(0, i-first+1, width, fg, bg, line)
+	}
+}
+
+func (ui *tui) drawFiles(t *torrential.Torrent, width, height int) {
+	drawText(0, 0, width, termbox.AttrBold, termbox.ColorDefault, fmt.Sprintf("Files of %s (q to go back)", t.Name))
+	drawText(0, 1, width, termbox.AttrBold, termbox.ColorDefault, fmt.Sprintf("%-60s %10s", "PATH", "SIZE"))
+	for i, f := range t.Files {
+		if i+2 >= height-1 {
+			break
+		}
+		drawText(0, i+2, width, termbox.ColorDefault, termbox.ColorDefault,
+			fmt.Sprintf("%-60s %10s", f.DisplayPath, formatBytes(int64(f.Length))))
+	}
+}
+
+// drawText draws s at x, y, padded or cut to width.
+func drawText(x, y, width int, fg, bg termbox.Attribute, s string) {
+	for _, r := range s {
+		if x >= width {
+			return
+		}
+		termbox.SetCell(x, y, r, fg, bg)
+		x++
+	}
+	for ; x < width; x++ {
+		termbox.SetCell(x, y, ' ', fg, bg)
+	}
+}
+
+func formatRate(bytesPerSec float64) string {
+	if bytesPerSec <= 0 {
+		return "-"
+	}
+	return formatBytes(int64(bytesPerSec)) + "/s"
+}
+
+// ratio returns the seed ratio of a torrent, computed like the seed ratio of
+// torrential.SeedRatio.
+func ratio(t torrential.Torrent) string {
+	if t.BytesCompleted == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.2f", float64(t.Stats.DataBytesWritten)/float64(t.BytesCompleted))
+}
+
+func state(t torrential.Torrent) string {
+	switch {
+	case t.Paused:
+		return "paused"
+	case !t.HasInfo:
+		return "getting info"
+	case t.Seeding:
+		return "seeding"
+	case t.BytesMissing == 0:
+		return "done"
+	default:
+		return "downloading"
+	}
+}
@@ -18,12 +18,21 @@ type addTorrentErr struct {
 type fetchErr struct {
 	error
 }
+type tooLargeErr struct {
+	error
+}
+type forbiddenErr struct {
+	error
+}
 type deleteErr struct {
 	error
 }
 type cacheErr struct {
 	error
 }
+type webhookErr struct {
+	error
+}
 
 func (e notFoundErr) IsNotFound() bool {
 	return true
@@ -43,9 +52,18 @@ func (e addTorrentErr) IsAddTorrentError() bool {
 func (e fetchErr) IsFetchError() bool {
 	return true
 }
+func (e tooLargeErr) IsTooLarge() bool {
+	return true
+}
+func (e forbiddenErr) IsForbidden() bool {
+	return true
+}
 func (e deleteErr) IsDeleteError() bool {
 	return true
 }
 func (e cacheErr) IsCacheError() bool {
 	return true
 }
+func (e webhookErr) IsWebhookError() bool {
+	return true
+}
@@ -13,6 +13,11 @@ type MultiEventer struct {
 	eventerMap   map[string]Eventer
 	numActive    int
 	mutex        sync.RWMutex
+
+	// closed is closed by close, after which final is sent to each
+	// subscription before its channel is closed.
+	closed chan struct{}
+	final  Event
 }
 
 var _ Eventer = &MultiEventer{}
@@ -21,9 +26,13 @@ func newMultiEventer() *MultiEventer {
 	return &MultiEventer{
 		eventerChans: make(map[string]chan Eventer),
 		eventerMap:   make(map[string]Eventer),
+		closed:       make(chan struct{}),
 	}
 }
 
+// Events returns a channel on which the events of all torrents are sent. The
+// channel is closed when done is closed, or after the final event is sent when
+// the service is closed.
 func (e *MultiEventer) Events(done <-chan struct{}) <-chan Event {
 	events := make(chan Event)
 	eventerChan := make(chan Eventer)
@@ -37,14 +46,22 @@ func (e *MultiEventer) Events(done <-chan struct{}) <-chan Event {
 		e.mutex.RLock()
 		defer e.mutex.RUnlock()
 		for _, eventer := range e.eventerMap {
-			eventerChan <- eventer
+			select {
+			case eventerChan <- eventer:
+			case <-done:
+				return
+			case <-e.closed:
+				return
+			}
 		}
 	}()
 	go func() {
+		var wg sync.WaitGroup
 		defer func() {
 			e.mutex.Lock()
 			delete(e.eventerChans, id)
 			e.mutex.Unlock()
+			wg.Wait()
 			close(events)
 		}()
 		for {
@@ -53,19 +70,40 @@ func (e *MultiEventer) Events(done <-chan struct{}) <-chan Event {
 				if !ok {
 					return
 				}
+				wg.Add(1)
 				go func() {
+					defer wg.Done()
 					for event := range t.Events(done) {
-						events <- event
+						select {
+						case events <- event:
+						case <-done:
+						}
 					}
 				}()
 			case <-done:
 				return
+			case <-e.closed:
+				// The torrents are closed before the eventer, so their
+				// closed events are sent before the final event.
+				wg.Wait()
+				select {
+				case events <- e.final:
+				case <-done:
+				}
+				return
 			}
 		}
 	}()
 	return events
 }
 
+// close sends final to the subscriptions and closes them. The torrents must
+// have been closed.
+func (e *MultiEventer) close(final Event) {
+	e.final = final
+	close(e.closed)
+}
+
 func (e *MultiEventer) add(t *TorrentEventer) {
 	e.mutex.RLock()
 	defer e.mutex.RUnlock()
@@ -86,7 +124,7 @@ func (e *MultiEventer) add(t *TorrentEventer) {
 type TorrentEventer struct {
 	seedRatio float64
 
-	torrent Torrent
+	torrent *torrent.Torrent
 
 	added        chan struct{}
 	gotInfo      chan struct{}
@@ -105,7 +143,7 @@ var _ Eventer = &TorrentEventer{}
 
 type EventerOptionFunc func(e *TorrentEventer)
 
-func newTorrentEventer(t Torrent, options ...EventerOptionFunc) *TorrentEventer {
+func newTorrentEventer(t *torrent.Torrent, options ...EventerOptionFunc) *TorrentEventer {
 	e := TorrentEventer{
 		torrent:      t,
 		added:        make(chan struct{}),
@@ -200,9 +238,9 @@ func (e *TorrentEventer) Events(done <-chan struct{}) <-chan Event {
 		}()
 		select {
 		case <-e.Added():
-			events <- Event{Type: Added, Torrent: e.torrent}
+			events <- Event{Type: Added, Torrent: NewTorrent(e.torrent)}
 		case <-e.Closed():
-			events <- Event{Type: Closed, Torrent: e.torrent}
+			events <- Event{Type: Closed, Torrent: NewTorrent(e.torrent)}
 			return
 		case <-done:
 			return
@@ -210,9 +248,9 @@ func (e *TorrentEventer) Events(done <-chan struct{}) <-chan Event {
 
 		select {
 		case <-e.GotInfo():
-			events <- Event{Type: GotInfo, Torrent: e.torrent}
+			events <- Event{Type: GotInfo, Torrent: NewTorrent(e.torrent)}
 		case <-e.Closed():
-			events <- Event{Type: Closed, Torrent: e.torrent}
+			events <- Event{Type: Closed, Torrent: NewTorrent(e.torrent)}
 			return
 		case <-done:
 			return
@@ -235,7 +273,7 @@ func (e *TorrentEventer) Events(done <-chan struct{}) <-chan Event {
 						case <-done:
 							return
 						case <-pieceDone:
-							events <- Event{Type: PieceDone, Torrent: e.torrent, Piece: &piece}
+							events <- Event{Type: PieceDone, Torrent: NewTorrent(e.torrent), Piece: &piece}
 						}
 					}(i)
 				}
@@ -260,13 +298,14 @@ func (e *TorrentEventer) Events(done <-chan struct{}) <-chan Event {
 								}(pieceIndex)
 							}
 							pieceWg.Wait()
-							events <- Event{Type: FileDone, Torrent: e.torrent, File: &File{&f}}
+							file := NewFile(&f)
+							events <- Event{Type: FileDone, Torrent: NewTorrent(e.torrent), File: &file}
 						}
 					}(file)
 				}
 			}()
 		case <-e.Closed():
-			events <- Event{Type: Closed, Torrent: e.torrent}
+			events <- Event{Type: Closed, Torrent: NewTorrent(e.torrent)}
 			return
 		case <-done:
 			return
@@ -278,9 +317,9 @@ func (e *TorrentEventer) Events(done <-chan struct{}) <-chan Event {
 
 		select {
 		case <-e.DownloadDone():
-			events <- Event{Type: DownloadDone, Torrent: e.torrent}
+			events <- Event{Type: DownloadDone, Torrent: NewTorrent(e.torrent)}
 		case <-e.Closed():
-			events <- Event{Type: Closed, Torrent: e.torrent}
+			events <- Event{Type: Closed, Torrent: NewTorrent(e.torrent)}
 			return
 		case <-done:
 			return
@@ -288,9 +327,9 @@ func (e *TorrentEventer) Events(done <-chan struct{}) <-chan Event {
 
 		select {
 		case <-e.SeedingDone():
-			events <- Event{Type: SeedingDone, Torrent: e.torrent}
+			events <- Event{Type: SeedingDone, Torrent: NewTorrent(e.torrent)}
 		case <-e.Closed():
-			events <- Event{Type: Closed, Torrent: e.torrent}
+			events <- Event{Type: Closed, Torrent: NewTorrent(e.torrent)}
 			return
 		case <-done:
 			return
@@ -298,7 +337,7 @@ func (e *TorrentEventer) Events(done <-chan struct{}) <-chan Event {
 
 		select {
 		case <-e.Closed():
-			events <- Event{Type: Closed, Torrent: e.torrent}
+			events <- Event{Type: Closed, Torrent: NewTorrent(e.torrent)}
 		case <-done:
 			return
 		}
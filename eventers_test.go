@@ -0,0 +1,71 @@
+package torrential_test
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/joelanford/torrential"
+)
+
+func TestMultiEventerClose(t *testing.T) {
+	svc, err := torrential.NewService(&torrential.Config{
+		ClientConfig: &torrent.Config{
+			ListenAddr:      "localhost:0",
+			NoDHT:           true,
+			DisableTrackers: true,
+		},
+		MemoryStorage: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open("testdata/sample.torrent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	tor, err := svc.AddTorrentReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	events := svc.TorrentsEventer().Events(done)
+	select {
+	case e := <-events:
+		assert.Equal(t, torrential.Added, e.Type)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no added event")
+	}
+
+	assert.NoError(t, svc.Close(context.Background()))
+
+	// The torrent's closed event comes before the final event, after which
+	// the stream ends.
+	var types []torrential.EventType
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				if assert.NotEmpty(t, types) {
+					assert.Equal(t, torrential.ServiceClosed, types[len(types)-1])
+					assert.Contains(t, types[:len(types)-1], torrential.Closed)
+				}
+				return
+			}
+			if e.Type == torrential.Closed {
+				assert.Equal(t, tor.InfoHash, e.InfoHash())
+			}
+			types = append(types, e.Type)
+		case <-timeout:
+			t.Fatalf("event stream was not closed, got %v", types)
+		}
+	}
+}
@@ -0,0 +1,358 @@
+package torrential
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	defaultExecTimeout     = 10 * time.Minute
+	defaultExecConcurrency = 4
+
+	// maxExecQueue is the number of commands that may wait for a free slot.
+	// Commands for events that arrive while the queue is full are dropped.
+	maxExecQueue = 100
+
+	// maxExecOutput is the number of bytes of stdout and stderr that are
+	// kept for each command.
+	maxExecOutput = 64 * 1024
+
+	// maxExecResults is the number of results that are kept per torrent.
+	maxExecResults = 50
+
+	// maxExecTorrents is the number of torrents for which results are kept.
+	// Results are kept after a torrent is dropped, so that the output of
+	// hooks run for dropped torrents can still be inspected.
+	maxExecTorrents = 1000
+)
+
+// ExecHook runs a command for torrent events. The command inherits the
+// service's environment, except for variables starting with TORRENTIAL_ and
+// the MinIO credentials, which may hold secrets. It is run with the following
+// environment variables instead:
+//
+//	TORRENTIAL_EVENT      the event type, e.g. downloadDone
+//	TORRENTIAL_INFO_HASH  the torrent info hash
+//	TORRENTIAL_NAME       the torrent name
+//	TORRENTIAL_DATA_PATH  the path of the torrent data
+//	TORRENTIAL_FILE_PATH  the path of the file, for fileDone events
+//	TORRENTIAL_PIECE      the piece index, for pieceDone events
+//
+// Only TORRENTIAL_EVENT is set for events without a torrent, such as
+// CacheLoaded. The paths are only set if the data is stored in
+// ClientConfig.DataDir, the torrent has its info, and its name and file paths
+// don't reach outside of the torrent's directory, e.g. with "..". Results are
+// only kept for events with a torrent.
+type ExecHook struct {
+	Command string   `json:"command"`
+	Args    []string `json:"args,omitempty"`
+
+	// EventTypes limits the hook to the given event types. If empty, the hook
+	// is run for DownloadDone, SeedingDone and Closed events.
+	EventTypes []EventType `json:"eventTypes,omitempty"`
+
+	// Timeout overrides Config.ExecTimeout for this hook.
+	Timeout time.Duration `json:"timeout,omitempty"`
+}
+
+// defaultExecEventTypes are the event types of hooks without EventTypes. Events
+// such as PieceDone are sent too often to start a command for each of them
+// unless asked to.
+var defaultExecEventTypes = []EventType{DownloadDone, SeedingDone, Closed}
+
+func (h ExecHook) matches(e Event) bool {
+	types := h.EventTypes
+	if len(types) == 0 {
+		types = defaultExecEventTypes
+	}
+	for _, t := range types {
+		if t == e.Type {
+			return true
+		}
+	}
+	return false
+}
+
+// ExecResult is the outcome of running an ExecHook.
+type ExecResult struct {
+	Command   string    `json:"command"`
+	Args      []string  `json:"args,omitempty"`
+	EventType string    `json:"eventType"`
+	Started   time.Time `json:"started"`
+	Duration  string    `json:"duration"`
+	ExitCode  int       `json:"exitCode"`
+	Stdout    string    `json:"stdout"`
+	Stderr    string    `json:"stderr"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// execRunner runs exec hooks in the background, with at most a fixed number
+// of commands running at the same time. Commands that can't run yet wait in a
+// queue of at most maxExecQueue commands.
+type execRunner struct {
+	hooks       []ExecHook
+	timeout     time.Duration
+	dataDir     string
+	localData   bool
+	concurrency int
+	running     sync.WaitGroup
+
+	queue   []execJob
+	workers int
+	queueMu sync.Mutex
+
+	results   map[string][]ExecResult
+	order     []string
+	resultsMu sync.RWMutex
+}
+
+// newExecRunner returns the runner of the exec hooks of conf. localData tells
+// whether torrent data is stored in ClientConfig.DataDir.
+func newExecRunner(conf *Config, localData bool) *execRunner {
+	r := &execRunner{
+		hooks:     conf.ExecHooks,
+		timeout:   conf.ExecTimeout,
+		dataDir:   conf.ClientConfig.DataDir,
+		localData: localData,
+		results:   make(map[string][]ExecResult),
+	}
+	if r.timeout <= 0 {
+		r.timeout = defaultExecTimeout
+	}
+	r.concurrency = conf.ExecConcurrency
+	if r.concurrency <= 0 {
+		r.concurrency = defaultExecConcurrency
+	}
+	return r
+}
+
+type execJob struct {
+	hook  ExecHook
+	event Event
+}
+
+// dispatch queues each hook that matches the event, starting a worker if fewer
+// than r.concurrency are running. Hooks are dropped if the queue is full.
+func (r *execRunner) dispatch(e Event) {
+	var dropped []ExecHook
+	r.queueMu.Lock()
+	for _, h := range r.hooks {
+		if !h.matches(e) {
+			continue
+		}
+		if len(r.queue) >= maxExecQueue {
+			dropped = append(dropped, h)
+			continue
+		}
+		r.running.Add(1)
+		r.queue = append(r.queue, execJob{hook: h, event: e})
+		if r.workers < r.concurrency {
+			r.workers++
+			go r.work()
+		}
+	}
+	r.queueMu.Unlock()
+
+	for _, h := range dropped {
+		log.Printf("exec queue is full, dropping exec hook %s for %s event for torrent %s", h.Command, e.Type, e.InfoHash())
+		if infoHash := e.InfoHash(); infoHash != "" {
+			r.record(infoHash, ExecResult{
+				Command:   h.Command,
+				Args:      h.Args,
+				EventType: e.Type.String(),
+				Started:   time.Now(),
+				Error:     "dropped because the exec queue is full",
+			})
+		}
+	}
+}
+
+// work runs the queued commands one by one until the queue is empty.
+func (r *execRunner) work() {
+	for {
+		r.queueMu.Lock()
+		if len(r.queue) == 0 {
+			r.workers--
+			r.queueMu.Unlock()
+			return
+		}
+		job := r.queue[0]
+		r.queue[0] = execJob{}
+		r.queue = r.queue[1:]
+		r.queueMu.Unlock()
+
+		r.run(job.hook, job.event)
+	}
+}
+
+// wait waits until the running commands have exited, or ctx is done.
+func (r *execRunner) wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		r.running.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (r *execRunner) run(h ExecHook, e Event) {
+	defer r.running.Done()
+
+	timeout := h.Timeout
+	if timeout <= 0 {
+		timeout = r.timeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	stdout := &limitedBuffer{limit: maxExecOutput}
+	stderr := &limitedBuffer{limit: maxExecOutput}
+
+	cmd := exec.CommandContext(ctx, h.Command, h.Args...)
+	cmd.Env = append(hookEnviron(os.Environ()), r.env(e)...)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	result := ExecResult{
+		Command:   h.Command,
+		Args:      h.Args,
+		EventType: e.Type.String(),
+		Started:   time.Now(),
+	}
+	err := cmd.Run()
+	result.Duration = time.Since(result.Started).String()
+	result.Stdout = stdout.String()
+	result.Stderr = stderr.String()
+	if cmd.ProcessState != nil {
+		if status, ok := cmd.ProcessState.Sys().(syscall.WaitStatus); ok {
+			result.ExitCode = status.ExitStatus()
+		}
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("timed out after %s", timeout)
+	}
+	if err != nil {
+		result.Error = err.Error()
+		log.Printf("error running exec hook %s for %s event for torrent %s: %s", h.Command, e.Type, e.InfoHash(), err)
+	}
+	if infoHash := e.InfoHash(); infoHash != "" {
+		r.record(infoHash, result)
+	}
+}
+
+func (r *execRunner) env(e Event) []string {
+	env := []string{
+		"TORRENTIAL_EVENT=" + e.Type.String(),
+	}
+	if e.Torrent.InfoHash == "" {
+		return env
+	}
+	env = append(env,
+		"TORRENTIAL_INFO_HASH="+e.Torrent.InfoHash,
+		"TORRENTIAL_NAME="+e.Torrent.Name,
+	)
+	// Without its info, a torrent has no name, and its data path would be the
+	// whole data directory.
+	if r.localData && e.Torrent.HasInfo && isSafePath(e.Torrent.Name) {
+		env = append(env, "TORRENTIAL_DATA_PATH="+filepath.Join(r.dataDir, e.Torrent.Name))
+		if e.File != nil && isSafePath(e.File.Path) {
+			env = append(env, "TORRENTIAL_FILE_PATH="+filepath.Join(r.dataDir, filepath.FromSlash(e.File.Path)))
+		}
+	}
+	if e.Piece != nil {
+		env = append(env, fmt.Sprintf("TORRENTIAL_PIECE=%d", *e.Piece))
+	}
+	return env
+}
+
+// isSafePath reports whether p, a slash-separated path from a torrent, stays
+// within the directory it is joined to.
+func isSafePath(p string) bool {
+	for _, part := range strings.Split(p, "/") {
+		if part == "" || part == "." || part == ".." || strings.ContainsRune(part, filepath.Separator) {
+			return false
+		}
+	}
+	return true
+}
+
+// hookEnviron returns the environment of the service without the variables
+// that configure it, which may hold secrets like TORRENTIAL_WEBHOOK_SECRET or
+// TORRENTIAL_CACHE_KEY, and without the MinIO credentials.
+func hookEnviron(environ []string) []string {
+	var env []string
+	for _, kv := range environ {
+		if strings.HasPrefix(kv, "TORRENTIAL_") || strings.HasPrefix(kv, "MINIO_ACCESS_KEY=") || strings.HasPrefix(kv, "MINIO_SECRET_KEY=") {
+			continue
+		}
+		env = append(env, kv)
+	}
+	return env
+}
+
+func (r *execRunner) record(infoHash string, result ExecResult) {
+	r.resultsMu.Lock()
+	defer r.resultsMu.Unlock()
+	results, ok := r.results[infoHash]
+	if !ok {
+		r.order = append(r.order, infoHash)
+		if len(r.order) > maxExecTorrents {
+			delete(r.results, r.order[0])
+			r.order = r.order[1:]
+		}
+	}
+	results = append(results, result)
+	if len(results) > maxExecResults {
+		results = results[len(results)-maxExecResults:]
+	}
+	r.results[infoHash] = results
+}
+
+func (r *execRunner) resultsFor(infoHash string) ([]ExecResult, bool) {
+	r.resultsMu.RLock()
+	defer r.resultsMu.RUnlock()
+	stored, ok := r.results[infoHash]
+	results := make([]ExecResult, len(stored))
+	copy(results, stored)
+	return results, ok
+}
+
+// limitedBuffer is an io.Writer that keeps the first limit bytes written to
+// it and discards the rest.
+type limitedBuffer struct {
+	buf       bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if remaining := b.limit - b.buf.Len(); remaining < len(p) {
+		b.truncated = true
+		if remaining > 0 {
+			b.buf.Write(p[:remaining])
+		}
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
+
+func (b *limitedBuffer) String() string {
+	if b.truncated {
+		return b.buf.String() + "\n[output truncated]"
+	}
+	return b.buf.String()
+}
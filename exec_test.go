@@ -0,0 +1,63 @@
+package torrential_test
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/anacrolix/torrent"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/joelanford/torrential"
+)
+
+func TestExecHooks(t *testing.T) {
+	// Hooks don't inherit the configuration of the service.
+	os.Setenv("TORRENTIAL_WEBHOOK_SECRET", "secret")
+	defer os.Unsetenv("TORRENTIAL_WEBHOOK_SECRET")
+
+	svc, err := torrential.NewService(&torrential.Config{
+		ClientConfig: &torrent.Config{
+			ListenAddr:      "localhost:0",
+			NoDHT:           true,
+			DisableTrackers: true,
+		},
+		MemoryStorage: true,
+		ExecHooks: []torrential.ExecHook{
+			{
+				Command:    "sh",
+				Args:       []string{"-c", `echo "$TORRENTIAL_EVENT $TORRENTIAL_NAME ${TORRENTIAL_DATA_PATH-none} ${TORRENTIAL_WEBHOOK_SECRET-none}"`},
+				EventTypes: []torrential.EventType{torrential.Added},
+			},
+			// Hooks without event types aren't run for added events.
+			{Command: "true"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer svc.Close(context.Background())
+
+	file, err := os.Open("testdata/sample.torrent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	added, err := svc.AddTorrentReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var results []torrential.ExecResult
+	waitFor(t, "exec hook", func() bool {
+		results, err = svc.ExecResults(added.InfoHash)
+		return err == nil && len(results) > 0
+	})
+	if assert.Len(t, results, 1) {
+		assert.Equal(t, "sh", results[0].Command)
+		assert.Equal(t, "added", results[0].EventType)
+		assert.Equal(t, 0, results[0].ExitCode)
+		// Data in memory storage has no path.
+		assert.Equal(t, "added sample.txt none none\n", results[0].Stdout)
+	}
+}
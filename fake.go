@@ -0,0 +1,521 @@
+package torrential
+
+import (
+	"context"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/pkg/errors"
+
+	"github.com/joelanford/torrential/cache"
+)
+
+// FakeService is an in-memory TorrentService for tests of code that uses
+// Handler or a TorrentService. Torrents are kept as snapshots built from their
+// metainfo or magnet link, without a torrent client, so they never download
+// and tests don't open any sockets. Events are only sent when the test calls
+// Send, which also invokes the matching webhooks.
+type FakeService struct {
+	webhooks *webhookDispatcher
+	format   EventFormat
+	source   string
+
+	torrents    map[string]Torrent
+	labels      map[string][]string
+	execResults map[string][]ExecResult
+	deadLetters map[string]WebhookDelivery
+	report      *cache.LoadReport
+	owner       string
+	leases      []cache.Lease
+	subscribers map[*fakeSubscriber]struct{}
+	mu          sync.RWMutex
+}
+
+var _ TorrentService = &FakeService{}
+
+func NewFakeService() (*FakeService, error) {
+	webhooks, err := newWebhookDispatcher(&Config{EventFormat: FormatJSON, EventSource: defaultEventSource})
+	if err != nil {
+		return nil, err
+	}
+	return &FakeService{
+		webhooks:    webhooks,
+		format:      FormatJSON,
+		source:      defaultEventSource,
+		torrents:    make(map[string]Torrent),
+		labels:      make(map[string][]string),
+		execResults: make(map[string][]ExecResult),
+		deadLetters: make(map[string]WebhookDelivery),
+		report:      &cache.LoadReport{},
+		subscribers: make(map[*fakeSubscriber]struct{}),
+	}, nil
+}
+
+// Close ends all event streams and stops the webhook deliveries.
+func (f *FakeService) Close() {
+	f.mu.Lock()
+	for s := range f.subscribers {
+		s.stop()
+	}
+	f.mu.Unlock()
+	f.webhooks.stop()
+}
+
+// Torrents returns the torrents sorted by info hash.
+func (f *FakeService) Torrents() []Torrent {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	torrents := make([]Torrent, 0, len(f.torrents))
+	for _, t := range f.torrents {
+		torrents = append(torrents, t)
+	}
+	sort.Slice(torrents, func(i, j int) bool {
+		return torrents[i].InfoHash < torrents[j].InfoHash
+	})
+	return torrents
+}
+
+func (f *FakeService) Torrent(infoHash string) (*Torrent, error) {
+	var h metainfo.Hash
+	if err := h.FromHexString(infoHash); err != nil {
+		return nil, errors.Wrap(parseErr{err}, "bad torrent hash")
+	}
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	t, ok := f.torrents[h.HexString()]
+	if !ok {
+		return nil, notFoundErr{errors.New("torrent not found")}
+	}
+	return &t, nil
+}
+
+func (f *FakeService) AddTorrentReader(torrentReader io.Reader, options ...AddOptionFunc) (*Torrent, error) {
+	return f.AddTorrentReaderContext(context.Background(), torrentReader, options...)
+}
+
+func (f *FakeService) AddTorrentReaderContext(ctx context.Context, torrentReader io.Reader, options ...AddOptionFunc) (*Torrent, error) {
+	mi, err := loadMetainfo(torrentReader, defaultMaxTorrentSize)
+	if err != nil {
+		return nil, err
+	}
+	return f.addMetainfo(mi, options...)
+}
+
+func (f *FakeService) AddTorrentURL(torrentURL string, options ...AddOptionFunc) (*Torrent, error) {
+	return f.AddTorrentURLContext(context.Background(), torrentURL, options...)
+}
+
+func (f *FakeService) AddTorrentURLContext(ctx context.Context, torrentURL string, options ...AddOptionFunc) (*Torrent, error) {
+	// Tests serve torrent files from local servers, so private addresses are
+	// allowed.
+	client := newFetchClient(defaultFetchTimeout, FetchPolicy{AllowPrivate: true})
+	mi, err := fetchMetainfo(ctx, client, torrentURL, defaultMaxTorrentSize)
+	if err != nil {
+		return nil, err
+	}
+	return f.addMetainfo(mi, options...)
+}
+
+func (f *FakeService) AddMagnetURI(magnetURI string, options ...AddOptionFunc) (*Torrent, error) {
+	return f.AddMagnetURIContext(context.Background(), magnetURI, options...)
+}
+
+// AddMagnetURIContext adds a torrent without info, which it never receives.
+func (f *FakeService) AddMagnetURIContext(ctx context.Context, magnetURI string, options ...AddOptionFunc) (*Torrent, error) {
+	spec, err := torrent.TorrentSpecFromMagnetURI(magnetURI)
+	if err != nil {
+		return nil, errors.Wrap(parseErr{err}, "could not parse spec from magnet URI")
+	}
+	var trackers []string
+	for _, tier := range spec.Trackers {
+		trackers = append(trackers, tier...)
+	}
+	magnet := metainfo.Magnet{InfoHash: spec.InfoHash, Trackers: trackers, DisplayName: spec.DisplayName}
+	return f.addTorrent(Torrent{
+		Files:      make([]File, 0),
+		InfoHash:   spec.InfoHash.HexString(),
+		MagnetLink: magnet.String(),
+		Name:       spec.DisplayName,
+	}, options...)
+}
+
+// addMetainfo adds a torrent with the info of mi, none of which is
+// downloaded.
+func (f *FakeService) addMetainfo(mi *metainfo.MetaInfo, options ...AddOptionFunc) (*Torrent, error) {
+	info, err := mi.UnmarshalInfo()
+	if err != nil {
+		return nil, errors.Wrap(parseErr{err}, "could not parse torrent info")
+	}
+	infoHash := mi.HashInfoBytes()
+	t := Torrent{
+		BytesMissing: int(info.TotalLength()),
+		Files:        make([]File, 0),
+		InfoHash:     infoHash.HexString(),
+		Length:       int(info.TotalLength()),
+		MagnetLink:   mi.Magnet(info.Name, infoHash).String(),
+		Name:         info.Name,
+		NumPieces:    info.NumPieces(),
+		HasInfo:      true,
+	}
+	// The paths are built like those of anacrolix/torrent files.
+	var offset int64
+	for _, fi := range info.UpvertedFiles() {
+		file := File{
+			DisplayPath: info.Name,
+			Length:      int(fi.Length),
+			Offset:      int(offset),
+			Path:        info.Name,
+		}
+		if len(fi.Path) > 0 {
+			file.DisplayPath = strings.Join(fi.Path, "/")
+			file.Path = strings.Join(append([]string{info.Name}, fi.Path...), "/")
+		}
+		t.Files = append(t.Files, file)
+		offset += fi.Length
+	}
+	return f.addTorrent(t, options...)
+}
+
+func (f *FakeService) addTorrent(t Torrent, options ...AddOptionFunc) (*Torrent, error) {
+	opts := ResolveAddOptions(options...)
+	t.Priority = PriorityNormal
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.torrents[t.InfoHash]; ok {
+		return nil, existsErr{errors.New("torrent already exists")}
+	}
+	f.torrents[t.InfoHash] = t
+	if len(opts.Labels) > 0 {
+		f.labels[t.InfoHash] = opts.Labels
+	}
+	return &t, nil
+}
+
+// Drop drops the torrent and ends the event streams of the torrent.
+func (f *FakeService) Drop(infoHash string, deleteFiles bool) error {
+	t, err := f.Torrent(infoHash)
+	if err != nil {
+		return err
+	}
+	infoHash = t.InfoHash
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.torrents, infoHash)
+	delete(f.labels, infoHash)
+	for s := range f.subscribers {
+		if s.infoHash == infoHash {
+			s.stop()
+		}
+	}
+	return nil
+}
+
+func (f *FakeService) Labels(infoHash string) ([]string, error) {
+	t, err := f.Torrent(infoHash)
+	if err != nil {
+		return nil, err
+	}
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.labels[t.InfoHash], nil
+}
+
+func (f *FakeService) SetLabels(infoHash string, labels []string) error {
+	t, err := f.Torrent(infoHash)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	f.labels[t.InfoHash] = labels
+	f.mu.Unlock()
+	return nil
+}
+
+// Pause records the torrent as paused. The fake's torrents never download,
+// so nothing else changes, and the same goes for Resume and SetPriority.
+func (f *FakeService) Pause(infoHash string) error {
+	return f.updateControl(infoHash, func(c *torrentControl) {
+		c.paused = true
+	})
+}
+
+func (f *FakeService) Resume(infoHash string) error {
+	return f.updateControl(infoHash, func(c *torrentControl) {
+		c.paused = false
+	})
+}
+
+func (f *FakeService) SetPriority(infoHash string, priority Priority) error {
+	if _, err := ParsePriority(string(priority)); err != nil {
+		return err
+	}
+	return f.updateControl(infoHash, func(c *torrentControl) {
+		c.priority = priority
+	})
+}
+
+func (f *FakeService) updateControl(infoHash string, update func(c *torrentControl)) error {
+	t, err := f.Torrent(infoHash)
+	if err != nil {
+		return err
+	}
+	c := torrentControl{paused: t.Paused, priority: t.Priority}
+	update(&c)
+	t.Paused, t.Priority = c.paused, c.priority
+	f.mu.Lock()
+	if _, ok := f.torrents[t.InfoHash]; ok {
+		f.torrents[t.InfoHash] = *t
+	}
+	f.mu.Unlock()
+	return nil
+}
+
+func (f *FakeService) ExecResults(infoHash string) ([]ExecResult, error) {
+	f.mu.RLock()
+	results, ok := f.execResults[infoHash]
+	f.mu.RUnlock()
+	if !ok {
+		if _, err := f.Torrent(infoHash); err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
+
+// SetExecResults sets the exec hook results of a torrent.
+func (f *FakeService) SetExecResults(infoHash string, results []ExecResult) {
+	f.mu.Lock()
+	f.execResults[infoHash] = results
+	f.mu.Unlock()
+}
+
+func (f *FakeService) TorrentsEventer() Eventer {
+	return fakeEventer{f, ""}
+}
+
+func (f *FakeService) TorrentEventer(infoHash string) (Eventer, error) {
+	if _, err := f.Torrent(infoHash); err != nil {
+		return nil, err
+	}
+	return fakeEventer{f, infoHash}, nil
+}
+
+func (f *FakeService) EventFormat() EventFormat {
+	return f.format
+}
+
+func (f *FakeService) EventSource() string {
+	return f.source
+}
+
+// Send sends an event to the event streams of all torrents and, if the event
+// has a torrent, to the event streams of that torrent. It blocks until every
+// stream has queued the event. Webhooks that match the event and the labels
+// of its torrent are invoked in the background.
+func (f *FakeService) Send(e Event) {
+	f.mu.RLock()
+	labels := f.labels[e.InfoHash()]
+	f.mu.RUnlock()
+	f.webhooks.dispatch(e, labels)
+
+	f.mu.RLock()
+	var subscribers []*fakeSubscriber
+	for s := range f.subscribers {
+		if s.infoHash == "" || s.infoHash == e.InfoHash() {
+			subscribers = append(subscribers, s)
+		}
+	}
+	f.mu.RUnlock()
+	for _, s := range subscribers {
+		select {
+		case s.queue <- e:
+		case <-s.gone:
+		}
+	}
+}
+
+func (f *FakeService) CacheReport() *cache.LoadReport {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.report
+}
+
+// SetCacheReport sets the report returned by CacheReport.
+func (f *FakeService) SetCacheReport(report *cache.LoadReport) {
+	f.mu.Lock()
+	f.report = report
+	f.mu.Unlock()
+}
+
+func (f *FakeService) Leases() []cache.Lease {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.leases
+}
+
+func (f *FakeService) InstanceID() string {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.owner
+}
+
+// SetLeases sets the instance ID and leases returned by InstanceID and Leases.
+func (f *FakeService) SetLeases(owner string, leases []cache.Lease) {
+	f.mu.Lock()
+	f.owner = owner
+	f.leases = leases
+	f.mu.Unlock()
+}
+
+func (f *FakeService) Webhooks() []Webhook {
+	return f.webhooks.webhooks()
+}
+
+func (f *FakeService) Webhook(id string) (*Webhook, error) {
+	return f.webhooks.webhook(id)
+}
+
+func (f *FakeService) AddWebhook(w Webhook) (*Webhook, error) {
+	return f.webhooks.addWebhook(w)
+}
+
+func (f *FakeService) UpdateWebhook(id string, w Webhook) (*Webhook, error) {
+	return f.webhooks.updateWebhook(id, w)
+}
+
+func (f *FakeService) DeleteWebhook(id string) error {
+	return f.webhooks.deleteWebhook(id)
+}
+
+func (f *FakeService) DeadLetters() []WebhookDelivery {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	deliveries := make([]WebhookDelivery, 0, len(f.deadLetters))
+	for _, del := range f.deadLetters {
+		deliveries = append(deliveries, del)
+	}
+	sort.Slice(deliveries, func(i, j int) bool {
+		return deliveries[i].Created.Before(deliveries[j].Created)
+	})
+	return deliveries
+}
+
+func (f *FakeService) DeadLetter(id string) (*WebhookDelivery, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	del, ok := f.deadLetters[id]
+	if !ok {
+		return nil, notFoundErr{errors.New("dead letter not found")}
+	}
+	return &del, nil
+}
+
+// ReplayDeadLetter removes the dead letter and returns it as if it was queued
+// again. It is not delivered.
+func (f *FakeService) ReplayDeadLetter(id string) (*WebhookDelivery, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	del, ok := f.deadLetters[id]
+	if !ok {
+		return nil, notFoundErr{errors.New("dead letter not found")}
+	}
+	delete(f.deadLetters, id)
+	del.Dead = false
+	del.Attempts = 0
+	del.LastError = ""
+	return &del, nil
+}
+
+func (f *FakeService) DeleteDeadLetter(id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.deadLetters[id]; !ok {
+		return notFoundErr{errors.New("dead letter not found")}
+	}
+	delete(f.deadLetters, id)
+	return nil
+}
+
+// AddDeadLetter adds a dead letter, as if the delivery had run out of
+// attempts.
+func (f *FakeService) AddDeadLetter(del WebhookDelivery) {
+	del.Dead = true
+	f.mu.Lock()
+	f.deadLetters[del.ID] = del
+	f.mu.Unlock()
+}
+
+// fakeEventer streams the events sent with FakeService.Send for a torrent, or
+// for all torrents if infoHash is empty.
+type fakeEventer struct {
+	f        *FakeService
+	infoHash string
+}
+
+type fakeSubscriber struct {
+	infoHash string
+	queue    chan Event
+	stopped  chan struct{}
+	gone     chan struct{}
+	once     sync.Once
+}
+
+func (s *fakeSubscriber) stop() {
+	s.once.Do(func() { close(s.stopped) })
+}
+
+func (e fakeEventer) Events(done <-chan struct{}) <-chan Event {
+	s := &fakeSubscriber{
+		infoHash: e.infoHash,
+		queue:    make(chan Event, 16),
+		stopped:  make(chan struct{}),
+		gone:     make(chan struct{}),
+	}
+	e.f.mu.Lock()
+	e.f.subscribers[s] = struct{}{}
+	e.f.mu.Unlock()
+
+	events := make(chan Event)
+	go func() {
+		defer close(events)
+		defer close(s.gone)
+		defer func() {
+			e.f.mu.Lock()
+			delete(e.f.subscribers, s)
+			e.f.mu.Unlock()
+		}()
+		for {
+			select {
+			case ev := <-s.queue:
+				select {
+				case events <- ev:
+				case <-done:
+					return
+				}
+			case <-s.stopped:
+				// Events sent before the stream was stopped are still
+				// delivered.
+				for {
+					select {
+					case ev := <-s.queue:
+						select {
+						case events <- ev:
+						case <-done:
+							return
+						}
+					default:
+						return
+					}
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return events
+}
@@ -0,0 +1,72 @@
+package torrential_test
+
+import (
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/joelanford/torrential"
+	"github.com/joelanford/torrential/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFakeService(t *testing.T) {
+	f, err := torrential.NewFakeService()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	srv := httptest.NewServer(torrential.Handler("/", f))
+	defer srv.Close()
+	c, err := client.New(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.Open("testdata/sample.torrent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	added, err := c.AddTorrentReader(file, "test")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "sample.txt", added.Name)
+		assert.Equal(t, "d0d14c926e6e99761a2fdcff27b403d96376eff6", added.InfoHash)
+		assert.True(t, added.HasInfo)
+		assert.Equal(t, added.Length, added.BytesMissing)
+		if assert.Len(t, added.Files, 1) {
+			assert.Equal(t, "sample.txt", added.Files[0].Path)
+		}
+	}
+	paused, err := c.Pause("d0d14c926e6e99761a2fdcff27b403d96376eff6")
+	if assert.NoError(t, err) {
+		assert.True(t, paused.Paused)
+	}
+	labels, err := c.Labels("d0d14c926e6e99761a2fdcff27b403d96376eff6")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"test"}, labels)
+
+	// Events are only sent by the test. The stream connects asynchronously,
+	// so send the event until it arrives.
+	done := make(chan struct{})
+	defer close(done)
+	events := c.TorrentEvents("d0d14c926e6e99761a2fdcff27b403d96376eff6", done)
+	tor, _ := f.Torrent("d0d14c926e6e99761a2fdcff27b403d96376eff6")
+	var got *client.Event
+	for i := 0; i < 100 && got == nil; i++ {
+		f.Send(torrential.Event{Type: torrential.DownloadDone, Torrent: *tor})
+		select {
+		case e := <-events:
+			got = &e
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	if assert.NotNil(t, got) {
+		assert.Equal(t, torrential.DownloadDone, got.Type)
+	}
+
+	assert.NoError(t, c.Drop("d0d14c926e6e99761a2fdcff27b403d96376eff6", false))
+	_, err = c.Torrent("d0d14c926e6e99761a2fdcff27b403d96376eff6")
+	assert.True(t, client.IsNotFound(err))
+}
@@ -0,0 +1,282 @@
+package torrential
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultFetchTimeout      = 30 * time.Second
+	defaultMaxTorrentSize    = 10 << 20
+	defaultInfoTimeout       = time.Minute
+	defaultFetchMaxRedirects = 5
+)
+
+// FetchPolicy restricts the URLs that AddTorrentURL fetches torrent files
+// from, so that clients of the API can't use the service to reach internal
+// services. Its zero value allows http and https URLs of public hosts.
+type FetchPolicy struct {
+	// Schemes are the allowed URL schemes. They default to http and https.
+	Schemes []string
+
+	// AllowHosts, if not empty, are the only hosts that torrent files are
+	// fetched from. DenyHosts are hosts that torrent files are never fetched
+	// from. Hosts are matched by name, or by subdomain if they start with
+	// "*.", e.g. "*.example.com".
+	AllowHosts []string
+	DenyHosts  []string
+
+	// AllowPrivate allows fetching from loopback, private, link-local,
+	// multicast, reserved and unspecified addresses, and from the IPv6
+	// addresses that translate to IPv4 addresses. The addresses are checked
+	// when connecting, so host names that resolve to private addresses are
+	// blocked too.
+	AllowPrivate bool
+
+	// MaxRedirects is the maximum number of redirects that are followed. It
+	// defaults to 5. If negative, redirects are rejected with a forbidden
+	// error.
+	MaxRedirects int
+
+	// Headers and Cookies are added to requests to the host they are keyed
+	// by, e.g. for the passkeys of private trackers. They are not sent to
+	// the hosts of redirects.
+	Headers map[string]http.Header
+	Cookies map[string][]*http.Cookie
+}
+
+// privateNetworks are the networks that are blocked unless
+// FetchPolicy.AllowPrivate is set, in addition to loopback, link-local,
+// multicast and unspecified addresses. NAT64 and 6to4 addresses are blocked
+// as a whole, since they can reach any IPv4 address, including private ones.
+var privateNetworks = parseCIDRs(
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"198.18.0.0/15",
+	"240.0.0.0/4",
+	"fc00::/7",
+	"64:ff9b::/96",
+	"2002::/16",
+)
+
+func parseCIDRs(cidrs ...string) []*net.IPNet {
+	var networks []*net.IPNet
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+func isPrivateIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return true
+	}
+	for _, network := range privateNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkURL returns a forbiddenErr if the policy doesn't allow u.
+func (p *FetchPolicy) checkURL(u *url.URL) error {
+	schemes := p.Schemes
+	if len(schemes) == 0 {
+		schemes = []string{"http", "https"}
+	}
+	if !containsFold(schemes, u.Scheme) {
+		return forbiddenErr{errors.Errorf("URL scheme %q is not allowed", u.Scheme)}
+	}
+	host := u.Hostname()
+	if matchHost(p.DenyHosts, host) || (len(p.AllowHosts) > 0 && !matchHost(p.AllowHosts, host)) {
+		return forbiddenErr{errors.Errorf("host %q is not allowed", host)}
+	}
+	return nil
+}
+
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchHost(patterns []string, host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(pattern)
+		if strings.HasPrefix(pattern, "*.") {
+			if strings.HasSuffix(host, pattern[1:]) {
+				return true
+			}
+		} else if host == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// dialContext connects to an allowed address of the host of addr. Host names
+// are resolved here, rather than by the dialer, so that the address that is
+// checked is the one connected to.
+func (p *FetchPolicy) dialContext(dialer *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		var lastErr error
+		for _, a := range addrs {
+			if !p.AllowPrivate && isPrivateIP(a.IP) {
+				lastErr = forbiddenErr{errors.Errorf("address %s of host %q is private", a.IP, host)}
+				continue
+			}
+			conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(a.IP.String(), port))
+			if err == nil {
+				return conn, nil
+			}
+			lastErr = err
+		}
+		if lastErr == nil {
+			lastErr = errors.Errorf("no addresses found for host %q", host)
+		}
+		return nil, lastErr
+	}
+}
+
+// policyTransport checks each request, including redirects, against the
+// policy, and adds the headers and cookies of its host.
+type policyTransport struct {
+	policy    *FetchPolicy
+	transport http.RoundTripper
+}
+
+func (t *policyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.policy.checkURL(req.URL); err != nil {
+		return nil, err
+	}
+	host := req.URL.Hostname()
+	header, cookies := t.policy.Headers[host], t.policy.Cookies[host]
+	if len(header) > 0 || len(cookies) > 0 {
+		// A RoundTripper must not modify the request.
+		r := *req
+		r.Header = make(http.Header)
+		for k, v := range req.Header {
+			r.Header[k] = v
+		}
+		for k, v := range header {
+			r.Header[k] = v
+		}
+		for _, c := range cookies {
+			r.AddCookie(c)
+		}
+		req = &r
+	}
+	return t.transport.RoundTrip(req)
+}
+
+// newFetchClient returns the client that fetches torrent files under the
+// policy.
+func newFetchClient(timeout time.Duration, policy FetchPolicy) *http.Client {
+	maxRedirects := policy.MaxRedirects
+	if maxRedirects == 0 {
+		maxRedirects = defaultFetchMaxRedirects
+	}
+	redirectErr := errors.New("redirects are not allowed")
+	if maxRedirects > 0 {
+		redirectErr = errors.Errorf("stopped after %d redirects", maxRedirects)
+	}
+	dialer := &net.Dialer{Timeout: timeout, KeepAlive: 30 * time.Second}
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &policyTransport{
+			policy: &policy,
+			transport: &http.Transport{
+				DialContext:         policy.dialContext(dialer),
+				TLSHandshakeTimeout: 10 * time.Second,
+				MaxIdleConns:        10,
+				IdleConnTimeout:     90 * time.Second,
+			},
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > maxRedirects {
+				return forbiddenErr{redirectErr}
+			}
+			// Don't send the headers of the first request, such as the
+			// headers of its host, to the redirect target.
+			req.Header = make(http.Header)
+			return nil
+		},
+	}
+}
+
+// loadMetainfo reads a torrent file of at most maxSize bytes.
+func loadMetainfo(r io.Reader, maxSize int64) (*metainfo.MetaInfo, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(r, maxSize+1))
+	if err != nil {
+		return nil, errors.Wrap(readErr{err}, "could not read torrent")
+	}
+	if int64(len(data)) > maxSize {
+		return nil, tooLargeErr{errors.Errorf("torrent is larger than %d bytes", maxSize)}
+	}
+	mi, err := metainfo.Load(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.Wrap(parseErr{err}, "could not parse spec from torrent")
+	}
+	return mi, nil
+}
+
+// fetchMetainfo fetches a torrent file of at most maxSize bytes. The request
+// is canceled when ctx is done. URLs that the client's policy doesn't allow
+// return a forbiddenErr, and responses with a non-2xx status a fetchErr.
+func fetchMetainfo(ctx context.Context, client *http.Client, torrentURL string, maxSize int64) (*metainfo.MetaInfo, error) {
+	req, err := http.NewRequest("GET", torrentURL, nil)
+	if err != nil {
+		return nil, errors.Wrap(parseErr{err}, "bad torrent URL")
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		if urlErr, ok := err.(*url.Error); ok {
+			if _, ok := errors.Cause(urlErr.Err).(forbiddenErr); ok {
+				return nil, errors.Wrap(urlErr.Err, "could not fetch torrent")
+			}
+		}
+		return nil, errors.Wrap(fetchErr{err}, "could not fetch torrent")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, errors.Wrap(fetchErr{errors.Errorf("unexpected status %s", resp.Status)}, "could not fetch torrent")
+	}
+	if resp.ContentLength > maxSize {
+		return nil, tooLargeErr{errors.Errorf("torrent is larger than %d bytes", maxSize)}
+	}
+	mi, err := loadMetainfo(resp.Body, maxSize)
+	if _, ok := errors.Cause(err).(readErr); ok {
+		return nil, errors.Wrap(fetchErr{err}, "could not fetch torrent")
+	}
+	return mi, err
+}
@@ -0,0 +1,125 @@
+package torrential_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/anacrolix/torrent"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/joelanford/torrential"
+)
+
+func isForbidden(err error) bool {
+	e, ok := errors.Cause(err).(interface {
+		IsForbidden() bool
+	})
+	return ok && e.IsForbidden()
+}
+
+func isFetchError(err error) bool {
+	e, ok := errors.Cause(err).(interface {
+		IsFetchError() bool
+	})
+	return ok && e.IsFetchError()
+}
+
+func newFetchService(t *testing.T, policy torrential.FetchPolicy) *torrential.Service {
+	svc, err := torrential.NewService(&torrential.Config{
+		ClientConfig: &torrent.Config{
+			ListenAddr:      "localhost:0",
+			NoDHT:           true,
+			DisableTrackers: true,
+		},
+		MemoryStorage: true,
+		FetchPolicy:   policy,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return svc
+}
+
+func TestFetchPolicy(t *testing.T) {
+	var cookie string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cookie = r.Header.Get("Cookie")
+		http.NotFound(w, r)
+	}))
+	defer srv.Close()
+
+	svc := newFetchService(t, torrential.FetchPolicy{})
+	_, err := svc.AddTorrentURL(srv.URL)
+	assert.True(t, isForbidden(err), "private address: %v", err)
+	_, err = svc.AddTorrentURL("ftp://example.com/file.torrent")
+	assert.True(t, isForbidden(err), "scheme: %v", err)
+	svc.Close(context.Background())
+
+	svc = newFetchService(t, torrential.FetchPolicy{AllowPrivate: true, DenyHosts: []string{"127.0.0.1"}})
+	_, err = svc.AddTorrentURL(srv.URL)
+	assert.True(t, isForbidden(err), "denied host: %v", err)
+	svc.Close(context.Background())
+
+	svc = newFetchService(t, torrential.FetchPolicy{
+		AllowPrivate: true,
+		Cookies:      map[string][]*http.Cookie{"127.0.0.1": {{Name: "passkey", Value: "secret"}}},
+	})
+	defer svc.Close(context.Background())
+	_, err = svc.AddTorrentURL(srv.URL)
+	assert.True(t, isFetchError(err), "not found: %v", err)
+	assert.Equal(t, "passkey=secret", cookie)
+}
+
+func TestFetchPrivateAddresses(t *testing.T) {
+	svc := newFetchService(t, torrential.FetchPolicy{})
+	defer svc.Close(context.Background())
+	for _, host := range []string{"198.18.0.1", "224.0.0.1", "255.255.255.255", "[64:ff9b::a00:1]", "[2002:a00:1::1]", "[ff02::1]"} {
+		_, err := svc.AddTorrentURL("http://" + host + "/file.torrent")
+		assert.True(t, isForbidden(err), "%s: %v", host, err)
+	}
+}
+
+func TestFetchRedirects(t *testing.T) {
+	// /redirect/<n> redirects n times before the torrent is not found.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/redirect/"))
+		if err != nil || n == 0 {
+			http.NotFound(w, r)
+			return
+		}
+		http.Redirect(w, r, "/redirect/"+strconv.Itoa(n-1), http.StatusFound)
+	}))
+	defer srv.Close()
+
+	svc := newFetchService(t, torrential.FetchPolicy{AllowPrivate: true, MaxRedirects: 2})
+	_, err := svc.AddTorrentURL(srv.URL + "/redirect/2")
+	assert.True(t, isFetchError(err), "2 redirects: %v", err)
+	_, err = svc.AddTorrentURL(srv.URL + "/redirect/3")
+	assert.True(t, isForbidden(err), "3 redirects: %v", err)
+	svc.Close(context.Background())
+
+	svc = newFetchService(t, torrential.FetchPolicy{AllowPrivate: true, MaxRedirects: -1})
+	_, err = svc.AddTorrentURL(srv.URL + "/redirect/1")
+	if assert.True(t, isForbidden(err), "no redirects: %v", err) {
+		assert.Contains(t, err.Error(), "redirects are not allowed")
+	}
+	svc.Close(context.Background())
+
+	// Redirects are checked against the allowed hosts too.
+	localhost := strings.Replace(srv.URL, "127.0.0.1", "localhost", 1)
+	redirect := httptest.NewServer(http.RedirectHandler(localhost+"/redirect/0", http.StatusFound))
+	defer redirect.Close()
+	svc = newFetchService(t, torrential.FetchPolicy{AllowPrivate: true, AllowHosts: []string{"127.0.0.1"}})
+	defer svc.Close(context.Background())
+	_, err = svc.AddTorrentURL(srv.URL + "/redirect/0")
+	assert.True(t, isFetchError(err), "allowed host: %v", err)
+	_, err = svc.AddTorrentURL(localhost + "/redirect/0")
+	assert.True(t, isForbidden(err), "other host: %v", err)
+	_, err = svc.AddTorrentURL(redirect.URL)
+	assert.True(t, isForbidden(err), "redirect to other host: %v", err)
+}
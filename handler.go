@@ -1,21 +1,90 @@
 package torrential
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"strings"
 
 	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
 	"github.com/pkg/errors"
+
+	"github.com/joelanford/torrential/cache"
 )
 
+// TorrentService is the set of methods that Handler serves. It is
+// implemented by *Service and *FakeService, and can be implemented by proxies
+// or routers in front of other services.
+type TorrentService interface {
+	Torrents() []Torrent
+	Torrent(infoHash string) (*Torrent, error)
+	AddTorrentReaderContext(ctx context.Context, torrentReader io.Reader, options ...AddOptionFunc) (*Torrent, error)
+	AddTorrentURLContext(ctx context.Context, torrentURL string, options ...AddOptionFunc) (*Torrent, error)
+	AddMagnetURIContext(ctx context.Context, magnetURI string, options ...AddOptionFunc) (*Torrent, error)
+	Drop(infoHash string, deleteFiles bool) error
+
+	Labels(infoHash string) ([]string, error)
+	SetLabels(infoHash string, labels []string) error
+	Pause(infoHash string) error
+	Resume(infoHash string) error
+	SetPriority(infoHash string, priority Priority) error
+	ExecResults(infoHash string) ([]ExecResult, error)
+
+	// TorrentsEventer returns an Eventer of the events of all torrents, and
+	// TorrentEventer returns an Eventer of the events of a single torrent.
+	TorrentsEventer() Eventer
+	TorrentEventer(infoHash string) (Eventer, error)
+	EventFormat() EventFormat
+	EventSource() string
+
+	CacheReport() *cache.LoadReport
+	Leases() []cache.Lease
+	InstanceID() string
+
+	Webhooks() []Webhook
+	Webhook(id string) (*Webhook, error)
+	AddWebhook(w Webhook) (*Webhook, error)
+	UpdateWebhook(id string, w Webhook) (*Webhook, error)
+	DeleteWebhook(id string) error
+	DeadLetters() []WebhookDelivery
+	DeadLetter(id string) (*WebhookDelivery, error)
+	ReplayDeadLetter(id string) (*WebhookDelivery, error)
+	DeleteDeadLetter(id string) error
+}
+
+var _ TorrentService = &Service{}
+
 type handler struct {
-	ts       *Service
+	ts       TorrentService
 	upgrader *websocket.Upgrader
 }
 
-func Handler(basePath string, svc *Service) http.Handler {
+// HandlerOptionFunc configures the handler returned by Handler.
+type HandlerOptionFunc func(o *handlerOptions)
+
+type handlerOptions struct {
+	authenticators []Authenticator
+}
+
+// Auth returns a HandlerOptionFunc that requires requests to be authenticated
+// by one of the authenticators. The first authenticator that finds
+// credentials in a request decides its scope.
+func Auth(authenticators ...Authenticator) HandlerOptionFunc {
+	return func(o *handlerOptions) {
+		o.authenticators = append(o.authenticators, authenticators...)
+	}
+}
+
+func Handler(basePath string, svc TorrentService, options ...HandlerOptionFunc) http.Handler {
+	var opts handlerOptions
+	for _, opt := range options {
+		opt(&opts)
+	}
+
 	r := mux.NewRouter()
 	sr := r.PathPrefix(basePath).Subrouter()
 
@@ -31,6 +100,22 @@ func Handler(basePath string, svc *Service) http.Handler {
 	sr.Path("/torrents/events").Methods("GET").HandlerFunc(h.getTorrentsEvents)
 	sr.Path("/torrents/{infoHash}/events").Methods("GET").HandlerFunc(h.getTorrentEvents)
 
+	sr.Path("/torrents/{infoHash}/labels").Methods("GET").HandlerFunc(h.getTorrentLabels)
+	sr.Path("/torrents/{infoHash}/labels").Methods("PUT").HandlerFunc(h.putTorrentLabels)
+	sr.Path("/torrents/{infoHash}/labels").HandlerFunc(h.supportedMethods("GET", "PUT"))
+
+	sr.Path("/torrents/{infoHash}/pause").Methods("POST").HandlerFunc(h.postTorrentPause)
+	sr.Path("/torrents/{infoHash}/pause").HandlerFunc(h.supportedMethods("POST"))
+
+	sr.Path("/torrents/{infoHash}/resume").Methods("POST").HandlerFunc(h.postTorrentResume)
+	sr.Path("/torrents/{infoHash}/resume").HandlerFunc(h.supportedMethods("POST"))
+
+	sr.Path("/torrents/{infoHash}/priority").Methods("PUT").HandlerFunc(h.putTorrentPriority)
+	sr.Path("/torrents/{infoHash}/priority").HandlerFunc(h.supportedMethods("PUT"))
+
+	sr.Path("/torrents/{infoHash}/exec").Methods("GET").HandlerFunc(h.getTorrentExecResults)
+	sr.Path("/torrents/{infoHash}/exec").HandlerFunc(h.supportedMethods("GET"))
+
 	sr.Path("/torrents").Methods("HEAD").HandlerFunc(h.headTorrents)
 	sr.Path("/torrents").Methods("GET").HandlerFunc(h.getTorrents)
 	sr.Path("/torrents").Methods("POST").Headers("Content-Type", "application/x-bittorrent").HandlerFunc(h.postTorrentData)
@@ -44,6 +129,40 @@ func Handler(basePath string, svc *Service) http.Handler {
 	sr.Path("/torrents/{infoHash}").Methods("DELETE").HandlerFunc(h.deleteTorrent)
 	sr.Path("/torrents/{infoHash}").HandlerFunc(h.supportedMethods("HEAD", "GET", "DELETE"))
 
+	sr.Path("/cache/report").Methods("GET").HandlerFunc(h.getCacheReport)
+	sr.Path("/cache/report").HandlerFunc(h.supportedMethods("GET"))
+
+	sr.Path("/leases").Methods("GET").HandlerFunc(h.getLeases)
+	sr.Path("/leases").HandlerFunc(h.supportedMethods("GET"))
+
+	sr.Path("/webhooks").Methods("GET").HandlerFunc(h.getWebhooks)
+	sr.Path("/webhooks").Methods("POST").HandlerFunc(h.postWebhook)
+	sr.Path("/webhooks").HandlerFunc(h.supportedMethods("GET", "POST"))
+
+	// Dead letter routes must be registered before the webhook ID routes,
+	// since "deadletters" would otherwise match as a webhook ID.
+	sr.Path("/webhooks/deadletters").Methods("GET").HandlerFunc(h.getDeadLetters)
+	sr.Path("/webhooks/deadletters").HandlerFunc(h.supportedMethods("GET"))
+
+	sr.Path("/webhooks/deadletters/{id}").Methods("GET").HandlerFunc(h.getDeadLetter)
+	sr.Path("/webhooks/deadletters/{id}").Methods("DELETE").HandlerFunc(h.deleteDeadLetter)
+	sr.Path("/webhooks/deadletters/{id}").HandlerFunc(h.supportedMethods("GET", "DELETE"))
+
+	sr.Path("/webhooks/deadletters/{id}/replay").Methods("POST").HandlerFunc(h.replayDeadLetter)
+	sr.Path("/webhooks/deadletters/{id}/replay").HandlerFunc(h.supportedMethods("POST"))
+
+	sr.Path("/webhooks/{id}").Methods("GET").HandlerFunc(h.getWebhook)
+	sr.Path("/webhooks/{id}").Methods("PUT").HandlerFunc(h.putWebhook)
+	sr.Path("/webhooks/{id}").Methods("DELETE").HandlerFunc(h.deleteWebhook)
+	sr.Path("/webhooks/{id}").HandlerFunc(h.supportedMethods("GET", "PUT", "DELETE"))
+
+	sr.Path("/ui/").Methods("HEAD", "GET").HandlerFunc(h.getUI)
+	sr.Path("/ui/").HandlerFunc(h.supportedMethods("HEAD", "GET"))
+	sr.Path("/ui").HandlerFunc(h.redirectUI)
+
+	if len(opts.authenticators) > 0 {
+		return &authHandler{handler: r, basePath: basePath, authenticators: opts.authenticators}
+	}
 	return r
 }
 
@@ -60,7 +179,7 @@ func (h *handler) getTorrents(w http.ResponseWriter, r *http.Request) {
 
 // postTorrentData adds a new torrent from torrent data
 func (h *handler) postTorrentData(w http.ResponseWriter, r *http.Request) {
-	torrent, err := h.ts.AddTorrentReader(r.Body)
+	torrent, err := h.ts.AddTorrentReaderContext(r.Context(), r.Body, Labels(r.URL.Query()["label"]...))
 	if err != nil {
 		encodeError(w, httpStatus(err), err)
 		return
@@ -76,7 +195,7 @@ func (h *handler) postTorrentURL(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	torrent, err := h.ts.AddTorrentURL(string(data))
+	torrent, err := h.ts.AddTorrentURLContext(r.Context(), string(data), Labels(r.URL.Query()["label"]...))
 	if err != nil {
 		encodeError(w, httpStatus(err), err)
 		return
@@ -92,7 +211,7 @@ func (h *handler) postMagnetURI(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	torrent, err := h.ts.AddMagnetURI(string(data))
+	torrent, err := h.ts.AddMagnetURIContext(r.Context(), string(data), Labels(r.URL.Query()["label"]...))
 	if err != nil {
 		encodeError(w, httpStatus(err), err)
 		return
@@ -100,21 +219,9 @@ func (h *handler) postMagnetURI(w http.ResponseWriter, r *http.Request) {
 	encodeTorrent(w, http.StatusCreated, torrent)
 }
 
-// getTorrentsEvents opens a websocket and sends events about all torrents.
+// getTorrentsEvents opens an event stream and sends events about all torrents.
 func (h *handler) getTorrentsEvents(w http.ResponseWriter, r *http.Request) {
-	eventer := h.ts.MultiEventer()
-
-	ws, err := h.upgrader.Upgrade(w, r, nil)
-	if err != nil {
-		// err is handled by h.upgrader.Error, which calls encodeError
-		return
-	}
-	defer ws.Close()
-
-	for e := range eventer.Events(r.Context().Done()) {
-		ws.WriteJSON(eventResult{e})
-	}
-	ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	h.streamEvents(w, r, h.ts.TorrentsEventer())
 }
 
 // headTorrent returns the headers and status code given an info hash
@@ -166,7 +273,7 @@ func (h *handler) deleteTorrent(w http.ResponseWriter, r *http.Request) {
 	encodeEmptyResult(w, http.StatusOK)
 }
 
-// getTorrentEvents opens a websocket and sends events about the given torrent.
+// getTorrentEvents opens an event stream and sends events about the given torrent.
 func (h *handler) getTorrentEvents(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	infoHash, ok := vars["infoHash"]
@@ -174,11 +281,32 @@ func (h *handler) getTorrentEvents(w http.ResponseWriter, r *http.Request) {
 		encodeError(w, http.StatusNotFound, errors.New("torrent not found"))
 		return
 	}
-	eventer, err := h.ts.Eventer(infoHash)
+	eventer, err := h.ts.TorrentEventer(infoHash)
 	if err != nil {
 		encodeError(w, httpStatus(err), err)
 		return
 	}
+	h.streamEvents(w, r, eventer)
+}
+
+// streamEvents sends events from the eventer until the request is done.
+// Clients that accept text/event-stream receive server-sent events, and all
+// other clients are upgraded to a websocket. The format query parameter
+// overrides the service's default event format.
+func (h *handler) streamEvents(w http.ResponseWriter, r *http.Request, eventer Eventer) {
+	format := h.ts.EventFormat()
+	if f := r.URL.Query().Get("format"); f != "" {
+		var err error
+		if format, err = ParseEventFormat(f); err != nil {
+			encodeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	if strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		h.streamServerSentEvents(w, r, eventer, format)
+		return
+	}
 
 	ws, err := h.upgrader.Upgrade(w, r, nil)
 	if err != nil {
@@ -188,11 +316,297 @@ func (h *handler) getTorrentEvents(w http.ResponseWriter, r *http.Request) {
 	defer ws.Close()
 
 	for e := range eventer.Events(r.Context().Done()) {
-		ws.WriteJSON(eventResult{e})
+		_, data, err := h.encodeStreamEvent(e, format)
+		if err != nil {
+			continue
+		}
+		ws.WriteMessage(websocket.TextMessage, data)
 	}
 	ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
 }
 
+func (h *handler) streamServerSentEvents(w http.ResponseWriter, r *http.Request, eventer Eventer, format EventFormat) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		encodeError(w, http.StatusInternalServerError, errors.New("streaming not supported"))
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for e := range eventer.Events(r.Context().Done()) {
+		id, data, err := h.encodeStreamEvent(e, format)
+		if err != nil {
+			continue
+		}
+		if id != "" {
+			fmt.Fprintf(w, "id: %s\n", id)
+		}
+		fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data)
+		flusher.Flush()
+	}
+}
+
+// encodeStreamEvent encodes an event for an event stream, returning the event
+// ID if the format has one.
+func (h *handler) encodeStreamEvent(e Event, format EventFormat) (string, []byte, error) {
+	return encodeEvent(e, format, h.ts.EventSource())
+}
+
+// getTorrentLabels returns the labels of a torrent given an info hash
+func (h *handler) getTorrentLabels(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	infoHash, ok := vars["infoHash"]
+	if !ok {
+		encodeError(w, http.StatusNotFound, errors.New("torrent not found"))
+		return
+	}
+	labels, err := h.ts.Labels(infoHash)
+	if err != nil {
+		encodeError(w, httpStatus(err), err)
+		return
+	}
+	encodeLabels(w, http.StatusOK, labels)
+}
+
+// putTorrentLabels replaces the labels of a torrent given an info hash
+func (h *handler) putTorrentLabels(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	infoHash, ok := vars["infoHash"]
+	if !ok {
+		encodeError(w, http.StatusNotFound, errors.New("torrent not found"))
+		return
+	}
+	var req labelsResult
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		encodeError(w, http.StatusBadRequest, errors.Wrap(err, "could not parse labels"))
+		return
+	}
+	if err := h.ts.SetLabels(infoHash, req.Labels); err != nil {
+		encodeError(w, httpStatus(err), err)
+		return
+	}
+	encodeLabels(w, http.StatusOK, req.Labels)
+}
+
+// postTorrentPause pauses a torrent given an info hash
+func (h *handler) postTorrentPause(w http.ResponseWriter, r *http.Request) {
+	h.controlTorrent(w, r, h.ts.Pause)
+}
+
+// postTorrentResume resumes a torrent given an info hash
+func (h *handler) postTorrentResume(w http.ResponseWriter, r *http.Request) {
+	h.controlTorrent(w, r, h.ts.Resume)
+}
+
+// putTorrentPriority sets the priority of a torrent given an info hash
+func (h *handler) putTorrentPriority(w http.ResponseWriter, r *http.Request) {
+	var req priorityResult
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		encodeError(w, http.StatusBadRequest, errors.Wrap(err, "could not parse priority"))
+		return
+	}
+	h.controlTorrent(w, r, func(infoHash string) error {
+		return h.ts.SetPriority(infoHash, req.Priority)
+	})
+}
+
+// controlTorrent changes the download state of a torrent with control and
+// returns the torrent.
+func (h *handler) controlTorrent(w http.ResponseWriter, r *http.Request, control func(infoHash string) error) {
+	vars := mux.Vars(r)
+	infoHash, ok := vars["infoHash"]
+	if !ok {
+		encodeError(w, http.StatusNotFound, errors.New("torrent not found"))
+		return
+	}
+	if err := control(infoHash); err != nil {
+		encodeError(w, httpStatus(err), err)
+		return
+	}
+	torrent, err := h.ts.Torrent(infoHash)
+	if err != nil {
+		encodeError(w, httpStatus(err), err)
+		return
+	}
+	encodeTorrent(w, http.StatusOK, torrent)
+}
+
+// getTorrentExecResults returns the results of the exec hooks run for a
+// torrent given an info hash
+func (h *handler) getTorrentExecResults(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	infoHash, ok := vars["infoHash"]
+	if !ok {
+		encodeError(w, http.StatusNotFound, errors.New("torrent not found"))
+		return
+	}
+	results, err := h.ts.ExecResults(infoHash)
+	if err != nil {
+		encodeError(w, httpStatus(err), err)
+		return
+	}
+	writeHeader(w, http.StatusOK)
+	json.NewEncoder(w).Encode(execResultsResult{results})
+}
+
+// getCacheReport returns the report of the cache load at startup
+func (h *handler) getCacheReport(w http.ResponseWriter, r *http.Request) {
+	writeHeader(w, http.StatusOK)
+	json.NewEncoder(w).Encode(cacheReportResult{h.ts.CacheReport()})
+}
+
+// getLeases returns the leases of the torrents owned by this instance
+func (h *handler) getLeases(w http.ResponseWriter, r *http.Request) {
+	leases := h.ts.Leases()
+	if leases == nil {
+		leases = []cache.Lease{}
+	}
+	writeHeader(w, http.StatusOK)
+	json.NewEncoder(w).Encode(leasesResult{h.ts.InstanceID(), leases})
+}
+
+// getUI returns the web UI
+func (h *handler) getUI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	if r.Method != "HEAD" {
+		io.WriteString(w, webUI)
+	}
+}
+
+// redirectUI redirects to the web UI, whose API paths are relative to the
+// trailing slash
+func (h *handler) redirectUI(w http.ResponseWriter, r *http.Request) {
+	http.Redirect(w, r, r.URL.Path+"/", http.StatusMovedPermanently)
+}
+
+// getWebhooks returns all webhook subscriptions
+func (h *handler) getWebhooks(w http.ResponseWriter, r *http.Request) {
+	encodeWebhooks(w, http.StatusOK, h.ts.Webhooks())
+}
+
+// postWebhook adds a new webhook subscription
+func (h *handler) postWebhook(w http.ResponseWriter, r *http.Request) {
+	var webhook Webhook
+	if err := json.NewDecoder(r.Body).Decode(&webhook); err != nil {
+		encodeError(w, http.StatusBadRequest, errors.Wrap(err, "could not parse webhook"))
+		return
+	}
+	created, err := h.ts.AddWebhook(webhook)
+	if err != nil {
+		encodeError(w, httpStatus(err), err)
+		return
+	}
+	encodeWebhook(w, http.StatusCreated, created)
+}
+
+// getWebhook returns a webhook subscription given its ID
+func (h *handler) getWebhook(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, ok := vars["id"]
+	if !ok {
+		encodeError(w, http.StatusNotFound, errors.New("webhook not found"))
+		return
+	}
+	webhook, err := h.ts.Webhook(id)
+	if err != nil {
+		encodeError(w, httpStatus(err), err)
+		return
+	}
+	encodeWebhook(w, http.StatusOK, webhook)
+}
+
+// putWebhook replaces a webhook subscription given its ID
+func (h *handler) putWebhook(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, ok := vars["id"]
+	if !ok {
+		encodeError(w, http.StatusNotFound, errors.New("webhook not found"))
+		return
+	}
+	var webhook Webhook
+	if err := json.NewDecoder(r.Body).Decode(&webhook); err != nil {
+		encodeError(w, http.StatusBadRequest, errors.Wrap(err, "could not parse webhook"))
+		return
+	}
+	updated, err := h.ts.UpdateWebhook(id, webhook)
+	if err != nil {
+		encodeError(w, httpStatus(err), err)
+		return
+	}
+	encodeWebhook(w, http.StatusOK, updated)
+}
+
+// deleteWebhook removes a webhook subscription given its ID
+func (h *handler) deleteWebhook(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, ok := vars["id"]
+	if !ok {
+		encodeError(w, http.StatusNotFound, errors.New("webhook not found"))
+		return
+	}
+	if err := h.ts.DeleteWebhook(id); err != nil {
+		encodeError(w, httpStatus(err), err)
+		return
+	}
+	encodeEmptyResult(w, http.StatusOK)
+}
+
+// getDeadLetters returns all webhook deliveries that failed permanently
+func (h *handler) getDeadLetters(w http.ResponseWriter, r *http.Request) {
+	encodeDeliveries(w, http.StatusOK, h.ts.DeadLetters())
+}
+
+// getDeadLetter returns a dead webhook delivery given its ID
+func (h *handler) getDeadLetter(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, ok := vars["id"]
+	if !ok {
+		encodeError(w, http.StatusNotFound, errors.New("dead letter not found"))
+		return
+	}
+	delivery, err := h.ts.DeadLetter(id)
+	if err != nil {
+		encodeError(w, httpStatus(err), err)
+		return
+	}
+	encodeDelivery(w, http.StatusOK, delivery)
+}
+
+// deleteDeadLetter discards a dead webhook delivery given its ID
+func (h *handler) deleteDeadLetter(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, ok := vars["id"]
+	if !ok {
+		encodeError(w, http.StatusNotFound, errors.New("dead letter not found"))
+		return
+	}
+	if err := h.ts.DeleteDeadLetter(id); err != nil {
+		encodeError(w, httpStatus(err), err)
+		return
+	}
+	encodeEmptyResult(w, http.StatusOK)
+}
+
+// replayDeadLetter queues a dead webhook delivery to be sent again
+func (h *handler) replayDeadLetter(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, ok := vars["id"]
+	if !ok {
+		encodeError(w, http.StatusNotFound, errors.New("dead letter not found"))
+		return
+	}
+	delivery, err := h.ts.ReplayDeadLetter(id)
+	if err != nil {
+		encodeError(w, httpStatus(err), err)
+		return
+	}
+	encodeDelivery(w, http.StatusAccepted, delivery)
+}
+
 func encodeTorrent(w http.ResponseWriter, code int, torrent *Torrent) {
 	writeHeader(w, code)
 	json.NewEncoder(w).Encode(torrentResult{torrent})
@@ -203,6 +617,34 @@ func encodeTorrents(w http.ResponseWriter, code int, torrents []Torrent) {
 	json.NewEncoder(w).Encode(torrentsResult{torrents})
 }
 
+func encodeLabels(w http.ResponseWriter, code int, labels []string) {
+	if labels == nil {
+		labels = []string{}
+	}
+	writeHeader(w, code)
+	json.NewEncoder(w).Encode(labelsResult{labels})
+}
+
+func encodeWebhook(w http.ResponseWriter, code int, webhook *Webhook) {
+	writeHeader(w, code)
+	json.NewEncoder(w).Encode(webhookResult{webhook})
+}
+
+func encodeWebhooks(w http.ResponseWriter, code int, webhooks []Webhook) {
+	writeHeader(w, code)
+	json.NewEncoder(w).Encode(webhooksResult{webhooks})
+}
+
+func encodeDelivery(w http.ResponseWriter, code int, delivery *WebhookDelivery) {
+	writeHeader(w, code)
+	json.NewEncoder(w).Encode(deliveryResult{delivery})
+}
+
+func encodeDeliveries(w http.ResponseWriter, code int, deliveries []WebhookDelivery) {
+	writeHeader(w, code)
+	json.NewEncoder(w).Encode(deliveriesResult{deliveries})
+}
+
 func encodeEmptyResult(w http.ResponseWriter, code int) {
 	writeHeader(w, code)
 	w.Write([]byte("{}"))
@@ -246,6 +688,7 @@ func (h *handler) supportedMethods(supportedMethods ...string) http.HandlerFunc
 }
 
 func httpStatus(err error) int {
+	err = errors.Cause(err)
 	if e, ok := err.(notFoundErr); ok && e.IsNotFound() {
 		return http.StatusNotFound
 	} else if e, ok := err.(existsErr); ok && e.IsExists() {
@@ -258,6 +701,10 @@ func httpStatus(err error) int {
 		return http.StatusInternalServerError
 	} else if e, ok := err.(fetchErr); ok && e.IsFetchError() {
 		return http.StatusInternalServerError
+	} else if e, ok := err.(tooLargeErr); ok && e.IsTooLarge() {
+		return http.StatusRequestEntityTooLarge
+	} else if e, ok := err.(forbiddenErr); ok && e.IsForbidden() {
+		return http.StatusForbidden
 	} else if e, ok := err.(deleteErr); ok && e.IsDeleteError() {
 		return http.StatusInternalServerError
 	}
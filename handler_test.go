@@ -0,0 +1,124 @@
+package torrential_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/joelanford/torrential"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandlerUI(t *testing.T) {
+	f, err := torrential.NewFakeService()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	srv := httptest.NewServer(torrential.Handler("/api/", f))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/api/ui")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "/api/ui/", resp.Request.URL.Path)
+	assert.Equal(t, "text/html; charset=utf-8", resp.Header.Get("Content-Type"))
+	assert.Contains(t, string(body), "<title>torrential</title>")
+}
+
+func TestHandlerMaxTorrentSize(t *testing.T) {
+	f, err := torrential.NewFakeService()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	srv := httptest.NewServer(torrential.Handler("/", f))
+	defer srv.Close()
+	huge := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(bytes.Repeat([]byte("d"), 11<<20))
+	}))
+	defer huge.Close()
+
+	resp, err := http.Post(srv.URL+"/torrents", "application/x-url", strings.NewReader(huge.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+
+	resp, err = http.Post(srv.URL+"/torrents", "application/x-bittorrent", bytes.NewReader(bytes.Repeat([]byte("d"), 11<<20)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+}
+
+func TestHandlerWebhooks(t *testing.T) {
+	f, err := torrential.NewFakeService()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	srv := httptest.NewServer(torrential.Handler("/", f))
+	defer srv.Close()
+
+	do := func(method, path, body string, v interface{}) int {
+		req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if v != nil {
+			assert.NoError(t, json.NewDecoder(resp.Body).Decode(v))
+		}
+		return resp.StatusCode
+	}
+
+	assert.Equal(t, http.StatusBadRequest, do("POST", "/webhooks", `{"id":"../../etc/x","url":"http://example.com/hook"}`, nil))
+	assert.Equal(t, http.StatusBadRequest, do("POST", "/webhooks", `{"id":"deadletters","url":"http://example.com/hook"}`, nil))
+	assert.Equal(t, http.StatusBadRequest, do("POST", "/webhooks", `{"url":"ftp://example.com/hook"}`, nil))
+	assert.Equal(t, http.StatusBadRequest, do("POST", "/webhooks", `{"url":"http://example.com/hook","template":"{{"}`, nil))
+
+	var created struct {
+		Webhook torrential.Webhook `json:"webhook"`
+	}
+	assert.Equal(t, http.StatusCreated, do("POST", "/webhooks", `{"url":"http://example.com/hook","eventTypes":["downloadDone"]}`, &created))
+	assert.NotEmpty(t, created.Webhook.ID)
+	assert.Equal(t, []torrential.EventType{torrential.DownloadDone}, created.Webhook.EventTypes)
+	path := "/webhooks/" + created.Webhook.ID
+
+	assert.Equal(t, http.StatusConflict, do("POST", "/webhooks", `{"id":"`+created.Webhook.ID+`","url":"http://example.com/hook"}`, nil))
+
+	var updated struct {
+		Webhook torrential.Webhook `json:"webhook"`
+	}
+	assert.Equal(t, http.StatusOK, do("PUT", path, `{"url":"http://example.com/other","labels":["movies"]}`, &updated))
+	assert.Equal(t, created.Webhook.ID, updated.Webhook.ID)
+	assert.Equal(t, []string{"movies"}, updated.Webhook.Labels)
+	assert.Empty(t, updated.Webhook.EventTypes)
+
+	var list struct {
+		Webhooks []torrential.Webhook `json:"webhooks"`
+	}
+	assert.Equal(t, http.StatusOK, do("GET", "/webhooks", "", &list))
+	assert.Equal(t, []torrential.Webhook{updated.Webhook}, list.Webhooks)
+
+	assert.Equal(t, http.StatusOK, do("DELETE", path, "", nil))
+	assert.Equal(t, http.StatusNotFound, do("GET", path, "", nil))
+	assert.Equal(t, http.StatusNotFound, do("PUT", path, `{"url":"http://example.com/hook"}`, nil))
+	assert.Equal(t, http.StatusNotFound, do("DELETE", path, "", nil))
+}
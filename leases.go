@@ -0,0 +1,199 @@
+package torrential
+
+import (
+	"context"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/pkg/errors"
+
+	"github.com/joelanford/torrential/cache"
+)
+
+// filterLeased returns the specs whose leases this instance owns.
+func (svc *Service) filterLeased(specs []torrent.TorrentSpec) []torrent.TorrentSpec {
+	if svc.conf.Leases == nil {
+		return specs
+	}
+	infoHashes := make([]string, len(specs))
+	for i := range specs {
+		infoHashes[i] = specs[i].InfoHash.HexString()
+	}
+	owned, err := svc.conf.Leases.AcquireAll(infoHashes)
+	if err != nil {
+		log.Printf("error acquiring leases: %s", err)
+	}
+	ownedSet := make(map[string]bool, len(owned))
+	for _, infoHash := range owned {
+		ownedSet[infoHash] = true
+		svc.setLeased(infoHash)
+	}
+	var leased []torrent.TorrentSpec
+	for _, spec := range specs {
+		if ownedSet[spec.InfoHash.HexString()] {
+			leased = append(leased, spec)
+		}
+	}
+	return leased
+}
+
+func (svc *Service) setLeased(infoHash string) {
+	svc.leaseMu.Lock()
+	svc.leased[infoHash] = time.Now().Add(svc.conf.Leases.TTL)
+	svc.leaseMu.Unlock()
+}
+
+// runLeases renews the leases of the torrents this instance owns, drops the
+// torrents whose leases were taken over, and takes over the torrents whose
+// leases have expired.
+func (svc *Service) runLeases() {
+	defer svc.loops.Done()
+	ticker := time.NewTicker(svc.conf.Leases.TTL / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			svc.renewLeases()
+			svc.takeOverLeases()
+		case <-svc.done:
+			return
+		}
+	}
+}
+
+// releaseLeases expires the leases of all torrents this instance owns, so
+// that other instances can take them over without waiting for them to expire.
+func (svc *Service) releaseLeases() {
+	svc.leaseMu.Lock()
+	defer svc.leaseMu.Unlock()
+	for infoHash := range svc.leased {
+		if err := svc.conf.Leases.Expire(infoHash); err != nil {
+			log.Printf("error releasing lease of torrent %s: %s", infoHash, err)
+		}
+		delete(svc.leased, infoHash)
+	}
+}
+
+func (svc *Service) renewLeases() {
+	svc.leaseMu.RLock()
+	infoHashes := make([]string, 0, len(svc.leased))
+	for infoHash := range svc.leased {
+		infoHashes = append(infoHashes, infoHash)
+	}
+	svc.leaseMu.RUnlock()
+
+	for _, infoHash := range infoHashes {
+		var h metainfo.Hash
+		if err := h.FromHexString(infoHash); err != nil {
+			continue
+		}
+		t, ok := svc.client.Torrent(h)
+		if !ok {
+			// The torrent was dropped, so give up its lease.
+			if err := svc.conf.Leases.Release(infoHash); err != nil {
+				log.Printf("error releasing lease of torrent %s: %s", infoHash, err)
+			}
+			svc.leaseMu.Lock()
+			delete(svc.leased, infoHash)
+			svc.leaseMu.Unlock()
+			continue
+		}
+		owned, err := svc.conf.Leases.Renew(infoHash)
+		if err != nil {
+			log.Printf("error renewing lease of torrent %s: %s", infoHash, err)
+			continue
+		}
+		if !owned {
+			log.Printf("lease of torrent %s was taken over, dropping it", infoHash)
+			svc.leaseMu.Lock()
+			delete(svc.leased, infoHash)
+			svc.leaseMu.Unlock()
+			svc.dropLocal(t, infoHash)
+			continue
+		}
+		svc.setLeased(infoHash)
+	}
+}
+
+// takeOverLeases takes over the torrents whose leases have expired, loading
+// them one by one from the cache. The cache is not loaded as a whole, since
+// other instances may be writing to it.
+func (svc *Service) takeOverLeases() {
+	loader, ok := svc.conf.Cache.(cache.TorrentLoader)
+	if !ok {
+		return
+	}
+	leases, err := svc.conf.Leases.List()
+	if err != nil {
+		log.Printf("error listing leases: %s", err)
+		return
+	}
+	now := time.Now()
+	var expired []string
+	for _, lease := range leases {
+		var h metainfo.Hash
+		if !lease.Expired(now) || h.FromHexString(lease.InfoHash) != nil {
+			continue
+		}
+		if _, ok := svc.client.Torrent(h); !ok {
+			expired = append(expired, lease.InfoHash)
+		}
+	}
+	if len(expired) == 0 {
+		return
+	}
+	owned, err := svc.conf.Leases.AcquireAll(expired)
+	if err != nil {
+		log.Printf("error acquiring leases: %s", err)
+	}
+	for _, infoHash := range owned {
+		spec, err := loader.LoadTorrent(infoHash)
+		if err != nil {
+			if errors.Cause(err) == cache.ErrNotFound {
+				// The torrent was dropped, but its lease was left behind.
+				err = svc.conf.Leases.Release(infoHash)
+			} else {
+				log.Printf("error loading torrent %s: %s", infoHash, err)
+				err = svc.conf.Leases.Expire(infoHash)
+			}
+			if err != nil {
+				log.Printf("error releasing lease of torrent %s: %s", infoHash, err)
+			}
+			continue
+		}
+		svc.setLeased(infoHash)
+		log.Printf("took over lease of torrent %s", infoHash)
+		svc.checkCompletion(spec)
+		options := append(svc.cachedOptions(infoHash), leased())
+		if _, err := svc.addTorrentSpec(context.Background(), spec, options...); err != nil {
+			log.Printf("error adding torrent %s: %s", infoHash, err)
+		}
+	}
+}
+
+func (svc *Service) ownedLeases() []cache.Lease {
+	svc.leaseMu.RLock()
+	defer svc.leaseMu.RUnlock()
+	leases := make([]cache.Lease, 0, len(svc.leased))
+	for infoHash, expires := range svc.leased {
+		leases = append(leases, cache.Lease{
+			InfoHash: infoHash,
+			Owner:    svc.conf.Leases.Owner(),
+			Expires:  expires,
+		})
+	}
+	sort.Slice(leases, func(i, j int) bool {
+		return leases[i].InfoHash < leases[j].InfoHash
+	})
+	return leases
+}
+
+// leased returns an AddOptionFunc for torrents whose lease is already owned.
+func leased() AddOptionFunc {
+	return func(o *AddOptions) {
+		o.leased = true
+	}
+}
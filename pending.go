@@ -0,0 +1,136 @@
+package torrential
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strings"
+
+	"github.com/anacrolix/torrent"
+	"github.com/pkg/errors"
+
+	"github.com/joelanford/torrential/cache"
+)
+
+// pendingSuffix is the suffix of the blobs that hold placeholders of torrents
+// whose info hasn't been received yet.
+const pendingSuffix = ".pending"
+
+// pendingTorrent is the placeholder of a torrent whose metadata can't be saved
+// yet, e.g. a magnet link whose info didn't arrive within InfoTimeout. It is
+// stored in Config.Cache, if it implements cache.BlobStore, so that the torrent
+// is added again when the service restarts, and deleted once the metadata is
+// saved.
+type pendingTorrent struct {
+	MagnetURI string   `json:"magnetURI"`
+	Labels    []string `json:"labels,omitempty"`
+	Paused    bool     `json:"paused,omitempty"`
+	Priority  Priority `json:"priority,omitempty"`
+}
+
+// savePending saves the placeholder of a torrent, with its current labels and
+// download state.
+func (svc *Service) savePending(t *torrent.Torrent) error {
+	store, ok := svc.conf.Cache.(cache.BlobStore)
+	if !ok {
+		return nil
+	}
+	infoHash := t.InfoHash().HexString()
+	mi := t.Metainfo()
+	c := svc.torrentControl(infoHash)
+	data, err := json.Marshal(pendingTorrent{
+		MagnetURI: mi.Magnet(t.Name(), t.InfoHash()).String(),
+		Labels:    svc.torrentLabels(infoHash),
+		Paused:    c.paused,
+		Priority:  c.priority,
+	})
+	if err != nil {
+		return err
+	}
+	if err := store.PutBlob(infoHash+pendingSuffix, data); err != nil {
+		return errors.Wrap(cacheErr{err}, "could not save torrent placeholder")
+	}
+	return nil
+}
+
+// deletePending deletes the placeholder of a torrent, if it has one.
+func (svc *Service) deletePending(infoHash string) error {
+	svc.pendingMu.Lock()
+	delete(svc.pending, infoHash)
+	svc.pendingMu.Unlock()
+
+	store, ok := svc.conf.Cache.(cache.BlobStore)
+	if !ok {
+		return nil
+	}
+	return store.DeleteBlob(infoHash + pendingSuffix)
+}
+
+func (svc *Service) setPending(infoHash string) {
+	svc.pendingMu.Lock()
+	svc.pending[infoHash] = true
+	svc.pendingMu.Unlock()
+}
+
+func (svc *Service) isPending(infoHash string) bool {
+	svc.pendingMu.RLock()
+	defer svc.pendingMu.RUnlock()
+	return svc.pending[infoHash]
+}
+
+// loadPending adds the torrents of the placeholders in the cache. Their
+// metadata is saved in the background once their info is received.
+func (svc *Service) loadPending() error {
+	store, ok := svc.conf.Cache.(cache.BlobStore)
+	if !ok {
+		return nil
+	}
+	names, err := store.ListBlobs(pendingSuffix)
+	if err != nil {
+		return errors.Wrap(err, "could not list torrent placeholders")
+	}
+	for _, name := range names {
+		infoHash := strings.TrimSuffix(name, pendingSuffix)
+		data, err := store.GetBlob(name)
+		if err == cache.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return errors.Wrapf(err, "could not read placeholder of torrent %s", infoHash)
+		}
+		var p pendingTorrent
+		if err := json.Unmarshal(data, &p); err != nil {
+			log.Printf("error parsing placeholder of torrent %s: %s", infoHash, err)
+			continue
+		}
+		spec, err := torrent.TorrentSpecFromMagnetURI(p.MagnetURI)
+		if err != nil {
+			log.Printf("error parsing placeholder of torrent %s: %s", infoHash, err)
+			continue
+		}
+		options := []AddOptionFunc{Labels(p.Labels...), savedControl(p.Paused, string(p.Priority)), savedInBackground()}
+		_, err = svc.addTorrentSpec(context.Background(), spec, options...)
+		if _, ok := errors.Cause(err).(existsErr); ok {
+			// The placeholder is left over if the service stopped right after
+			// the metadata was saved.
+			if t, ok := svc.client.Torrent(spec.InfoHash); ok && t.Info() != nil {
+				if err := svc.deletePending(infoHash); err != nil {
+					log.Printf("error deleting placeholder of torrent %s: %s", infoHash, err)
+				}
+			}
+			continue
+		}
+		if err != nil {
+			log.Printf("error adding torrent %s from placeholder: %s", infoHash, err)
+		}
+	}
+	return nil
+}
+
+// savedInBackground returns an AddOptionFunc that makes the service save the
+// metadata of the torrent in the background without waiting for its info.
+func savedInBackground() AddOptionFunc {
+	return func(o *AddOptions) {
+		o.background = true
+	}
+}
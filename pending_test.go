@@ -0,0 +1,63 @@
+package torrential_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/joelanford/torrential"
+	"github.com/joelanford/torrential/cache"
+)
+
+func TestPendingTorrents(t *testing.T) {
+	c := cache.NewMemory()
+	newService := func() *torrential.Service {
+		svc, err := torrential.NewService(&torrential.Config{
+			ClientConfig: &torrent.Config{
+				ListenAddr:      "localhost:0",
+				NoDHT:           true,
+				DisableTrackers: true,
+			},
+			MemoryStorage: true,
+			Cache:         c,
+			InfoTimeout:   50 * time.Millisecond,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return svc
+	}
+	pending := func() []string {
+		names, err := c.ListBlobs(".pending")
+		assert.NoError(t, err)
+		return names
+	}
+
+	// The info of the magnet link never arrives, so only a placeholder is
+	// saved.
+	svc := newService()
+	tor, err := svc.AddMagnetURI("magnet:?xt=urn:btih:d0d14c926e6e99761a2fdcff27b403d96376eff6", torrential.Labels("movies"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.False(t, tor.HasInfo)
+	assert.Equal(t, []string{tor.InfoHash + ".pending"}, pending())
+	assert.NoError(t, svc.Close(context.Background()))
+
+	// The placeholder adds the torrent again with its labels.
+	svc = newService()
+	defer svc.Close(context.Background())
+	restored, err := svc.Torrent(tor.InfoHash)
+	if assert.NoError(t, err) {
+		assert.False(t, restored.HasInfo)
+	}
+	labels, err := svc.Labels(tor.InfoHash)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"movies"}, labels)
+
+	assert.NoError(t, svc.Drop(tor.InfoHash, false))
+	assert.Empty(t, pending())
+}
@@ -0,0 +1,152 @@
+package torrential
+
+import (
+	"encoding/json"
+
+	"github.com/anacrolix/torrent"
+	"github.com/pkg/errors"
+)
+
+// Priority is the download priority of a torrent. Pieces of torrents with a
+// high priority are requested before pieces of torrents with a normal
+// priority.
+type Priority string
+
+const (
+	PriorityNormal Priority = "normal"
+	PriorityHigh   Priority = "high"
+)
+
+// ParsePriority returns the Priority with the given name.
+func ParsePriority(name string) (Priority, error) {
+	switch p := Priority(name); p {
+	case PriorityNormal, PriorityHigh:
+		return p, nil
+	}
+	return "", parseErr{errors.Errorf("unknown priority %q", name)}
+}
+
+func (p *Priority) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err != nil {
+		return err
+	}
+	parsed, err := ParsePriority(name)
+	if err != nil {
+		return err
+	}
+	*p = parsed
+	return nil
+}
+
+// torrentControl is the download state of a torrent that is set through the
+// service.
+type torrentControl struct {
+	paused   bool
+	priority Priority
+}
+
+// Pause stops requesting pieces of the torrent. Pieces that are already
+// downloaded are still seeded.
+func (svc *Service) Pause(infoHash string) error {
+	return svc.updateControl(infoHash, func(c *torrentControl) {
+		c.paused = true
+	})
+}
+
+// Resume requests the pieces of a paused torrent again.
+func (svc *Service) Resume(infoHash string) error {
+	return svc.updateControl(infoHash, func(c *torrentControl) {
+		c.paused = false
+	})
+}
+
+func (svc *Service) SetPriority(infoHash string, priority Priority) error {
+	if _, err := ParsePriority(string(priority)); err != nil {
+		return err
+	}
+	return svc.updateControl(infoHash, func(c *torrentControl) {
+		c.priority = priority
+	})
+}
+
+// updateControl changes the download state of a torrent and saves it with the
+// torrent's state, like its labels, so that it is restored when the torrent is
+// loaded from the cache.
+func (svc *Service) updateControl(infoHash string, update func(c *torrentControl)) error {
+	t, err := svc.clientTorrent(infoHash)
+	if err != nil {
+		return err
+	}
+	infoHash = t.InfoHash().HexString()
+	svc.controlMu.Lock()
+	c := svc.control(infoHash)
+	update(&c)
+	svc.controls[infoHash] = c
+	svc.controlMu.Unlock()
+
+	select {
+	case <-t.GotInfo():
+		applyControl(t, c)
+	default:
+		// The control is applied once the info is received.
+	}
+	return svc.saveState(infoHash)
+}
+
+// control returns the download state of a torrent. svc.controlMu must be
+// held.
+func (svc *Service) control(infoHash string) torrentControl {
+	c, ok := svc.controls[infoHash]
+	if !ok {
+		c.priority = PriorityNormal
+	}
+	return c
+}
+
+// withControl returns the torrent with its download state.
+func (svc *Service) withControl(t *torrent.Torrent) Torrent {
+	svc.controlMu.RLock()
+	c := svc.control(t.InfoHash().HexString())
+	svc.controlMu.RUnlock()
+	tor := NewTorrent(t)
+	tor.Paused, tor.Priority = c.paused, c.priority
+	return tor
+}
+
+// torrentControl returns the download state of a torrent, for saving it.
+func (svc *Service) torrentControl(infoHash string) torrentControl {
+	svc.controlMu.RLock()
+	defer svc.controlMu.RUnlock()
+	return svc.control(infoHash)
+}
+
+// savedControl returns an AddOptionFunc that restores the saved download state
+// of a torrent. Unknown priorities are ignored.
+func savedControl(paused bool, priority string) AddOptionFunc {
+	return func(o *AddOptions) {
+		c := torrentControl{paused: paused, priority: PriorityNormal}
+		if p, err := ParsePriority(priority); err == nil {
+			c.priority = p
+		}
+		o.control = &c
+	}
+}
+
+// applyControl sets the piece priorities of a torrent, which must have its
+// info, from its download state.
+func applyControl(t *torrent.Torrent, c torrentControl) {
+	prio := torrent.PiecePriorityNone
+	if c.paused {
+		t.CancelPieces(0, t.NumPieces())
+	} else {
+		t.DownloadAll()
+		if c.priority == PriorityHigh {
+			prio = torrent.PiecePriorityHigh
+		}
+	}
+	files := t.Files()
+	for i := range files {
+		files[i].SetPriority(prio)
+	}
+}
@@ -0,0 +1,63 @@
+package torrential_test
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/anacrolix/torrent"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/joelanford/torrential"
+	"github.com/joelanford/torrential/cache"
+)
+
+func TestControlSaved(t *testing.T) {
+	c := cache.NewMemory()
+	newService := func() *torrential.Service {
+		svc, err := torrential.NewService(&torrential.Config{
+			ClientConfig: &torrent.Config{
+				ListenAddr:      "localhost:0",
+				NoDHT:           true,
+				DisableTrackers: true,
+			},
+			MemoryStorage: true,
+			Cache:         c,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return svc
+	}
+
+	svc := newService()
+	file, err := os.Open("testdata/sample.torrent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	added, err := svc.AddTorrentReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Info hashes are matched in any case.
+	assert.NoError(t, svc.Pause(strings.ToUpper(added.InfoHash)))
+	assert.NoError(t, svc.SetPriority(added.InfoHash, torrential.PriorityHigh))
+	paused, err := svc.Torrent(added.InfoHash)
+	if assert.NoError(t, err) {
+		assert.True(t, paused.Paused)
+		assert.Equal(t, torrential.PriorityHigh, paused.Priority)
+	}
+	assert.NoError(t, svc.Close(context.Background()))
+
+	// The download state is restored from the cache.
+	svc = newService()
+	defer svc.Close(context.Background())
+	restored, err := svc.Torrent(added.InfoHash)
+	if assert.NoError(t, err) {
+		assert.True(t, restored.Paused)
+		assert.Equal(t, torrential.PriorityHigh, restored.Priority)
+	}
+}
@@ -1,8 +1,7 @@
 package torrential
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"io"
 	"log"
 	"net/http"
@@ -10,20 +9,41 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/anacrolix/torrent"
 	"github.com/anacrolix/torrent/metainfo"
+	"github.com/anacrolix/torrent/storage"
 	"github.com/pkg/errors"
 
 	"github.com/joelanford/torrential/cache"
+	payload "github.com/joelanford/torrential/storage"
 )
 
 type Service struct {
 	client       *torrent.Client
 	multiEventer *MultiEventer
 	eventers     map[string]*TorrentEventer
+	fetchClient  *http.Client
+	webhooks     *webhookDispatcher
+	execs        *execRunner
+	labels       map[string][]string
+	controls     map[string]torrentControl
+	cacheReport  *cache.LoadReport
+	leased       map[string]time.Time
+	pending      map[string]bool
 	conf         *Config
+	done         chan struct{}
+	closing      bool
+	sinks        sync.WaitGroup
+	saves        sync.WaitGroup
+	loops        sync.WaitGroup
+	confMu       sync.RWMutex
 	eventerMu    sync.RWMutex
+	labelMu      sync.RWMutex
+	controlMu    sync.RWMutex
+	leaseMu      sync.RWMutex
+	pendingMu    sync.RWMutex
 }
 
 func NewService(conf *Config) (*Service, error) {
@@ -36,6 +56,43 @@ func NewService(conf *Config) (*Service, error) {
 	if conf.SeedRatio > 0 {
 		conf.ClientConfig.Seed = true
 	}
+	// Torrent data is stored in DataDir unless other storage is used.
+	localData := conf.ClientConfig.DefaultStorage == nil && !conf.MemoryStorage
+	if conf.MemoryStorage && conf.ClientConfig.DefaultStorage == nil {
+		conf.ClientConfig.DefaultStorage = payload.NewMemory(conf.MemoryStorageLimit)
+	}
+	if conf.PieceCompletion != nil && conf.ClientConfig.DefaultStorage == nil {
+		conf.ClientConfig.DefaultStorage = storage.NewFileWithCompletion(conf.ClientConfig.DataDir, conf.PieceCompletion)
+	}
+	if conf.EventFormat == "" {
+		conf.EventFormat = FormatJSON
+	}
+	if _, err := ParseEventFormat(string(conf.EventFormat)); err != nil {
+		return nil, err
+	}
+	if conf.EventSource == "" {
+		conf.EventSource = defaultEventSource
+	}
+	if conf.FetchTimeout <= 0 {
+		conf.FetchTimeout = defaultFetchTimeout
+	}
+	if conf.MaxTorrentSize <= 0 {
+		conf.MaxTorrentSize = defaultMaxTorrentSize
+	}
+	if conf.InfoTimeout <= 0 {
+		conf.InfoTimeout = defaultInfoTimeout
+	}
+
+	if conf.Leases != nil {
+		if _, ok := conf.Cache.(cache.TorrentLoader); !ok {
+			return nil, errors.Errorf("cache %T can't be shared with leases", conf.Cache)
+		}
+	}
+
+	webhooks, err := newWebhookDispatcher(conf)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not load webhook outbox")
+	}
 
 	client, err := torrent.NewClient(conf.ClientConfig)
 	if err != nil {
@@ -44,68 +101,369 @@ func NewService(conf *Config) (*Service, error) {
 	svc := &Service{
 		client:       client,
 		conf:         conf,
+		fetchClient:  newFetchClient(conf.FetchTimeout, conf.FetchPolicy),
+		webhooks:     webhooks,
+		execs:        newExecRunner(conf, localData),
 		multiEventer: newMultiEventer(),
 		eventers:     make(map[string]*TorrentEventer),
+		labels:       make(map[string][]string),
+		controls:     make(map[string]torrentControl),
+		leased:       make(map[string]time.Time),
+		pending:      make(map[string]bool),
+		done:         make(chan struct{}),
+	}
+	for _, sink := range conf.EventSinks {
+		svc.sinks.Add(1)
+		go svc.runSink(sink)
 	}
 	if svc.conf.Cache != nil {
-		specs, err := svc.conf.Cache.LoadTorrents()
+		if err := svc.loadCache(); err != nil {
+			return nil, err
+		}
+	}
+	if svc.conf.Leases != nil {
+		svc.loops.Add(1)
+		go svc.runLeases()
+	}
+	if svc.conf.WatchCache {
+		watcher, ok := svc.conf.Cache.(cache.Watcher)
+		if !ok {
+			return nil, errors.Errorf("cache %T can't be watched", svc.conf.Cache)
+		}
+		changes, err := watcher.Watch(svc.done)
 		if err != nil {
-			return nil, errors.Wrap(err, "could not load cache")
+			return nil, errors.Wrap(err, "could not watch cache")
 		}
-		for _, spec := range specs {
-			if _, err := svc.addTorrentSpec(&spec); err != nil {
-				return nil, err
+		svc.loops.Add(1)
+		go svc.runWatch(changes)
+	}
+	return svc, nil
+}
+
+// Close stops the service. It waits for the lease and cache watch loops to
+// stop, releases the leases of its torrents, stops the client, and sends a
+// final ServiceClosed event to webhooks, exec hooks, event sinks and event
+// streams, after which the event streams are closed. Torrents are not
+// dropped, so their Closed events are only sent to event streams. Close then
+// waits until pending webhook deliveries, exec hooks, background writes of the
+// storage and saves of torrent metadata have finished, or ctx is done, and
+// closes Config.PieceCompletion and Config.Cache if they can be closed. The
+// cache is left open if the loops or saves that use it didn't finish in time.
+// Deliveries that are still pending stay in Config.WebhookStore, if set, and
+// are retried when the service restarts; retries that are waiting out their
+// backoff are not attempted again.
+func (svc *Service) Close(ctx context.Context) error {
+	svc.confMu.Lock()
+	if svc.closing {
+		svc.confMu.Unlock()
+		return nil
+	}
+	svc.closing = true
+	close(svc.done)
+	svc.confMu.Unlock()
+
+	// The lease and watch loops stop when done is closed, but may still be
+	// adding or dropping torrents until then.
+	loopsErr := errors.Wrap(waitGroup(ctx, &svc.loops), "could not stop background tasks")
+	if svc.conf.Leases != nil {
+		svc.releaseLeases()
+	}
+	svc.recordCompletion()
+	svc.client.Close()
+
+	e := Event{Type: ServiceClosed}
+	svc.webhooks.dispatch(e, nil)
+	svc.execs.dispatch(e)
+	svc.multiEventer.close(e)
+
+	err := loopsErr
+	if err == nil {
+		err = waitGroup(ctx, &svc.sinks)
+	}
+	if err == nil {
+		err = errors.Wrap(svc.webhooks.wait(ctx), "could not deliver pending webhooks")
+	}
+	svc.webhooks.stop()
+	if err == nil {
+		err = errors.Wrap(svc.execs.wait(ctx), "could not wait for exec hooks")
+	}
+	if waiter, ok := svc.conf.ClientConfig.DefaultStorage.(payload.Waiter); ok && err == nil {
+		err = errors.Wrap(waiter.Wait(ctx), "could not write torrent data to storage")
+	}
+	savesErr := errors.Wrap(waitGroup(ctx, &svc.saves), "could not wait for torrent metadata to be saved")
+	if err == nil {
+		err = savesErr
+	}
+	if loopsErr != nil || savesErr != nil {
+		return err
+	}
+	if svc.conf.PieceCompletion != nil {
+		if cerr := svc.conf.PieceCompletion.Close(); cerr != nil && err == nil {
+			err = errors.Wrap(cacheErr{cerr}, "could not close piece completion")
+		}
+	}
+	if closer, ok := svc.conf.Cache.(io.Closer); ok {
+		if cerr := closer.Close(); cerr != nil && err == nil {
+			err = errors.Wrap(cacheErr{cerr}, "could not close cache")
+		}
+	}
+	return err
+}
+
+func (svc *Service) isClosing() bool {
+	svc.confMu.RLock()
+	defer svc.confMu.RUnlock()
+	return svc.closing
+}
+
+// waitGroup waits for wg, or until ctx is done.
+func waitGroup(ctx context.Context, wg *sync.WaitGroup) error {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// SetSeedRatio sets the seed ratio of the active torrents and of the torrents
+// added later. Torrents are only seeded if Config.SeedRatio was positive when
+// the service was created.
+func (svc *Service) SetSeedRatio(seedRatio float64) {
+	svc.confMu.Lock()
+	svc.conf.SeedRatio = seedRatio
+	svc.confMu.Unlock()
+
+	svc.eventerMu.RLock()
+	defer svc.eventerMu.RUnlock()
+	for _, e := range svc.eventers {
+		e.SetSeedRatio(seedRatio)
+	}
+}
+
+// SetDropWhenDone sets whether torrents are dropped when their seed ratio is
+// met.
+func (svc *Service) SetDropWhenDone(dropWhenDone bool) {
+	svc.confMu.Lock()
+	defer svc.confMu.Unlock()
+	svc.conf.DropWhenDone = dropWhenDone
+}
+
+func (svc *Service) dropWhenDone() bool {
+	svc.confMu.RLock()
+	defer svc.confMu.RUnlock()
+	return svc.conf.DropWhenDone
+}
+
+// loadCache adds the cached torrents and sends a CacheLoaded event with the
+// load report. Cache entries that could not be loaded have already been
+// quarantined by the cache, and duplicate entries are skipped. If the cache
+// is shared, only the torrents whose leases this instance owns are added.
+func (svc *Service) loadCache() error {
+	specs, report, err := svc.conf.Cache.LoadTorrents()
+	if err != nil {
+		return errors.Wrap(err, "could not load cache")
+	}
+	for _, q := range report.Quarantined {
+		log.Printf("quarantined cache entry %s: %s", q.Name, q.Error)
+	}
+	specs = svc.filterLeased(specs)
+	for i := range specs {
+		svc.checkCompletion(&specs[i])
+		options := append(svc.cachedOptions(specs[i].InfoHash.HexString()), leased())
+		if _, err := svc.addTorrentSpec(context.Background(), &specs[i], options...); err != nil {
+			if _, ok := errors.Cause(err).(existsErr); ok {
+				continue
 			}
+			return err
+		}
+	}
+	if err := svc.loadPending(); err != nil {
+		return err
+	}
+	svc.cacheReport = report
+
+	e := Event{Type: CacheLoaded, Report: report}
+	svc.webhooks.dispatch(e, nil)
+	svc.execs.dispatch(e)
+	for _, sink := range svc.conf.EventSinks {
+		if err := sink.PublishEvent(e); err != nil {
+			log.Printf("error publishing %s event: %s", e.Type, err)
+		}
+	}
+	return nil
+}
+
+// runWatch adds torrents that are added to the cache by other processes, and
+// drops torrents that are removed from it. Changes made by the service itself
+// show up as torrents that already exist or are already dropped.
+func (svc *Service) runWatch(changes <-chan cache.Change) {
+	defer svc.loops.Done()
+	for change := range changes {
+		infoHash := change.InfoHash.HexString()
+		switch change.Type {
+		case cache.EntryAdded:
+			svc.checkCompletion(change.Spec)
+			if _, err := svc.addTorrentSpec(context.Background(), change.Spec, svc.cachedOptions(infoHash)...); err != nil {
+				if _, ok := errors.Cause(err).(existsErr); !ok {
+					log.Printf("error adding torrent %s from cache: %s", infoHash, err)
+				}
+				continue
+			}
+			log.Printf("added torrent %s from cache", infoHash)
+		case cache.EntryRemoved:
+			t, ok := svc.client.Torrent(change.InfoHash)
+			if !ok {
+				continue
+			}
+			svc.dropLocal(t, infoHash)
+			if svc.conf.Leases != nil {
+				svc.leaseMu.Lock()
+				delete(svc.leased, infoHash)
+				svc.leaseMu.Unlock()
+				if err := svc.conf.Leases.Release(infoHash); err != nil {
+					log.Printf("error releasing lease of torrent %s: %s", infoHash, err)
+				}
+			}
+			log.Printf("dropped torrent %s removed from cache", infoHash)
+		}
+	}
+}
+
+// checkCompletion discards the stored piece completion of a cached torrent if
+// it does not match the torrent data, so that the client verifies the data
+// instead of trusting stale completion.
+func (svc *Service) checkCompletion(spec *torrent.TorrentSpec) {
+	if svc.conf.PieceCompletion == nil || spec.InfoBytes == nil {
+		return
+	}
+	info, err := (&metainfo.MetaInfo{InfoBytes: spec.InfoBytes}).UnmarshalInfo()
+	if err != nil {
+		return
+	}
+	fresh, err := svc.conf.PieceCompletion.Check(spec.InfoHash, &info, svc.conf.ClientConfig.DataDir)
+	if err != nil {
+		log.Printf("error checking piece completion of torrent %s: %s", spec.InfoHash.HexString(), err)
+	} else if !fresh {
+		log.Printf("piece completion of torrent %s is stale, verifying data", spec.InfoHash.HexString())
+	}
+}
+
+// recordCompletion records the modification times of the files of the torrents
+// with info, so that data written after their last completed piece doesn't
+// make their completion look stale when they are loaded again.
+func (svc *Service) recordCompletion() {
+	if svc.conf.PieceCompletion == nil {
+		return
+	}
+	for _, t := range svc.client.Torrents() {
+		info := t.Info()
+		if info == nil {
+			continue
+		}
+		if err := svc.conf.PieceCompletion.Record(t.InfoHash(), info, svc.conf.ClientConfig.DataDir); err != nil {
+			log.Printf("error recording piece completion of torrent %s: %s", t.InfoHash().HexString(), err)
 		}
 	}
-	return svc, nil
 }
 
 func (svc *Service) Torrents() (torrents []Torrent) {
 	for _, torrent := range svc.client.Torrents() {
-		torrents = append(torrents, Torrent{torrent})
+		torrents = append(torrents, svc.withControl(torrent))
 	}
 	return
 }
 
 func (svc *Service) Torrent(infoHash string) (*Torrent, error) {
+	torrent, err := svc.clientTorrent(infoHash)
+	if err != nil {
+		return nil, err
+	}
+	t := svc.withControl(torrent)
+	return &t, nil
+}
+
+// clientTorrent returns the torrent of the client with the given info hash.
+func (svc *Service) clientTorrent(infoHash string) (*torrent.Torrent, error) {
 	var h metainfo.Hash
 	if err := h.FromHexString(infoHash); err != nil {
 		return nil, errors.Wrap(parseErr{err}, "bad torrent hash")
 	}
-	torrent, ok := svc.client.Torrent(h)
+	t, ok := svc.client.Torrent(h)
 	if !ok {
 		return nil, notFoundErr{errors.New("torrent not found")}
 	}
-	return &Torrent{torrent}, nil
+	return t, nil
 }
 
-func (svc *Service) AddTorrentReader(torrentReader io.Reader) (*Torrent, error) {
-	mi, err := metainfo.Load(torrentReader)
-	if err != nil {
-		return nil, errors.Wrap(parseErr{err}, "could not parse spec from torrent")
-	}
-	return svc.addTorrentSpec(torrent.TorrentSpecFromMetaInfo(mi))
+func (svc *Service) AddTorrentReader(torrentReader io.Reader, options ...AddOptionFunc) (*Torrent, error) {
+	return svc.AddTorrentReaderContext(context.Background(), torrentReader, options...)
 }
 
-func (svc *Service) AddTorrentURL(torrentURL string) (*Torrent, error) {
-	resp, err := http.Get(torrentURL)
+// AddTorrentReaderContext is like AddTorrentReader, but stops waiting for the
+// torrent info when ctx is done. See Config.InfoTimeout.
+func (svc *Service) AddTorrentReaderContext(ctx context.Context, torrentReader io.Reader, options ...AddOptionFunc) (*Torrent, error) {
+	mi, err := loadMetainfo(torrentReader, svc.conf.MaxTorrentSize)
 	if err != nil {
-		return nil, errors.Wrap(fetchErr{err}, "could not fetch torrent")
+		return nil, err
 	}
-	mi, err := metainfo.Load(resp.Body)
+	return svc.addTorrentSpec(ctx, torrent.TorrentSpecFromMetaInfo(mi), options...)
+}
+
+func (svc *Service) AddTorrentURL(torrentURL string, options ...AddOptionFunc) (*Torrent, error) {
+	return svc.AddTorrentURLContext(context.Background(), torrentURL, options...)
+}
+
+// AddTorrentURLContext is like AddTorrentURL, but cancels the request for the
+// torrent file and stops waiting for the torrent info when ctx is done.
+func (svc *Service) AddTorrentURLContext(ctx context.Context, torrentURL string, options ...AddOptionFunc) (*Torrent, error) {
+	mi, err := fetchMetainfo(ctx, svc.fetchClient, torrentURL, svc.conf.MaxTorrentSize)
 	if err != nil {
-		return nil, errors.Wrap(parseErr{err}, "could not parse spec from torrent")
+		return nil, err
 	}
-	return svc.addTorrentSpec(torrent.TorrentSpecFromMetaInfo(mi))
+	return svc.addTorrentSpec(ctx, torrent.TorrentSpecFromMetaInfo(mi), options...)
+}
+
+func (svc *Service) AddMagnetURI(magnetURI string, options ...AddOptionFunc) (*Torrent, error) {
+	return svc.AddMagnetURIContext(context.Background(), magnetURI, options...)
 }
 
-func (svc *Service) AddMagnetURI(magnetURI string) (*Torrent, error) {
+// AddMagnetURIContext is like AddMagnetURI, but stops waiting for the torrent
+// info when ctx is done. See Config.InfoTimeout.
+func (svc *Service) AddMagnetURIContext(ctx context.Context, magnetURI string, options ...AddOptionFunc) (*Torrent, error) {
 	spec, err := torrent.TorrentSpecFromMagnetURI(magnetURI)
 	if err != nil {
 		return nil, errors.Wrap(parseErr{err}, "could not parse spec from magnet URI")
 	}
-	return svc.addTorrentSpec(spec)
+	return svc.addTorrentSpec(ctx, spec, options...)
+}
+
+func (svc *Service) Labels(infoHash string) ([]string, error) {
+	t, err := svc.Torrent(infoHash)
+	if err != nil {
+		return nil, err
+	}
+	return svc.torrentLabels(t.InfoHash), nil
+}
+
+// SetLabels replaces the labels of a torrent. If Config.Cache implements
+// cache.StateCache, the labels are saved with the torrent's state, so that
+// they are restored when the torrent is loaded from the cache.
+func (svc *Service) SetLabels(infoHash string, labels []string) error {
+	t, err := svc.Torrent(infoHash)
+	if err != nil {
+		return err
+	}
+	infoHash = t.InfoHash
+	svc.labelMu.Lock()
+	svc.labels[infoHash] = labels
+	svc.labelMu.Unlock()
+	return svc.saveState(infoHash)
 }
 
 func (svc *Service) Eventer(infoHash string) (*TorrentEventer, error) {
@@ -122,6 +480,95 @@ func (svc *Service) MultiEventer() *MultiEventer {
 	return svc.multiEventer
 }
 
+func (svc *Service) TorrentsEventer() Eventer {
+	return svc.multiEventer
+}
+
+func (svc *Service) TorrentEventer(infoHash string) (Eventer, error) {
+	e, err := svc.Eventer(infoHash)
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (svc *Service) EventFormat() EventFormat {
+	return svc.conf.EventFormat
+}
+
+func (svc *Service) EventSource() string {
+	return svc.conf.EventSource
+}
+
+func (svc *Service) CacheReport() *cache.LoadReport {
+	return svc.cacheReport
+}
+
+func (svc *Service) Leases() []cache.Lease {
+	if svc.conf.Leases == nil {
+		return nil
+	}
+	return svc.ownedLeases()
+}
+
+func (svc *Service) InstanceID() string {
+	if svc.conf.Leases == nil {
+		return ""
+	}
+	return svc.conf.Leases.Owner()
+}
+
+func (svc *Service) ExecResults(infoHash string) ([]ExecResult, error) {
+	results, ok := svc.execs.resultsFor(infoHash)
+	if !ok {
+		// Results are kept after a torrent is dropped, so only check that
+		// the torrent exists if no results were found.
+		if _, err := svc.Torrent(infoHash); err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
+
+func (svc *Service) Webhooks() []Webhook {
+	return svc.webhooks.webhooks()
+}
+
+func (svc *Service) Webhook(id string) (*Webhook, error) {
+	return svc.webhooks.webhook(id)
+}
+
+func (svc *Service) AddWebhook(w Webhook) (*Webhook, error) {
+	return svc.webhooks.addWebhook(w)
+}
+
+func (svc *Service) UpdateWebhook(id string, w Webhook) (*Webhook, error) {
+	return svc.webhooks.updateWebhook(id, w)
+}
+
+func (svc *Service) DeleteWebhook(id string) error {
+	return svc.webhooks.deleteWebhook(id)
+}
+
+func (svc *Service) DeadLetters() []WebhookDelivery {
+	return svc.webhooks.deadLetters()
+}
+
+func (svc *Service) DeadLetter(id string) (*WebhookDelivery, error) {
+	return svc.webhooks.deadLetter(id)
+}
+
+func (svc *Service) ReplayDeadLetter(id string) (*WebhookDelivery, error) {
+	return svc.webhooks.replay(id)
+}
+
+func (svc *Service) DeleteDeadLetter(id string) error {
+	return svc.webhooks.deleteDeadLetter(id)
+}
+
+// Drop drops a torrent and deletes it from the cache. If deleteFiles is set,
+// its data is deleted too, by ClientConfig.DefaultStorage if it implements
+// storage.Deleter and from ClientConfig.DataDir otherwise.
 func (svc *Service) Drop(infoHash string, deleteFiles bool) error {
 	var h metainfo.Hash
 	if err := h.FromHexString(infoHash); err != nil {
@@ -131,18 +578,35 @@ func (svc *Service) Drop(infoHash string, deleteFiles bool) error {
 	if !ok {
 		return notFoundErr{errors.New("torrent not found")}
 	}
-	t.Drop()
-
-	svc.eventerMu.Lock()
-	delete(svc.eventers, infoHash)
-	svc.eventerMu.Unlock()
+	infoHash = h.HexString()
+	svc.dropLocal(t, infoHash)
 
 	if svc.conf.Cache != nil {
 		if err := svc.conf.Cache.DeleteTorrent(t); err != nil {
 			return errors.Wrap(deleteErr{err}, "could not delete cached torrent metadata")
 		}
+		if err := svc.deletePending(t.InfoHash().HexString()); err != nil {
+			return errors.Wrap(deleteErr{err}, "could not delete torrent placeholder")
+		}
+	}
+	if svc.conf.PieceCompletion != nil {
+		if err := svc.conf.PieceCompletion.Forget(h); err != nil {
+			return errors.Wrap(deleteErr{err}, "could not delete piece completion")
+		}
 	}
-	if deleteFiles {
+	if svc.conf.Leases != nil {
+		svc.leaseMu.Lock()
+		delete(svc.leased, infoHash)
+		svc.leaseMu.Unlock()
+		if err := svc.conf.Leases.Release(infoHash); err != nil {
+			return errors.Wrap(deleteErr{err}, "could not release lease")
+		}
+	}
+	if deleter, ok := svc.conf.ClientConfig.DefaultStorage.(payload.Deleter); ok && deleteFiles {
+		if err := deleter.DeleteTorrent(h); err != nil {
+			return errors.Wrap(deleteErr{err}, "could not delete torrent data")
+		}
+	} else if deleteFiles {
 		directories := make(map[string]struct{})
 		for _, f := range t.Files() {
 			dirs := strings.Split(f.Path(), string(os.PathSeparator))
@@ -162,7 +626,147 @@ func (svc *Service) Drop(infoHash string, deleteFiles bool) error {
 	return nil
 }
 
-func (svc *Service) addTorrentSpec(spec *torrent.TorrentSpec) (*Torrent, error) {
+// dropLocal drops the torrent from the client without touching the cache or
+// the torrent data.
+func (svc *Service) dropLocal(t *torrent.Torrent, infoHash string) {
+	t.Drop()
+
+	svc.eventerMu.Lock()
+	delete(svc.eventers, infoHash)
+	svc.eventerMu.Unlock()
+
+	svc.labelMu.Lock()
+	delete(svc.labels, infoHash)
+	svc.labelMu.Unlock()
+
+	svc.controlMu.Lock()
+	delete(svc.controls, infoHash)
+	svc.controlMu.Unlock()
+}
+
+// saveTorrent saves the metadata of a torrent in the cache, which waits for
+// the torrent info. If ctx is done or Config.InfoTimeout passes first, the
+// metadata is saved in the background once the info is received.
+func (svc *Service) saveTorrent(ctx context.Context, t *torrent.Torrent, background bool) error {
+	infoHash := t.InfoHash().HexString()
+	saved := make(chan error, 1)
+	go func() {
+		saved <- svc.conf.Cache.SaveTorrent(t)
+	}()
+	if !background {
+		ctx, cancel := context.WithTimeout(ctx, svc.conf.InfoTimeout)
+		defer cancel()
+		select {
+		case err := <-saved:
+			if err != nil {
+				return errors.Wrap(cacheErr{err}, "could not save torrent metadata")
+			}
+			return svc.saveState(infoHash)
+		case <-ctx.Done():
+		}
+	}
+
+	// Until the info is received, a placeholder keeps the torrent in the
+	// cache.
+	svc.setPending(infoHash)
+	if err := svc.savePending(t); err != nil {
+		return err
+	}
+	svc.confMu.RLock()
+	closing := svc.closing
+	if !closing {
+		svc.saves.Add(1)
+	}
+	svc.confMu.RUnlock()
+	if closing {
+		return nil
+	}
+	go func() {
+		defer svc.saves.Done()
+		if err := <-saved; err != nil {
+			// The torrent was dropped or the service closed before the info
+			// was received. Close keeps the placeholder, and Drop deletes it.
+			return
+		}
+		if err := svc.deletePending(infoHash); err != nil {
+			log.Printf("error deleting placeholder of torrent %s: %s", infoHash, err)
+		}
+		if err := svc.saveState(infoHash); err != nil {
+			log.Printf("error saving metadata of torrent %s: %s", infoHash, err)
+		}
+	}()
+	return nil
+}
+
+// saveState saves the labels and download state of a torrent in its cached
+// state, if Config.Cache implements cache.StateCache. Torrents whose metadata
+// is not saved yet are skipped, since their state is saved along with their
+// metadata.
+func (svc *Service) saveState(infoHash string) error {
+	sc, ok := svc.conf.Cache.(cache.StateCache)
+	if !ok {
+		return nil
+	}
+	state, err := sc.State(infoHash)
+	if errors.Cause(err) == cache.ErrNotFound {
+		if svc.isPending(infoHash) {
+			t, ok := svc.client.Torrent(metainfo.NewHashFromHex(infoHash))
+			if ok {
+				return svc.savePending(t)
+			}
+		}
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(cacheErr{err}, "could not load torrent state")
+	}
+	c := svc.torrentControl(infoHash)
+	state.Labels = svc.torrentLabels(infoHash)
+	state.Paused, state.Priority = c.paused, string(c.priority)
+	if err := sc.SetState(infoHash, *state); err != nil {
+		return errors.Wrap(cacheErr{err}, "could not save torrent state")
+	}
+	return nil
+}
+
+// cachedOptions returns the AddOptionFuncs that restore the cached state of a
+// torrent, if Config.Cache implements cache.StateCache.
+func (svc *Service) cachedOptions(infoHash string) []AddOptionFunc {
+	sc, ok := svc.conf.Cache.(cache.StateCache)
+	if !ok {
+		return nil
+	}
+	state, err := sc.State(infoHash)
+	if err != nil {
+		if errors.Cause(err) != cache.ErrNotFound {
+			log.Printf("error loading state of torrent %s: %s", infoHash, err)
+		}
+		return nil
+	}
+	return []AddOptionFunc{Labels(state.Labels...), savedControl(state.Paused, state.Priority)}
+}
+
+func (svc *Service) torrentLabels(infoHash string) []string {
+	svc.labelMu.RLock()
+	defer svc.labelMu.RUnlock()
+	return svc.labels[infoHash]
+}
+
+func (svc *Service) addTorrentSpec(ctx context.Context, spec *torrent.TorrentSpec, options ...AddOptionFunc) (*Torrent, error) {
+	opts := ResolveAddOptions(options...)
+
+	if svc.conf.Leases != nil && !opts.leased {
+		infoHash := spec.InfoHash.HexString()
+		owned, err := svc.conf.Leases.Acquire(infoHash)
+		if err != nil {
+			return nil, errors.Wrap(cacheErr{err}, "could not acquire lease")
+		}
+		if !owned {
+			return nil, existsErr{errors.New("torrent is owned by another instance")}
+		}
+		svc.setLeased(infoHash)
+	}
+
 	t, new, err := svc.client.AddTorrentSpec(spec)
 	if !new {
 		return nil, existsErr{errors.New("torrent already exists")}
@@ -171,62 +775,196 @@ func (svc *Service) addTorrentSpec(spec *torrent.TorrentSpec) (*Torrent, error)
 		return nil, errors.Wrap(addTorrentErr{err}, "could not add torrent")
 	}
 
-	torrent := Torrent{t}
+	infoHash := spec.InfoHash.HexString()
+
+	// Set the labels before the eventer is created, so that webhooks with
+	// label filters see the labels from the very first event.
+	if len(opts.Labels) > 0 {
+		svc.labelMu.Lock()
+		svc.labels[infoHash] = opts.Labels
+		svc.labelMu.Unlock()
+	}
+	if opts.control != nil {
+		svc.controlMu.Lock()
+		svc.controls[infoHash] = *opts.control
+		svc.controlMu.Unlock()
+	}
 
-	e := newTorrentEventer(torrent, SeedRatio(svc.conf.SeedRatio))
+	svc.confMu.RLock()
+	seedRatio := svc.conf.SeedRatio
+	svc.confMu.RUnlock()
+	e := newTorrentEventer(t, SeedRatio(seedRatio))
 	svc.multiEventer.add(e)
 
 	svc.eventerMu.Lock()
-	svc.eventers[spec.InfoHash.String()] = e
+	svc.eventers[infoHash] = e
 	svc.eventerMu.Unlock()
 
 	if svc.conf.Cache != nil {
-		if err := svc.conf.Cache.SaveTorrent(t); err != nil {
-			return nil, errors.Wrap(cacheErr{err}, "could not save torrent metadata")
+		if err := svc.saveTorrent(ctx, t, opts.background); err != nil {
+			return nil, err
 		}
 	}
 	go func() {
 		select {
 		case <-e.Closed():
 		case <-e.GotInfo():
-			t.DownloadAll()
+			svc.controlMu.RLock()
+			c := svc.control(infoHash)
+			svc.controlMu.RUnlock()
+			applyControl(t, c)
 		}
 	}()
 	go func() {
 		background := make(chan struct{})
 		for event := range e.Events(background) {
-			if svc.conf.WebhookURL != "" {
-				if err := invokeWebhook(event, svc.conf.WebhookURL); err != nil {
-					log.Printf("error invoking webhook %s for %s event for torrent %s: %s", svc.conf.WebhookURL, event.Type, event.Torrent.InfoHash().String(), err)
-				}
+			if event.Type == Closed && svc.isClosing() {
+				// The torrent was closed by Close, not dropped.
+				continue
 			}
-			if event.Type == SeedingDone && svc.conf.DropWhenDone {
-				event.Torrent.Drop()
+			svc.webhooks.dispatch(event, svc.torrentLabels(infoHash))
+			svc.execs.dispatch(event)
+			if event.Type == SeedingDone && svc.dropWhenDone() {
+				t.Drop()
 			}
 		}
 	}()
-	return &torrent, nil
+	added := svc.withControl(t)
+	return &added, nil
 }
 
 type Config struct {
 	ClientConfig *torrent.Config
 	Cache        cache.Cache
-	WebhookURL   string
 	SeedRatio    float64
 	DropWhenDone bool
+
+	// PieceCompletion persists piece completion, so that cached torrents
+	// resume without hashing their data again. It is used with file storage
+	// in ClientConfig.DataDir, unless ClientConfig.DefaultStorage is set.
+	PieceCompletion cache.PieceCompletion
+
+	// MemoryStorage keeps torrent data in memory instead of in
+	// ClientConfig.DataDir, unless ClientConfig.DefaultStorage is set. Data
+	// is lost when torrents are dropped or the service stops.
+	MemoryStorage bool
+
+	// MemoryStorageLimit is the maximum number of bytes of torrent data kept
+	// in memory. Pieces that don't fit fail to download. If 0, there is no
+	// limit.
+	MemoryStorageLimit int64
+
+	// Leases coordinates instances that share Cache. If set, each instance
+	// only runs the torrents whose leases it owns, and takes over the
+	// torrents of instances that stop renewing their leases. Cache must
+	// implement cache.TorrentLoader.
+	Leases *cache.Leases
+
+	// WatchCache makes the service add torrents that other processes add to
+	// the cache, and drop torrents that they remove from it, while it runs.
+	// Cache must implement cache.Watcher.
+	WatchCache bool
+
+	// EventFormat is the default encoding of events sent to webhooks and
+	// event streams. It defaults to FormatJSON.
+	EventFormat EventFormat
+
+	// EventSource is the CloudEvents source attribute of events. It defaults
+	// to "/torrential".
+	EventSource string
+
+	// EventSinks receive all torrent events.
+	EventSinks []EventSink
+
+	// Webhooks are invoked for torrent events, in addition to the webhooks
+	// added at runtime. Webhooks without an ID are given one based on their
+	// position in the list.
+	Webhooks []Webhook
+
+	// WebhookSecret is the shared secret used to sign webhook requests. If
+	// empty, requests are not signed. See VerifyWebhookSignature.
+	WebhookSecret string
+
+	// WebhookStore persists the webhook outbox and dead letters. If nil,
+	// pending deliveries are lost when the service stops.
+	WebhookStore WebhookStore
+
+	// WebhookTimeout is the timeout of each webhook request.
+	WebhookTimeout time.Duration
+
+	// WebhookMaxAttempts is the number of times a webhook delivery is
+	// attempted before it is moved to the dead letter store.
+	WebhookMaxAttempts int
+
+	// WebhookBackoff is the wait after the first failed attempt. It doubles
+	// after each subsequent attempt, up to WebhookMaxBackoff.
+	WebhookBackoff    time.Duration
+	WebhookMaxBackoff time.Duration
+
+	// ExecHooks are commands that are run for torrent events.
+	ExecHooks []ExecHook
+
+	// ExecTimeout is the default timeout of exec hook commands.
+	ExecTimeout time.Duration
+
+	// ExecConcurrency is the maximum number of exec hook commands that run
+	// at the same time.
+	ExecConcurrency int
+
+	// FetchTimeout is the timeout of requests for torrent files added by URL,
+	// including reading the response. It defaults to 30 seconds.
+	FetchTimeout time.Duration
+
+	// FetchPolicy restricts the URLs that torrent files are fetched from.
+	FetchPolicy FetchPolicy
+
+	// MaxTorrentSize is the maximum size in bytes of added torrent files. It
+	// defaults to 10 MiB.
+	MaxTorrentSize int64
+
+	// InfoTimeout is how long adding a torrent waits for its info, e.g. from
+	// the peers of a magnet link, to save its metadata in Cache. After that,
+	// the torrent is returned without its info, and its metadata is saved
+	// once the info is received. Until then, if Cache implements
+	// cache.BlobStore, a placeholder with the torrent's magnet link is kept
+	// in it, so that the torrent is added again when the service restarts.
+	// It defaults to one minute.
+	InfoTimeout time.Duration
 }
 
-func invokeWebhook(e Event, url string) error {
-	jsonData, err := json.Marshal(eventResult{e})
-	if err != nil {
-		return err
-	}
-	resp, err := http.Post(url, "application/json", bytes.NewReader(jsonData))
-	if err != nil {
-		return err
+// AddOptionFunc configures a torrent as it is added to the service.
+type AddOptionFunc func(o *AddOptions)
+
+// AddOptions are the options of a torrent that is added to a TorrentService.
+// Implementations of TorrentService get them from the AddOptionFuncs they are
+// passed with ResolveAddOptions.
+type AddOptions struct {
+	Labels []string
+
+	// leased is set for torrents whose lease is already owned.
+	leased bool
+
+	// background is set for torrents whose metadata is saved without
+	// waiting for their info.
+	background bool
+
+	// control is the saved download state of torrents loaded from the cache.
+	control *torrentControl
+}
+
+// ResolveAddOptions returns the options that the AddOptionFuncs set.
+func ResolveAddOptions(options ...AddOptionFunc) AddOptions {
+	var opts AddOptions
+	for _, o := range options {
+		o(&opts)
 	}
-	if resp.StatusCode >= 400 {
-		return errors.New(resp.Status)
+	return opts
+}
+
+// Labels returns an AddOptionFunc that sets the labels of the torrent. Labels
+// can be used to filter the torrents that webhooks are invoked for.
+func Labels(labels ...string) AddOptionFunc {
+	return func(o *AddOptions) {
+		o.Labels = append(o.Labels, labels...)
 	}
-	return nil
 }
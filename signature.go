@@ -0,0 +1,73 @@
+package torrential
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// WebhookDeliveryHeader holds the ID of a webhook delivery. The ID is the
+	// same for every attempt of a delivery, so receivers can use it to ignore
+	// duplicates.
+	WebhookDeliveryHeader = "X-Torrential-Delivery"
+
+	// WebhookTimestampHeader holds the unix time at which a webhook request
+	// was signed.
+	WebhookTimestampHeader = "X-Torrential-Timestamp"
+
+	// WebhookSignatureHeader holds the HMAC-SHA256 signature of a webhook
+	// request, formatted as "sha256=<hex digest>".
+	WebhookSignatureHeader = "X-Torrential-Signature"
+
+	signaturePrefix = "sha256="
+)
+
+// SignWebhook returns the signature of a webhook body sent at the given time.
+// The signature is the HMAC-SHA256 of the unix timestamp, a period, and the
+// body, keyed with the shared secret.
+func SignWebhook(secret string, timestamp time.Time, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp.Unix(), 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhookSignature checks the signature headers of a webhook request
+// received from torrential against its body. Requests signed more than
+// tolerance ago (or in the future) are rejected to prevent replays. A
+// tolerance of 0 disables the timestamp check.
+func VerifyWebhookSignature(secret string, header http.Header, body []byte, tolerance time.Duration) error {
+	ts := header.Get(WebhookTimestampHeader)
+	if ts == "" {
+		return errors.New("missing webhook timestamp")
+	}
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return errors.Wrap(err, "invalid webhook timestamp")
+	}
+	timestamp := time.Unix(unix, 0)
+	if tolerance > 0 {
+		age := time.Since(timestamp)
+		if age > tolerance || age < -tolerance {
+			return errors.New("webhook timestamp outside of tolerance")
+		}
+	}
+
+	signature := header.Get(WebhookSignatureHeader)
+	if !strings.HasPrefix(signature, signaturePrefix) {
+		return errors.New("missing webhook signature")
+	}
+	expected := SignWebhook(secret, timestamp, body)
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return errors.New("webhook signature mismatch")
+	}
+	return nil
+}
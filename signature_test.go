@@ -0,0 +1,33 @@
+package torrential_test
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/joelanford/torrential"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifyWebhookSignature(t *testing.T) {
+	body := []byte(`{"event":{"type":"added"}}`)
+	now := time.Now()
+
+	header := http.Header{}
+	header.Set(torrential.WebhookTimestampHeader, strconv.FormatInt(now.Unix(), 10))
+	header.Set(torrential.WebhookSignatureHeader, torrential.SignWebhook("secret", now, body))
+
+	assert.NoError(t, torrential.VerifyWebhookSignature("secret", header, body, time.Minute))
+	assert.Error(t, torrential.VerifyWebhookSignature("other", header, body, time.Minute))
+	assert.Error(t, torrential.VerifyWebhookSignature("secret", header, []byte(`{}`), time.Minute))
+
+	// Old requests are only rejected when a tolerance is set
+	old := now.Add(-time.Hour)
+	header.Set(torrential.WebhookTimestampHeader, strconv.FormatInt(old.Unix(), 10))
+	header.Set(torrential.WebhookSignatureHeader, torrential.SignWebhook("secret", old, body))
+	assert.Error(t, torrential.VerifyWebhookSignature("secret", header, body, time.Minute))
+	assert.NoError(t, torrential.VerifyWebhookSignature("secret", header, body, 0))
+
+	assert.Error(t, torrential.VerifyWebhookSignature("secret", http.Header{}, body, 0))
+}
@@ -0,0 +1,47 @@
+package sink
+
+import (
+	"strings"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/joelanford/torrential"
+)
+
+// MQTT publishes events to an MQTT broker. Each event is published to the
+// topic <prefix>/<event type>/<info hash>, so subscribers can filter with
+// wildcards, e.g. torrential/downloadDone/#. Events without a torrent are
+// published to <prefix>/<event type>.
+type MQTT struct {
+	Options
+
+	// QoS is the MQTT quality of service level of published events.
+	QoS byte
+
+	client mqtt.Client
+}
+
+var _ torrential.EventSink = &MQTT{}
+
+// NewMQTT returns an MQTT sink that publishes with the given client, which
+// must already be connected.
+func NewMQTT(client mqtt.Client, options Options) *MQTT {
+	return &MQTT{
+		Options: options,
+		client:  client,
+	}
+}
+
+// Topic returns the topic that the event is published to.
+func (s *MQTT) Topic(e torrential.Event) string {
+	return strings.Join(topic(s.prefix(), e), "/")
+}
+
+func (s *MQTT) PublishEvent(e torrential.Event) error {
+	payload, err := s.encode(e)
+	if err != nil {
+		return err
+	}
+	token := s.client.Publish(s.Topic(e), s.QoS, false, payload)
+	token.Wait()
+	return token.Error()
+}
@@ -0,0 +1,41 @@
+package sink
+
+import (
+	"strings"
+
+	"github.com/joelanford/torrential"
+	nats "github.com/nats-io/nats.go"
+)
+
+// NATS publishes events to a NATS server. Each event is published to the
+// subject <prefix>.<event type>.<info hash>, so subscribers can filter with
+// wildcards, e.g. torrential.downloadDone.>. Events without a torrent are
+// published to <prefix>.<event type>.
+type NATS struct {
+	Options
+
+	conn *nats.Conn
+}
+
+var _ torrential.EventSink = &NATS{}
+
+// NewNATS returns a NATS sink that publishes on the given connection.
+func NewNATS(conn *nats.Conn, options Options) *NATS {
+	return &NATS{
+		Options: options,
+		conn:    conn,
+	}
+}
+
+// Subject returns the subject that the event is published to.
+func (s *NATS) Subject(e torrential.Event) string {
+	return strings.Join(topic(s.prefix(), e), ".")
+}
+
+func (s *NATS) PublishEvent(e torrential.Event) error {
+	payload, err := s.encode(e)
+	if err != nil {
+		return err
+	}
+	return s.conn.Publish(s.Subject(e), payload)
+}
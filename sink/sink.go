@@ -0,0 +1,46 @@
+// Package sink contains torrential.EventSink implementations that publish
+// torrent events to message brokers.
+package sink
+
+import (
+	"github.com/joelanford/torrential"
+)
+
+// Options are the options shared by all sinks.
+type Options struct {
+	// TopicPrefix is prepended to the topic of each event. It defaults to
+	// "torrential".
+	TopicPrefix string
+
+	// Format is the encoding of published events. It defaults to
+	// torrential.FormatJSON.
+	Format torrential.EventFormat
+
+	// Source is the CloudEvents source attribute of published events. It is
+	// only used by the CloudEvents formats.
+	Source string
+}
+
+func (o Options) prefix() string {
+	if o.TopicPrefix == "" {
+		return "torrential"
+	}
+	return o.TopicPrefix
+}
+
+func (o Options) encode(e torrential.Event) ([]byte, error) {
+	source := o.Source
+	if source == "" {
+		source = "/torrential"
+	}
+	return torrential.EncodeEvent(e, o.Format, source)
+}
+
+// topic returns the parts of the topic of an event.
+func topic(prefix string, e torrential.Event) []string {
+	parts := []string{prefix, e.Type.String()}
+	if infoHash := e.InfoHash(); infoHash != "" {
+		parts = append(parts, infoHash)
+	}
+	return parts
+}
@@ -0,0 +1,128 @@
+package sink_test
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/joelanford/torrential"
+	"github.com/joelanford/torrential/sink"
+	nats "github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	c *torrent.Client
+)
+
+func TestMain(m *testing.M) {
+	var err error
+	c, err = torrent.NewClient(&torrent.Config{
+		DataDir: ".torrent-test-data",
+		NoDHT:   true,
+	})
+	if err != nil {
+		panic(err)
+	}
+	exitStatus := m.Run()
+
+	c.Close()
+	os.RemoveAll(".torrent-test-data")
+
+	os.Exit(exitStatus)
+}
+
+func testEvent(t *testing.T) torrential.Event {
+	tor, err := c.AddTorrentFromFile("../testdata/sample.torrent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return torrential.Event{Type: torrential.DownloadDone, Torrent: torrential.NewTorrent(tor)}
+}
+
+func TestTopics(t *testing.T) {
+	e := testEvent(t)
+	infoHash := e.Torrent.InfoHash
+
+	m := sink.NewMQTT(nil, sink.Options{})
+	assert.Equal(t, "torrential/downloadDone/"+infoHash, m.Topic(e))
+
+	n := sink.NewNATS(nil, sink.Options{TopicPrefix: "events"})
+	assert.Equal(t, "events.downloadDone."+infoHash, n.Subject(e))
+}
+
+// TestMQTT publishes to the broker at $TORRENTIAL_TEST_MQTT_URL, e.g.
+// tcp://localhost:1883.
+func TestMQTT(t *testing.T) {
+	broker := os.Getenv("TORRENTIAL_TEST_MQTT_URL")
+	if broker == "" {
+		t.Skip("TORRENTIAL_TEST_MQTT_URL is not set")
+	}
+	client := mqtt.NewClient(mqtt.NewClientOptions().AddBroker(broker))
+	if token := client.Connect(); token.Wait() && token.Error() != nil {
+		t.Fatal(token.Error())
+	}
+	defer client.Disconnect(250)
+
+	received := make(chan []byte, 1)
+	token := client.Subscribe("torrential/#", 1, func(_ mqtt.Client, msg mqtt.Message) {
+		received <- msg.Payload()
+	})
+	if token.Wait() && token.Error() != nil {
+		t.Fatal(token.Error())
+	}
+
+	s := sink.NewMQTT(client, sink.Options{})
+	s.QoS = 1
+	e := testEvent(t)
+	assert.Nil(t, s.PublishEvent(e))
+	assertReceived(t, e, received)
+}
+
+// TestNATS publishes to the server at $TORRENTIAL_TEST_NATS_URL, e.g.
+// nats://localhost:4222.
+func TestNATS(t *testing.T) {
+	url := os.Getenv("TORRENTIAL_TEST_NATS_URL")
+	if url == "" {
+		t.Skip("TORRENTIAL_TEST_NATS_URL is not set")
+	}
+	conn, err := nats.Connect(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	received := make(chan []byte, 1)
+	if _, err := conn.Subscribe("torrential.>", func(msg *nats.Msg) {
+		received <- msg.Data
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	s := sink.NewNATS(conn, sink.Options{})
+	e := testEvent(t)
+	assert.Nil(t, s.PublishEvent(e))
+	assertReceived(t, e, received)
+}
+
+func assertReceived(t *testing.T, e torrential.Event, received <-chan []byte) {
+	select {
+	case payload := <-received:
+		var result struct {
+			Event struct {
+				Type    string `json:"type"`
+				Torrent struct {
+					InfoHash string `json:"infoHash"`
+				} `json:"torrent"`
+			} `json:"event"`
+		}
+		assert.Nil(t, json.Unmarshal(payload, &result))
+		assert.Equal(t, e.Type.String(), result.Event.Type)
+		assert.Equal(t, e.Torrent.InfoHash, result.Event.Torrent.InfoHash)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for event")
+	}
+}
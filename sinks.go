@@ -0,0 +1,27 @@
+package torrential
+
+import (
+	"log"
+)
+
+// EventSink publishes torrent events to an external system, such as a message
+// broker. See the sink package for implementations.
+type EventSink interface {
+	PublishEvent(e Event) error
+}
+
+// runSink publishes all events from the service's MultiEventer to the sink.
+// Each sink has its own subscription, so a slow sink does not hold up the
+// others. It returns once the MultiEventer is closed.
+func (svc *Service) runSink(sink EventSink) {
+	defer svc.sinks.Done()
+	background := make(chan struct{})
+	for e := range svc.multiEventer.Events(background) {
+		if e.Type == Closed && svc.isClosing() {
+			continue
+		}
+		if err := sink.PublishEvent(e); err != nil {
+			log.Printf("error publishing %s event for torrent %s: %s", e.Type, e.InfoHash(), err)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package storage
+
+import (
+	"io"
+	"sync"
+
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/anacrolix/torrent/storage"
+	"github.com/pkg/errors"
+)
+
+// ErrMemoryLimit is returned by writes to Memory storage that would exceed
+// its limit.
+var ErrMemoryLimit = errors.New("memory storage limit reached")
+
+// Memory keeps torrent payload data in memory, for tests and ephemeral
+// deployments. Memory for a piece is allocated when the piece is first
+// written, and is released when its torrent is closed.
+type Memory struct {
+	limit int64
+	used  int64
+	mu    sync.Mutex
+}
+
+var _ storage.ClientImpl = &Memory{}
+
+// NewMemory returns Memory storage that holds at most limit bytes, or an
+// unlimited amount if limit is 0.
+func NewMemory(limit int64) *Memory {
+	return &Memory{limit: limit}
+}
+
+// Used returns the number of bytes allocated for pieces.
+func (s *Memory) Used() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.used
+}
+
+func (s *Memory) reserve(n int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.limit > 0 && s.used+n > s.limit {
+		return ErrMemoryLimit
+	}
+	s.used += n
+	return nil
+}
+
+func (s *Memory) release(n int64) {
+	s.mu.Lock()
+	s.used -= n
+	s.mu.Unlock()
+}
+
+func (s *Memory) OpenTorrent(info *metainfo.Info, infoHash metainfo.Hash) (storage.TorrentImpl, error) {
+	return &memoryTorrent{
+		s:        s,
+		pieces:   make(map[int][]byte),
+		complete: make(map[int]bool),
+	}, nil
+}
+
+func (s *Memory) Close() error {
+	return nil
+}
+
+type memoryTorrent struct {
+	s        *Memory
+	pieces   map[int][]byte
+	complete map[int]bool
+	mu       sync.RWMutex
+}
+
+func (t *memoryTorrent) Piece(p metainfo.Piece) storage.PieceImpl {
+	return &memoryPiece{t: t, p: p}
+}
+
+func (t *memoryTorrent) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i, data := range t.pieces {
+		t.s.release(int64(len(data)))
+		delete(t.pieces, i)
+	}
+	return nil
+}
+
+type memoryPiece struct {
+	t *memoryTorrent
+	p metainfo.Piece
+}
+
+func (p *memoryPiece) ReadAt(b []byte, off int64) (int, error) {
+	p.t.mu.RLock()
+	defer p.t.mu.RUnlock()
+	data, ok := p.t.pieces[p.p.Index()]
+	if !ok || off >= int64(len(data)) {
+		return 0, io.EOF
+	}
+	n := copy(b, data[off:])
+	if n < len(b) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (p *memoryPiece) WriteAt(b []byte, off int64) (int, error) {
+	if off+int64(len(b)) > p.p.Length() {
+		return 0, errors.New("write beyond end of piece")
+	}
+	p.t.mu.Lock()
+	defer p.t.mu.Unlock()
+	data, ok := p.t.pieces[p.p.Index()]
+	if !ok {
+		if err := p.t.s.reserve(p.p.Length()); err != nil {
+			return 0, err
+		}
+		data = make([]byte, p.p.Length())
+		p.t.pieces[p.p.Index()] = data
+	}
+	return copy(data[off:], b), nil
+}
+
+func (p *memoryPiece) MarkComplete() error {
+	p.t.mu.Lock()
+	defer p.t.mu.Unlock()
+	p.t.complete[p.p.Index()] = true
+	return nil
+}
+
+func (p *memoryPiece) MarkNotComplete() error {
+	p.t.mu.Lock()
+	defer p.t.mu.Unlock()
+	p.t.complete[p.p.Index()] = false
+	return nil
+}
+
+func (p *memoryPiece) Completion() storage.Completion {
+	p.t.mu.RLock()
+	defer p.t.mu.RUnlock()
+	return storage.Completion{Complete: p.t.complete[p.p.Index()], Ok: true}
+}
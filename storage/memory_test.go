@@ -0,0 +1,48 @@
+package storage_test
+
+import (
+	"testing"
+
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/joelanford/torrential/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemory(t *testing.T) {
+	s := storage.NewMemory(6)
+	info := &metainfo.Info{
+		Name:        "test",
+		PieceLength: 4,
+		Pieces:      make([]byte, 3*20),
+		Length:      10,
+	}
+	tor, err := s.OpenTorrent(info, metainfo.Hash{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p0 := tor.Piece(info.Piece(0))
+	_, err = p0.WriteAt([]byte("abcd"), 0)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), s.Used())
+	assert.False(t, p0.Completion().Complete)
+	assert.NoError(t, p0.MarkComplete())
+	assert.True(t, p0.Completion().Complete)
+
+	buf := make([]byte, 2)
+	_, err = p0.ReadAt(buf, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, "cd", string(buf))
+
+	// A second full piece doesn't fit in the limit.
+	_, err = tor.Piece(info.Piece(1)).WriteAt([]byte("efgh"), 0)
+	assert.Equal(t, storage.ErrMemoryLimit, err)
+
+	// The last piece is shorter and does.
+	_, err = tor.Piece(info.Piece(2)).WriteAt([]byte("ij"), 0)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(6), s.Used())
+
+	assert.NoError(t, tor.Close())
+	assert.Equal(t, int64(0), s.Used())
+}
@@ -0,0 +1,440 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/anacrolix/torrent/storage"
+	minio "github.com/minio/minio-go"
+	"github.com/pkg/errors"
+)
+
+// DefaultMinioBufferLimit is the buffer limit of Minio storage whose
+// BufferLimit is 0.
+const DefaultMinioBufferLimit = 256 << 20
+
+// ErrBufferLimit is returned by writes to Minio storage that would start
+// buffering a piece beyond its buffer limit. The piece can be written again
+// once other pieces are complete.
+var ErrBufferLimit = errors.New("storage buffer limit reached")
+
+// Minio stores torrent payload data in a MinIO or S3 bucket, so that
+// torrential does not need a local data directory. Pieces are kept in memory
+// while they are downloaded, and are uploaded as objects named
+// <prefix>/<info hash>/pieces/<index> once they are verified. Reads of
+// complete pieces, e.g. for seeding, are served from the bucket.
+type Minio struct {
+	client *minio.Client
+	bucket string
+	region string
+
+	// Prefix is prepended to all object names.
+	Prefix string
+
+	// Files makes the storage also write each file of a torrent as an object
+	// named <prefix>/<info hash>/files/<path> once all pieces are complete.
+	// The files are written in the background; Wait waits for them. Torrents
+	// with names or paths like ".." are rejected.
+	Files bool
+
+	// BufferLimit is the maximum number of bytes of pieces that are kept in
+	// memory while they are downloaded, across all torrents. If 0,
+	// DefaultMinioBufferLimit is used.
+	BufferLimit int64
+
+	buffered int64
+	writing  map[metainfo.Hash]chan struct{}
+	writes   sync.WaitGroup
+	errs     []error
+	mu       sync.Mutex
+}
+
+var (
+	_ storage.ClientImpl = &Minio{}
+	_ Deleter            = &Minio{}
+	_ Waiter             = &Minio{}
+)
+
+func NewMinio(client *minio.Client, bucket string) *Minio {
+	return NewMinioWithRegion(client, bucket, "")
+}
+
+func NewMinioWithRegion(client *minio.Client, bucket, region string) *Minio {
+	return &Minio{
+		client:  client,
+		bucket:  bucket,
+		region:  region,
+		writing: make(map[metainfo.Hash]chan struct{}),
+	}
+}
+
+func (s *Minio) reserve(n int64) error {
+	limit := s.BufferLimit
+	if limit == 0 {
+		limit = DefaultMinioBufferLimit
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.buffered+n > limit {
+		return ErrBufferLimit
+	}
+	s.buffered += n
+	return nil
+}
+
+func (s *Minio) release(n int64) {
+	s.mu.Lock()
+	s.buffered -= n
+	s.mu.Unlock()
+}
+
+// startFiles writes the files of a torrent in the background, after the files
+// of the torrent that are already being written.
+func (s *Minio) startFiles(t *minioTorrent) {
+	done := make(chan struct{})
+	s.mu.Lock()
+	prev := s.writing[t.infoHash]
+	s.writing[t.infoHash] = done
+	s.mu.Unlock()
+
+	s.writes.Add(1)
+	go func() {
+		defer s.writes.Done()
+		if prev != nil {
+			<-prev
+		}
+		err := t.writeFiles()
+		s.mu.Lock()
+		if err != nil {
+			log.Print(err)
+			s.errs = append(s.errs, err)
+		}
+		if s.writing[t.infoHash] == done {
+			delete(s.writing, t.infoHash)
+		}
+		s.mu.Unlock()
+		close(done)
+	}()
+}
+
+// Wait waits until the files that are being written in the background have
+// been written, or ctx is done. It returns the first error of the writes that
+// failed since the last call.
+func (s *Minio) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		s.writes.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	s.mu.Lock()
+	errs := s.errs
+	s.errs = nil
+	s.mu.Unlock()
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	}
+	return errors.Wrapf(errs[0], "%d file writes failed", len(errs))
+}
+
+// DeleteTorrent deletes the pieces and files of a torrent from the bucket,
+// once the files that are being written have been written.
+func (s *Minio) DeleteTorrent(infoHash metainfo.Hash) error {
+	s.mu.Lock()
+	writing := s.writing[infoHash]
+	s.mu.Unlock()
+	if writing != nil {
+		<-writing
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	prefix := path.Join(s.Prefix, infoHash.HexString()) + "/"
+	for obj := range s.client.ListObjectsV2(s.bucket, prefix, true, done) {
+		if obj.Err != nil {
+			return errors.Wrap(obj.Err, "could not list torrent objects")
+		}
+		if err := s.client.RemoveObject(s.bucket, obj.Key); err != nil {
+			return errors.Wrapf(err, "could not delete %s", obj.Key)
+		}
+	}
+	return nil
+}
+
+// OpenTorrent opens the storage of a torrent. If Files is set, torrents whose
+// name or file paths can't be used as object names are rejected.
+func (s *Minio) OpenTorrent(info *metainfo.Info, infoHash metainfo.Hash) (storage.TorrentImpl, error) {
+	if s.Files {
+		for _, fi := range info.UpvertedFiles() {
+			if _, err := fileName(s.Prefix, info, infoHash, fi); err != nil {
+				return nil, err
+			}
+		}
+	}
+	exists, err := s.client.BucketExists(s.bucket)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		if err := s.client.MakeBucket(s.bucket, s.region); err != nil {
+			return nil, err
+		}
+	}
+	return &minioTorrent{
+		s:        s,
+		info:     info,
+		infoHash: infoHash,
+		buffers:  make(map[int][]byte),
+		complete: make(map[int]bool),
+	}, nil
+}
+
+func (s *Minio) Close() error {
+	return nil
+}
+
+type minioTorrent struct {
+	s        *Minio
+	info     *metainfo.Info
+	infoHash metainfo.Hash
+
+	// buffers holds the data of pieces that are being downloaded, and
+	// complete caches the completion of pieces, so that the bucket is only
+	// asked once per piece.
+	buffers  map[int][]byte
+	complete map[int]bool
+	files    bool
+	mu       sync.Mutex
+}
+
+func (t *minioTorrent) Piece(p metainfo.Piece) storage.PieceImpl {
+	return &minioPiece{t: t, p: p}
+}
+
+func (t *minioTorrent) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, buf := range t.buffers {
+		t.s.release(int64(len(buf)))
+	}
+	t.buffers = make(map[int][]byte)
+	return nil
+}
+
+func (t *minioTorrent) pieceName(index int) string {
+	return path.Join(t.s.Prefix, t.infoHash.HexString(), "pieces", fmt.Sprint(index))
+}
+
+// fileName returns the object name of a file of a torrent. The name and path
+// come from the torrent, so parts that would resolve outside of the torrent's
+// files, like "..", are rejected.
+func fileName(prefix string, info *metainfo.Info, infoHash metainfo.Hash, fi metainfo.FileInfo) (string, error) {
+	parts := append([]string{info.Name}, fi.Path...)
+	for _, part := range parts {
+		if part == "" || part == "." || part == ".." || strings.Contains(part, "/") {
+			return "", errors.Errorf("invalid file path %q in torrent %s", strings.Join(parts, "/"), infoHash.HexString())
+		}
+	}
+	return path.Join(append([]string{prefix, infoHash.HexString(), "files"}, parts...)...), nil
+}
+
+// claimFiles reports whether every piece is complete and the files have not
+// been written yet. It only returns true once.
+func (t *minioTorrent) claimFiles() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.files {
+		return false
+	}
+	for i := 0; i < t.info.NumPieces(); i++ {
+		if !t.complete[i] {
+			return false
+		}
+	}
+	t.files = true
+	return true
+}
+
+// writeFiles writes each file of the torrent as an object, reading the data
+// from the piece objects. Files that fail are skipped, and the first error is
+// returned.
+func (t *minioTorrent) writeFiles() error {
+	var (
+		offset int64
+		first  error
+	)
+	for _, fi := range t.info.UpvertedFiles() {
+		name, err := fileName(t.s.Prefix, t.info, t.infoHash, fi)
+		if err == nil {
+			r := io.NewSectionReader(t, offset, fi.Length)
+			_, err = t.s.client.PutObject(t.s.bucket, name, r, fi.Length, minio.PutObjectOptions{})
+			err = errors.Wrapf(err, "could not write file %s of torrent %s", path.Join(fi.Path...), t.infoHash.HexString())
+		}
+		if err != nil && first == nil {
+			first = err
+		}
+		offset += fi.Length
+	}
+	return first
+}
+
+// ReadAt reads torrent data from the complete pieces.
+func (t *minioTorrent) ReadAt(b []byte, off int64) (int, error) {
+	n := 0
+	for n < len(b) {
+		index := int((off + int64(n)) / t.info.PieceLength)
+		if index >= t.info.NumPieces() {
+			return n, io.EOF
+		}
+		p := &minioPiece{t: t, p: t.info.Piece(index)}
+		pieceOff := off + int64(n) - p.p.Offset()
+		end := len(b)
+		if remaining := p.p.Length() - pieceOff; int64(end-n) > remaining {
+			end = n + int(remaining)
+		}
+		m, err := p.ReadAt(b[n:end], pieceOff)
+		n += m
+		if err != nil && err != io.EOF {
+			return n, err
+		}
+		if m == 0 {
+			return n, io.ErrUnexpectedEOF
+		}
+	}
+	return n, nil
+}
+
+type minioPiece struct {
+	t *minioTorrent
+	p metainfo.Piece
+}
+
+func (p *minioPiece) name() string {
+	return p.t.pieceName(p.p.Index())
+}
+
+func (p *minioPiece) ReadAt(b []byte, off int64) (int, error) {
+	if off >= p.p.Length() {
+		return 0, io.EOF
+	}
+	if int64(len(b)) > p.p.Length()-off {
+		b = b[:p.p.Length()-off]
+	}
+
+	p.t.mu.Lock()
+	buf, buffered := p.t.buffers[p.p.Index()]
+	if buffered {
+		n := copy(b, buf[off:])
+		p.t.mu.Unlock()
+		return n, nil
+	}
+	p.t.mu.Unlock()
+
+	if !p.Completion().Complete {
+		return 0, io.ErrUnexpectedEOF
+	}
+	opts := minio.GetObjectOptions{}
+	if err := opts.SetRange(off, off+int64(len(b))-1); err != nil {
+		return 0, err
+	}
+	obj, err := p.t.s.client.GetObject(p.t.s.bucket, p.name(), opts)
+	if err != nil {
+		return 0, err
+	}
+	defer obj.Close()
+	return io.ReadFull(obj, b)
+}
+
+func (p *minioPiece) WriteAt(b []byte, off int64) (int, error) {
+	if off+int64(len(b)) > p.p.Length() {
+		return 0, errors.New("write beyond end of piece")
+	}
+	p.t.mu.Lock()
+	defer p.t.mu.Unlock()
+	buf, ok := p.t.buffers[p.p.Index()]
+	if !ok {
+		if err := p.t.s.reserve(p.p.Length()); err != nil {
+			return 0, err
+		}
+		buf = make([]byte, p.p.Length())
+		p.t.buffers[p.p.Index()] = buf
+	}
+	return copy(buf[off:], b), nil
+}
+
+func (p *minioPiece) MarkComplete() error {
+	p.t.mu.Lock()
+	buf, ok := p.t.buffers[p.p.Index()]
+	p.t.mu.Unlock()
+	if !ok {
+		return errors.Errorf("piece %d has no data", p.p.Index())
+	}
+
+	if _, err := p.t.s.client.PutObject(p.t.s.bucket, p.name(), bytes.NewReader(buf), int64(len(buf)), minio.PutObjectOptions{}); err != nil {
+		return errors.Wrapf(err, "could not upload piece %d", p.p.Index())
+	}
+
+	p.t.mu.Lock()
+	if _, ok := p.t.buffers[p.p.Index()]; ok {
+		delete(p.t.buffers, p.p.Index())
+		p.t.s.release(int64(len(buf)))
+	}
+	p.t.complete[p.p.Index()] = true
+	p.t.mu.Unlock()
+
+	if p.t.s.Files && p.t.claimFiles() {
+		p.t.s.startFiles(p.t)
+	}
+	return nil
+}
+
+func (p *minioPiece) MarkNotComplete() error {
+	p.t.mu.Lock()
+	p.t.complete[p.p.Index()] = false
+	p.t.mu.Unlock()
+	err := p.t.s.client.RemoveObject(p.t.s.bucket, p.name())
+	if err != nil && minio.ToErrorResponse(err).Code != "NoSuchKey" {
+		return err
+	}
+	return nil
+}
+
+func (p *minioPiece) Completion() storage.Completion {
+	p.t.mu.Lock()
+	complete, known := p.t.complete[p.p.Index()]
+	p.t.mu.Unlock()
+	if known {
+		return storage.Completion{Complete: complete, Ok: true}
+	}
+
+	info, err := p.t.s.client.StatObject(p.t.s.bucket, p.name(), minio.StatObjectOptions{})
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			complete = false
+		} else {
+			return storage.Completion{}
+		}
+	} else {
+		complete = info.Size == p.p.Length()
+	}
+
+	p.t.mu.Lock()
+	p.t.complete[p.p.Index()] = complete
+	p.t.mu.Unlock()
+	return storage.Completion{Complete: complete, Ok: true}
+}
@@ -0,0 +1,24 @@
+// Package storage contains torrent payload storage implementations for use as
+// torrent.Config.DefaultStorage.
+package storage
+
+import (
+	"context"
+
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// Deleter is implemented by storage that keeps torrent data outside of the
+// data directory. Service.Drop calls DeleteTorrent to delete the data of a
+// dropped torrent instead of deleting its files.
+type Deleter interface {
+	DeleteTorrent(infoHash metainfo.Hash) error
+}
+
+// Waiter is implemented by storage that writes data in the background.
+// Service.Close calls Wait before it returns.
+type Waiter interface {
+	// Wait waits until the data that is being written has been written, or
+	// ctx is done, and returns the errors of writes that failed.
+	Wait(ctx context.Context) error
+}
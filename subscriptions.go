@@ -0,0 +1,329 @@
+package torrential
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"regexp"
+	"sort"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+	uuid "github.com/satori/go.uuid"
+)
+
+// Webhook is a subscription that invokes a URL for torrent events.
+type Webhook struct {
+	ID      string            `json:"id"`
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers,omitempty"`
+
+	// EventTypes limits the webhook to the given event types. If empty, the
+	// webhook is invoked for all events.
+	EventTypes []EventType `json:"eventTypes,omitempty"`
+
+	// Labels limits the webhook to torrents that have at least one of the
+	// given labels. If empty, the webhook is invoked for all torrents.
+	Labels []string `json:"labels,omitempty"`
+
+	// Template is an optional text/template that renders the request body
+	// from a WebhookTemplateData. If empty, the body is the event encoded in
+	// the webhook's format.
+	Template string `json:"template,omitempty"`
+
+	// Format is the encoding of the event when no template is set. It
+	// defaults to Config.EventFormat.
+	Format EventFormat `json:"format,omitempty"`
+
+	// ContentType is the content type of the request body. It defaults to
+	// application/json.
+	ContentType string `json:"contentType,omitempty"`
+}
+
+// WebhookTemplateData is the data that webhook templates are executed with.
+// In addition to the event fields, templates can use the json function to
+// encode a value, e.g. {{json .Torrent.Name}}.
+type WebhookTemplateData struct {
+	Event
+	Labels []string
+}
+
+var templateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+}
+
+// webhookIDPattern matches valid webhook IDs. IDs are used as file names by
+// WebhookDirectory, so they are limited to characters that are safe in paths.
+var webhookIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// validateWebhookID returns a parseErr if the ID can't be used for a webhook.
+// "deadletters" is reserved, since it would clash with the dead letter
+// routes of Handler.
+func validateWebhookID(id string) error {
+	if !webhookIDPattern.MatchString(id) {
+		return parseErr{errors.Errorf("invalid webhook ID %q: must be 1 to 64 letters, digits, dashes or underscores", id)}
+	}
+	if id == "deadletters" {
+		return parseErr{errors.Errorf("webhook ID %q is reserved", id)}
+	}
+	return nil
+}
+
+type subscription struct {
+	Webhook
+	tmpl *template.Template
+}
+
+func newSubscription(w Webhook) (*subscription, error) {
+	if err := validateWebhookID(w.ID); err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(w.URL)
+	if err != nil {
+		return nil, errors.Wrap(parseErr{err}, "invalid webhook URL")
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, parseErr{errors.Errorf("invalid webhook URL %q", w.URL)}
+	}
+	if w.Format != "" {
+		if _, err := ParseEventFormat(string(w.Format)); err != nil {
+			return nil, errors.Wrap(err, "invalid webhook format")
+		}
+	}
+	s := &subscription{Webhook: w}
+	if w.Template != "" {
+		s.tmpl, err = template.New(w.ID).Funcs(templateFuncs).Parse(w.Template)
+		if err != nil {
+			return nil, errors.Wrap(parseErr{err}, "invalid webhook template")
+		}
+	}
+	return s, nil
+}
+
+func (s *subscription) matches(e Event, labels []string) bool {
+	if len(s.EventTypes) > 0 {
+		found := false
+		for _, t := range s.EventTypes {
+			if t == e.Type {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(s.Labels) > 0 {
+		for _, want := range s.Labels {
+			for _, have := range labels {
+				if want == have {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// render builds a delivery of the event, which must already have its ID and
+// creation time set, using the webhook's template or format.
+func (d *webhookDispatcher) render(s *subscription, e Event, labels []string, del *WebhookDelivery) error {
+	del.Headers = make(map[string]string, len(s.Headers))
+	for k, v := range s.Headers {
+		del.Headers[k] = v
+	}
+	del.ContentType = s.ContentType
+
+	if s.tmpl != nil {
+		var buf bytes.Buffer
+		if err := s.tmpl.Execute(&buf, WebhookTemplateData{Event: e, Labels: labels}); err != nil {
+			return err
+		}
+		del.Body = buf.String()
+		return nil
+	}
+
+	format := s.Format
+	if format == "" {
+		format = d.format
+	}
+	if !format.isCloudEvents() {
+		data, err := json.Marshal(eventResult{e})
+		del.Body = string(data)
+		return err
+	}
+
+	// The delivery ID doubles as the CloudEvents ID, so that it stays the same
+	// across retries and receivers can use it to detect duplicates.
+	ce, err := NewCloudEvent(e, d.source, del.ID, del.Created)
+	if err != nil {
+		return err
+	}
+	if format == FormatCloudEventsBinary {
+		for k, v := range ce.binaryHeaders() {
+			del.Headers[k] = v[0]
+		}
+		del.Body = string(ce.Data)
+		if del.ContentType == "" {
+			del.ContentType = ce.DataContentType
+		}
+		return nil
+	}
+	data, err := json.Marshal(ce)
+	if err != nil {
+		return err
+	}
+	del.Body = string(data)
+	if del.ContentType == "" {
+		del.ContentType = cloudEventsMediaType
+	}
+	return nil
+}
+
+// loadWebhooks loads the stored webhooks, followed by the configured ones.
+// Configured webhooks replace stored webhooks with the same ID.
+func (d *webhookDispatcher) loadWebhooks(configured []Webhook) error {
+	var webhooks []Webhook
+	if d.store != nil {
+		stored, err := d.store.LoadWebhooks()
+		if err != nil {
+			return err
+		}
+		webhooks = append(webhooks, stored...)
+	}
+	for i, w := range configured {
+		if w.ID == "" {
+			w.ID = fmt.Sprintf("config-%d", i)
+		}
+		webhooks = append(webhooks, w)
+	}
+	for _, w := range webhooks {
+		s, err := newSubscription(w)
+		if err != nil {
+			return errors.Wrapf(err, "could not load webhook %s", w.ID)
+		}
+		d.hooks[w.ID] = s
+	}
+	return nil
+}
+
+// dispatch queues a delivery of the event for each matching webhook.
+func (d *webhookDispatcher) dispatch(e Event, labels []string) {
+	d.hooksMu.RLock()
+	var matched []*subscription
+	for _, s := range d.hooks {
+		if s.matches(e, labels) {
+			matched = append(matched, s)
+		}
+	}
+	d.hooksMu.RUnlock()
+
+	infoHash := e.InfoHash()
+	for _, s := range matched {
+		del := &WebhookDelivery{
+			ID:        uuid.NewV4().String(),
+			WebhookID: s.ID,
+			URL:       s.URL,
+			InfoHash:  infoHash,
+			EventType: e.Type.String(),
+			Created:   time.Now(),
+		}
+		if err := d.render(s, e, labels, del); err != nil {
+			log.Printf("error rendering webhook %s for %s event for torrent %s: %s", s.ID, e.Type, infoHash, err)
+			continue
+		}
+		if err := d.enqueue(del); err != nil {
+			log.Printf("error queueing webhook %s for %s event for torrent %s: %s", s.ID, e.Type, infoHash, err)
+		}
+	}
+}
+
+func (d *webhookDispatcher) webhooks() []Webhook {
+	d.hooksMu.RLock()
+	defer d.hooksMu.RUnlock()
+	webhooks := make([]Webhook, 0, len(d.hooks))
+	for _, s := range d.hooks {
+		webhooks = append(webhooks, s.Webhook)
+	}
+	sort.Slice(webhooks, func(i, j int) bool {
+		return webhooks[i].ID < webhooks[j].ID
+	})
+	return webhooks
+}
+
+func (d *webhookDispatcher) webhook(id string) (*Webhook, error) {
+	d.hooksMu.RLock()
+	defer d.hooksMu.RUnlock()
+	s, ok := d.hooks[id]
+	if !ok {
+		return nil, notFoundErr{errors.New("webhook not found")}
+	}
+	w := s.Webhook
+	return &w, nil
+}
+
+func (d *webhookDispatcher) addWebhook(w Webhook) (*Webhook, error) {
+	if w.ID == "" {
+		w.ID = uuid.NewV4().String()
+	}
+	s, err := newSubscription(w)
+	if err != nil {
+		return nil, err
+	}
+
+	d.hooksMu.Lock()
+	defer d.hooksMu.Unlock()
+	if _, ok := d.hooks[w.ID]; ok {
+		return nil, existsErr{errors.New("webhook already exists")}
+	}
+	if d.store != nil {
+		if err := d.store.SaveWebhook(w); err != nil {
+			return nil, errors.Wrap(webhookErr{err}, "could not save webhook")
+		}
+	}
+	d.hooks[w.ID] = s
+	return &w, nil
+}
+
+func (d *webhookDispatcher) updateWebhook(id string, w Webhook) (*Webhook, error) {
+	w.ID = id
+	s, err := newSubscription(w)
+	if err != nil {
+		return nil, err
+	}
+
+	d.hooksMu.Lock()
+	defer d.hooksMu.Unlock()
+	if _, ok := d.hooks[id]; !ok {
+		return nil, notFoundErr{errors.New("webhook not found")}
+	}
+	if d.store != nil {
+		if err := d.store.SaveWebhook(w); err != nil {
+			return nil, errors.Wrap(webhookErr{err}, "could not save webhook")
+		}
+	}
+	d.hooks[id] = s
+	return &w, nil
+}
+
+func (d *webhookDispatcher) deleteWebhook(id string) error {
+	d.hooksMu.Lock()
+	defer d.hooksMu.Unlock()
+	if _, ok := d.hooks[id]; !ok {
+		return notFoundErr{errors.New("webhook not found")}
+	}
+	if d.store != nil {
+		if err := d.store.DeleteWebhook(id); err != nil {
+			return errors.Wrap(webhookErr{err}, "could not delete webhook")
+		}
+	}
+	delete(d.hooks, id)
+	return nil
+}
@@ -4,51 +4,64 @@ import (
 	"encoding/json"
 
 	"github.com/anacrolix/torrent"
+	"github.com/pkg/errors"
+
+	"github.com/joelanford/torrential/cache"
 )
 
+// Torrent is a snapshot of a torrent of a TorrentService. It is a plain value,
+// so TorrentServices other than Service can return torrents, and clients can
+// decode them from the JSON that Handler returns.
 type Torrent struct {
-	*torrent.Torrent
+	BytesCompleted int    `json:"bytesCompleted"` // Number of bytes completed
+	BytesMissing   int    `json:"bytesMissing"`   // Number of bytes missing
+	Files          []File `json:"files"`          // Files contained in the torrent
+	InfoHash       string `json:"infoHash"`       // Torrent info hash
+	Length         int    `json:"length"`         // Total number of bytes in torrent
+	MagnetLink     string `json:"magnetLink"`     // Torrent magnet link
+	Name           string `json:"name"`           // Torrent name
+	NumPieces      int    `json:"numPieces"`      // Total number of pieces in torrent
+	Seeding        bool   `json:"seeding"`        // Whether torrent is currently seeding
+	Stats          Stats  `json:"stats"`          // Torrent stats
+	HasInfo        bool   `json:"hasInfo"`        // Whether the torrent info has been received
+
+	// Paused and Priority are the download state of the torrent. They are
+	// only set on torrents returned by a TorrentService, not on the torrents
+	// of events.
+	Paused   bool     `json:"paused"`             // Whether the torrent is paused
+	Priority Priority `json:"priority,omitempty"` // Download priority of the torrent
 }
 
+// MarshalJSON encodes the zero Torrent, which events that are not about a
+// torrent have, as null.
 func (t Torrent) MarshalJSON() ([]byte, error) {
-	if t.Torrent == nil {
+	if t.InfoHash == "" {
 		return []byte("null"), nil
 	}
+	type plain Torrent
+	return json.Marshal(plain(t))
+}
+
+// NewTorrent returns a snapshot of a torrent of the client.
+func NewTorrent(t *torrent.Torrent) Torrent {
 	mi := t.Metainfo()
-	torrent := struct {
-		BytesCompleted int    `json:"bytesCompleted"` // Number of bytes completed
-		BytesMissing   int    `json:"bytesMissing"`   // Number of bytes missing
-		Files          []File `json:"files"`          // Files contained in the torrent
-		InfoHash       string `json:"infoHash"`       // Torrent info hash
-		Length         int    `json:"length"`         // Total number of bytes in torrent
-		MagnetLink     string `json:"magnetLink"`     // Torrent magnet link
-		Name           string `json:"name"`           // Torrent name
-		NumPieces      int    `json:"numPieces"`      // Total number of pieces in torrent
-		Seeding        bool   `json:"seeding"`        // Whether torrent is currently seeding
-		Stats          stats  `json:"stats"`          // Torrent stats
-		HasInfo        bool   `json:"hasInfo"`        // Whether the torrent info has been received)
-	}{
+	tor := Torrent{
 		BytesCompleted: int(t.BytesCompleted()),
-		BytesMissing:   0,
 		Files:          make([]File, 0),
-		InfoHash:       t.InfoHash().String(),
-		Length:         0,
+		InfoHash:       t.InfoHash().HexString(),
 		MagnetLink:     mi.Magnet(t.Name(), t.InfoHash()).String(),
 		Name:           t.Name(),
-		NumPieces:      0,
 		Seeding:        t.Seeding(),
-		Stats:          stats{},
-		HasInfo:        false,
 	}
 	select {
 	case <-t.GotInfo():
-		torrent.BytesMissing = int(t.BytesMissing())
-		torrent.Length = int(t.Length())
-		torrent.NumPieces = t.NumPieces()
-		torrent.HasInfo = true
+		tor.BytesMissing = int(t.BytesMissing())
+		tor.Length = int(t.Length())
+		tor.NumPieces = t.NumPieces()
+		tor.HasInfo = true
 
 		s := t.Stats()
-		torrent.Stats = stats{
+		tor.Stats = Stats{
 			BytesRead:        int(s.BytesRead),
 			BytesWritten:     int(s.BytesWritten),
 			ChunksRead:       int(s.ChunksRead),
@@ -62,36 +75,33 @@ func (t Torrent) MarshalJSON() ([]byte, error) {
 		}
 		files := t.Files()
 		for i := range files {
-			torrent.Files = append(torrent.Files, File{&files[i]})
+			tor.Files = append(tor.Files, NewFile(&files[i]))
 		}
-
 	default:
 	}
-	return json.Marshal(torrent)
+	return tor
 }
 
+// File is a file of a Torrent.
 type File struct {
-	*torrent.File
+	DisplayPath string `json:"displayPath"`
+	Length      int    `json:"length"`
+	Offset      int    `json:"offset"`
+	Path        string `json:"path"`
 }
 
-func (f File) MarshalJSON() ([]byte, error) {
-	if f.File == nil {
-		return []byte("null"), nil
-	}
-	return json.Marshal(struct {
-		DisplayPath string `json:"displayPath"`
-		Length      int    `json:"length"`
-		Offset      int    `json:"offset"`
-		Path        string `json:"path"`
-	}{
+// NewFile returns a snapshot of a file of an anacrolix/torrent torrent.
+func NewFile(f *torrent.File) File {
+	return File{
 		Path:        f.Path(),
 		DisplayPath: f.DisplayPath(),
 		Length:      int(f.Length()),
 		Offset:      int(f.Offset()),
-	})
+	}
 }
 
-type stats struct {
+// Stats are the transfer statistics of a Torrent.
+type Stats struct {
 	ActivePeers      int `json:"activePeers"`
 	BytesRead        int `json:"bytesRead"`
 	BytesWritten     int `json:"bytesWritten"`
@@ -113,6 +123,15 @@ type Event struct {
 	Torrent Torrent   `json:"torrent"`
 	File    *File     `json:"file,omitempty"`
 	Piece   *int      `json:"piece,omitempty"`
+
+	// Report is the cache load report, for CacheLoaded events.
+	Report *cache.LoadReport `json:"report,omitempty"`
+}
+
+// InfoHash returns the info hash of the event's torrent, or an empty string
+// for events that are not about a torrent, such as CacheLoaded.
+func (e Event) InfoHash() string {
+	return e.Torrent.InfoHash
 }
 
 type EventType int
@@ -125,6 +144,14 @@ const (
 	DownloadDone
 	SeedingDone
 	Closed
+
+	// CacheLoaded is sent once when the service starts, after the cache has
+	// been loaded. It has no torrent.
+	CacheLoaded
+
+	// ServiceClosed is the last event sent by a service, when it is closed.
+	// It has no torrent.
+	ServiceClosed
 )
 
 func (t EventType) String() string {
@@ -143,6 +170,10 @@ func (t EventType) String() string {
 		return "seedingDone"
 	case Closed:
 		return "closed"
+	case CacheLoaded:
+		return "cacheLoaded"
+	case ServiceClosed:
+		return "serviceClosed"
 	default:
 		return "unknown"
 	}
@@ -152,6 +183,30 @@ func (t EventType) MarshalJSON() (data []byte, err error) {
 	return []byte("\"" + t.String() + "\""), nil
 }
 
+func (t *EventType) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err != nil {
+		return err
+	}
+	et, err := ParseEventType(name)
+	if err != nil {
+		return err
+	}
+	*t = et
+	return nil
+}
+
+// ParseEventType returns the EventType with the given name, as returned by
+// EventType.String.
+func ParseEventType(name string) (EventType, error) {
+	for t := Added; t <= ServiceClosed; t++ {
+		if t.String() == name {
+			return t, nil
+		}
+	}
+	return 0, errors.Errorf("unknown event type %q", name)
+}
+
 type torrentResult struct {
 	Torrent *Torrent `json:"torrent"`
 }
@@ -164,6 +219,43 @@ type eventResult struct {
 	Event Event `json:"event"`
 }
 
+type labelsResult struct {
+	Labels []string `json:"labels"`
+}
+
+type priorityResult struct {
+	Priority Priority `json:"priority"`
+}
+
+type webhookResult struct {
+	Webhook *Webhook `json:"webhook"`
+}
+
+type webhooksResult struct {
+	Webhooks []Webhook `json:"webhooks"`
+}
+
+type execResultsResult struct {
+	Results []ExecResult `json:"results"`
+}
+
+type deliveryResult struct {
+	Delivery *WebhookDelivery `json:"delivery"`
+}
+
+type deliveriesResult struct {
+	Deliveries []WebhookDelivery `json:"deliveries"`
+}
+
+type cacheReportResult struct {
+	Report *cache.LoadReport `json:"report"`
+}
+
+type leasesResult struct {
+	Owner  string        `json:"owner"`
+	Leases []cache.Lease `json:"leases"`
+}
+
 type errorResult struct {
 	Error string `json:"error"`
 }
@@ -42,23 +42,23 @@ func TestTorrentMarshalJSON(t *testing.T) {
 	assert.NoError(t, err)
 	defer tor.Drop()
 
-	data, err = json.Marshal(torrential.Torrent{Torrent: tor})
+	data, err = json.Marshal(torrential.NewTorrent(tor))
 	assert.NoError(t, err)
-	assert.JSONEq(t, `{"bytesCompleted":0,"bytesMissing":20,"files":[{"displayPath":"sample.txt","length":20,"offset":0,"path":"sample.txt"}],"infoHash":"d0d14c926e6e99761a2fdcff27b403d96376eff6","length":20,"magnetLink":"magnet:?xt=urn:btih:d0d14c926e6e99761a2fdcff27b403d96376eff6\u0026dn=sample.txt\u0026tr=udp%3A%2F%2Ftracker.openbittorrent.com%3A80","name":"sample.txt","numPieces":1,"seeding":false,"stats":{"activePeers":0,"bytesRead":0,"bytesWritten":0,"chunksRead":0,"chunksWritten":0,"dataBytesRead":0,"dataBytesWritten":0,"halfOpenPeers":0,"pendingPeers":0,"totalPeers":0},"hasInfo":true}`, string(data))
+	assert.JSONEq(t, `{"bytesCompleted":0,"bytesMissing":20,"files":[{"displayPath":"sample.txt","length":20,"offset":0,"path":"sample.txt"}],"infoHash":"d0d14c926e6e99761a2fdcff27b403d96376eff6","length":20,"magnetLink":"magnet:?xt=urn:btih:d0d14c926e6e99761a2fdcff27b403d96376eff6\u0026dn=sample.txt\u0026tr=udp%3A%2F%2Ftracker.openbittorrent.com%3A80","name":"sample.txt","numPieces":1,"seeding":false,"stats":{"activePeers":0,"bytesRead":0,"bytesWritten":0,"chunksRead":0,"chunksWritten":0,"dataBytesRead":0,"dataBytesWritten":0,"halfOpenPeers":0,"pendingPeers":0,"totalPeers":0},"hasInfo":true,"paused":false}`, string(data))
 }
 
 func TestFileMarshalJSON(t *testing.T) {
-	// Test empty File
+	// Test empty File, which is a plain value now
 	data, err := json.Marshal(torrential.File{})
 	assert.NoError(t, err)
-	assert.JSONEq(t, `null`, string(data))
+	assert.JSONEq(t, `{"displayPath":"","length":0,"offset":0,"path":""}`, string(data))
 
 	// Test non-empty File
 	tor, err := c.AddTorrentFromFile("testdata/sample.torrent")
 	assert.NoError(t, err)
 	defer tor.Drop()
 
-	data, err = json.Marshal(torrential.File{File: &tor.Files()[0]})
+	data, err = json.Marshal(torrential.NewFile(&tor.Files()[0]))
 	assert.NoError(t, err)
 	assert.JSONEq(t, `{"displayPath":"sample.txt","length":20,"offset":0,"path":"sample.txt"}`, string(data))
 }
@@ -71,7 +71,9 @@ func TestEventTypeString(t *testing.T) {
 	assert.Equal(t, "downloadDone", torrential.DownloadDone.String())
 	assert.Equal(t, "seedingDone", torrential.SeedingDone.String())
 	assert.Equal(t, "closed", torrential.Closed.String())
-	assert.Equal(t, "unknown", torrential.EventType(7).String())
+	assert.Equal(t, "cacheLoaded", torrential.CacheLoaded.String())
+	assert.Equal(t, "serviceClosed", torrential.ServiceClosed.String())
+	assert.Equal(t, "unknown", torrential.EventType(9).String())
 }
 func TestEventTypeMarshalJSON(t *testing.T) {
 	actual, err := torrential.Added.MarshalJSON()
@@ -102,7 +104,36 @@ func TestEventTypeMarshalJSON(t *testing.T) {
 	assert.Equal(t, "\"closed\"", string(actual))
 	assert.NoError(t, err)
 
-	actual, err = torrential.EventType(7).MarshalJSON()
+	actual, err = torrential.CacheLoaded.MarshalJSON()
+	assert.Equal(t, "\"cacheLoaded\"", string(actual))
+	assert.NoError(t, err)
+
+	actual, err = torrential.EventType(9).MarshalJSON()
 	assert.Equal(t, "\"unknown\"", string(actual))
 	assert.NoError(t, err)
 }
+
+func TestEventTypeUnmarshalJSON(t *testing.T) {
+	var actual []torrential.EventType
+	err := json.Unmarshal([]byte(`["added","gotInfo","pieceDone","fileDone","downloadDone","seedingDone","closed"]`), &actual)
+	assert.NoError(t, err)
+	assert.Equal(t, []torrential.EventType{
+		torrential.Added,
+		torrential.GotInfo,
+		torrential.PieceDone,
+		torrential.FileDone,
+		torrential.DownloadDone,
+		torrential.SeedingDone,
+		torrential.Closed,
+	}, actual)
+
+	var et torrential.EventType
+	assert.Error(t, json.Unmarshal([]byte(`"unknown"`), &et))
+	assert.Error(t, json.Unmarshal([]byte(`1`), &et))
+}
+
+func TestResolveAddOptions(t *testing.T) {
+	opts := torrential.ResolveAddOptions(torrential.Labels("movies"), torrential.Labels("new", "hd"))
+	assert.Equal(t, []string{"movies", "new", "hd"}, opts.Labels)
+	assert.Empty(t, torrential.ResolveAddOptions().Labels)
+}
@@ -0,0 +1,548 @@
+package torrential
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultWebhookTimeout     = 10 * time.Second
+	defaultWebhookMaxAttempts = 5
+	defaultWebhookBackoff     = time.Second
+	defaultWebhookMaxBackoff  = 5 * time.Minute
+
+	// maxWebhookResponse is the number of bytes of a response body that are
+	// read to reuse the connection.
+	maxWebhookResponse = 64 * 1024
+
+	// webhookQuarantineDir is the subdirectory of a WebhookDirectory that
+	// outbox files that can't be decoded are moved to.
+	webhookQuarantineDir = "quarantine"
+)
+
+// WebhookDelivery is a single webhook request for a torrent event. Deliveries
+// stay in the outbox until they succeed, and are moved to the dead letter
+// store once they have failed the configured number of attempts.
+type WebhookDelivery struct {
+	ID          string            `json:"id"`
+	WebhookID   string            `json:"webhookId"`
+	URL         string            `json:"url"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	ContentType string            `json:"contentType"`
+	InfoHash    string            `json:"infoHash"`
+	EventType   string            `json:"eventType"`
+	Body        string            `json:"body"`
+	Created     time.Time         `json:"created"`
+	Attempts    int               `json:"attempts"`
+	LastAttempt time.Time         `json:"lastAttempt"`
+	LastError   string            `json:"lastError"`
+	Dead        bool              `json:"dead"`
+}
+
+// queueKey returns the key of the queue the delivery belongs to. Deliveries
+// are ordered per webhook and torrent, so that a failing webhook does not
+// hold up the others.
+func (d WebhookDelivery) queueKey() string {
+	return d.WebhookID + "/" + d.InfoHash
+}
+
+// WebhookStore persists webhook subscriptions and deliveries so that they
+// survive a restart.
+type WebhookStore interface {
+	SaveWebhook(Webhook) error
+	LoadWebhooks() ([]Webhook, error)
+	DeleteWebhook(id string) error
+
+	SaveDelivery(WebhookDelivery) error
+	LoadDeliveries() ([]WebhookDelivery, error)
+	DeleteDelivery(id string) error
+}
+
+// WebhookDirectory is a WebhookStore that keeps one JSON file per delivery,
+// and one JSON file per webhook subscription in the subscriptions
+// subdirectory.
+type WebhookDirectory struct {
+	Directory string
+}
+
+func NewWebhookDirectory(dir string) *WebhookDirectory {
+	return &WebhookDirectory{
+		Directory: dir,
+	}
+}
+
+func (s *WebhookDirectory) SaveWebhook(w Webhook) error {
+	return writeJSONFile(filepath.Join(s.Directory, "subscriptions"), w.ID, w)
+}
+
+func (s *WebhookDirectory) LoadWebhooks() ([]Webhook, error) {
+	var webhooks []Webhook
+	err := readJSONFiles(filepath.Join(s.Directory, "subscriptions"), func(name string, data []byte) error {
+		var w Webhook
+		if err := json.Unmarshal(data, &w); err != nil {
+			return errors.Wrapf(err, "could not decode webhook %s", name)
+		}
+		webhooks = append(webhooks, w)
+		return nil
+	})
+	return webhooks, err
+}
+
+func (s *WebhookDirectory) DeleteWebhook(id string) error {
+	return removeJSONFile(filepath.Join(s.Directory, "subscriptions"), id)
+}
+
+func (s *WebhookDirectory) SaveDelivery(d WebhookDelivery) error {
+	return writeJSONFile(s.Directory, d.ID, d)
+}
+
+// LoadDeliveries loads the deliveries of the outbox. Files that can't be
+// decoded are moved to the quarantine subdirectory, so that they don't keep
+// the service from starting.
+func (s *WebhookDirectory) LoadDeliveries() ([]WebhookDelivery, error) {
+	var deliveries []WebhookDelivery
+	err := readJSONFiles(s.Directory, func(name string, data []byte) error {
+		var d WebhookDelivery
+		if err := json.Unmarshal(data, &d); err != nil {
+			log.Printf("error decoding webhook delivery %s, quarantining it: %s", name, err)
+			if err := s.quarantine(name); err != nil {
+				log.Printf("error quarantining webhook delivery %s: %s", name, err)
+			}
+			return nil
+		}
+		deliveries = append(deliveries, d)
+		return nil
+	})
+	return deliveries, err
+}
+
+func (s *WebhookDirectory) quarantine(name string) error {
+	dir := filepath.Join(s.Directory, webhookQuarantineDir)
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return err
+	}
+	return os.Rename(filepath.Join(s.Directory, name), filepath.Join(dir, name))
+}
+
+func (s *WebhookDirectory) DeleteDelivery(id string) error {
+	return removeJSONFile(s.Directory, id)
+}
+
+// checkFileID returns an error if id can't safely be used as a file name.
+func checkFileID(id string) error {
+	if !webhookIDPattern.MatchString(id) {
+		return errors.Errorf("invalid ID %q", id)
+	}
+	return nil
+}
+
+func writeJSONFile(dir, id string, v interface{}) error {
+	if err := checkFileID(id); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return err
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so that a crash can never leave a
+	// partially written file behind.
+	filename := filepath.Join(dir, fmt.Sprintf("%s.json", id))
+	tmpFilename := filename + ".tmp"
+	if err := ioutil.WriteFile(tmpFilename, data, 0660); err != nil {
+		return err
+	}
+	return os.Rename(tmpFilename, filename)
+}
+
+func readJSONFiles(dir string, fn func(name string, data []byte) error) error {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return err
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if !strings.HasSuffix(e.Name(), ".json") || e.IsDir() {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return err
+		}
+		if err := fn(e.Name(), data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func removeJSONFile(dir, id string) error {
+	if err := checkFileID(id); err != nil {
+		return err
+	}
+	err := os.Remove(filepath.Join(dir, fmt.Sprintf("%s.json", id)))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// webhookDispatcher delivers webhooks in the background. Each webhook has a
+// queue per torrent, which is drained by a single goroutine so that events for
+// a torrent are always delivered in the order they happened. A failed delivery
+// is retried with exponential backoff before the next one in its queue is
+// attempted.
+type webhookDispatcher struct {
+	client      *http.Client
+	store       WebhookStore
+	secret      string
+	format      EventFormat
+	source      string
+	maxAttempts int
+	backoff     time.Duration
+	maxBackoff  time.Duration
+
+	hooks   map[string]*subscription
+	hooksMu sync.RWMutex
+
+	// queues has an entry for each queue that has a running delivery
+	// goroutine. The entry is removed by the goroutine when it exits.
+	queues map[string][]*WebhookDelivery
+	dead   map[string]*WebhookDelivery
+	mu     sync.Mutex
+
+	// done is closed by stop to interrupt retries that are waiting out
+	// their backoff.
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+func newWebhookDispatcher(conf *Config) (*webhookDispatcher, error) {
+	d := &webhookDispatcher{
+		client:      &http.Client{Timeout: conf.WebhookTimeout},
+		store:       conf.WebhookStore,
+		secret:      conf.WebhookSecret,
+		format:      conf.EventFormat,
+		source:      conf.EventSource,
+		maxAttempts: conf.WebhookMaxAttempts,
+		backoff:     conf.WebhookBackoff,
+		maxBackoff:  conf.WebhookMaxBackoff,
+		hooks:       make(map[string]*subscription),
+		queues:      make(map[string][]*WebhookDelivery),
+		dead:        make(map[string]*WebhookDelivery),
+		done:        make(chan struct{}),
+	}
+	if d.client.Timeout <= 0 {
+		d.client.Timeout = defaultWebhookTimeout
+	}
+	if d.maxAttempts <= 0 {
+		d.maxAttempts = defaultWebhookMaxAttempts
+	}
+	if d.backoff <= 0 {
+		d.backoff = defaultWebhookBackoff
+	}
+	if d.maxBackoff <= 0 {
+		d.maxBackoff = defaultWebhookMaxBackoff
+	}
+
+	if err := d.loadWebhooks(conf.Webhooks); err != nil {
+		return nil, err
+	}
+
+	if d.store != nil {
+		deliveries, err := d.store.LoadDeliveries()
+		if err != nil {
+			return nil, err
+		}
+		sort.Slice(deliveries, func(i, j int) bool {
+			return deliveries[i].Created.Before(deliveries[j].Created)
+		})
+		for i := range deliveries {
+			del := &deliveries[i]
+			if del.Dead {
+				d.dead[del.ID] = del
+				continue
+			}
+			d.queues[del.queueKey()] = append(d.queues[del.queueKey()], del)
+		}
+		d.mu.Lock()
+		keys := make([]string, 0, len(d.queues))
+		for key := range d.queues {
+			keys = append(keys, key)
+		}
+		d.mu.Unlock()
+		for _, key := range keys {
+			go d.run(key)
+		}
+	}
+	return d, nil
+}
+
+func (d *webhookDispatcher) enqueue(del *WebhookDelivery) error {
+	if d.store != nil {
+		if err := d.store.SaveDelivery(*del); err != nil {
+			return err
+		}
+	}
+
+	key := del.queueKey()
+	d.mu.Lock()
+	q, running := d.queues[key]
+	d.queues[key] = append(q, del)
+	d.mu.Unlock()
+
+	if !running {
+		go d.run(key)
+	}
+	return nil
+}
+
+// wait waits until every queue has been drained, or ctx is done. Deliveries
+// that are still queued stay in the store, if any.
+func (d *webhookDispatcher) wait(ctx context.Context) error {
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		d.mu.Lock()
+		pending := len(d.queues)
+		d.mu.Unlock()
+		if pending == 0 {
+			return nil
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// stop interrupts the delivery goroutines. Deliveries that haven't been
+// delivered yet stay in the store, if any, and aren't attempted again.
+func (d *webhookDispatcher) stop() {
+	d.stopOnce.Do(func() { close(d.done) })
+}
+
+func (d *webhookDispatcher) stopped() bool {
+	select {
+	case <-d.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// run drains the given queue, and returns once it is empty or the dispatcher
+// is stopped.
+func (d *webhookDispatcher) run(key string) {
+	for {
+		d.mu.Lock()
+		q := d.queues[key]
+		if len(q) == 0 || d.stopped() {
+			delete(d.queues, key)
+			d.mu.Unlock()
+			return
+		}
+		del := q[0]
+		d.mu.Unlock()
+
+		if !d.deliver(del) {
+			d.mu.Lock()
+			delete(d.queues, key)
+			d.mu.Unlock()
+			return
+		}
+
+		d.mu.Lock()
+		d.queues[key] = d.queues[key][1:]
+		d.mu.Unlock()
+	}
+}
+
+// deliver invokes the webhook until it succeeds or runs out of attempts, in
+// which case the delivery is moved to the dead letter store. It returns false
+// if the dispatcher was stopped while waiting to retry.
+func (d *webhookDispatcher) deliver(del *WebhookDelivery) bool {
+	for {
+		del.Attempts++
+		del.LastAttempt = time.Now()
+		err := invokeWebhook(d.client, del, d.secret)
+		if err == nil {
+			if d.store != nil {
+				if err := d.store.DeleteDelivery(del.ID); err != nil {
+					log.Printf("error removing webhook delivery %s from outbox: %s", del.ID, err)
+				}
+			}
+			return true
+		}
+
+		del.LastError = err.Error()
+		log.Printf("error invoking webhook %s for %s event for torrent %s (attempt %d of %d): %s", del.URL, del.EventType, del.InfoHash, del.Attempts, d.maxAttempts, err)
+
+		if del.Attempts >= d.maxAttempts {
+			del.Dead = true
+			d.save(del)
+
+			d.mu.Lock()
+			d.dead[del.ID] = del
+			d.mu.Unlock()
+			return true
+		}
+		d.save(del)
+
+		timer := time.NewTimer(d.backoffDuration(del.Attempts))
+		select {
+		case <-timer.C:
+		case <-d.done:
+			timer.Stop()
+			return false
+		}
+	}
+}
+
+func (d *webhookDispatcher) save(del *WebhookDelivery) {
+	if d.store == nil {
+		return
+	}
+	if err := d.store.SaveDelivery(*del); err != nil {
+		log.Printf("error saving webhook delivery %s: %s", del.ID, err)
+	}
+}
+
+// backoffDuration returns how long to wait after the given number of failed
+// attempts. The wait doubles after each attempt, up to the maximum backoff.
+func (d *webhookDispatcher) backoffDuration(attempts int) time.Duration {
+	wait := d.backoff
+	for i := 1; i < attempts; i++ {
+		wait *= 2
+		if wait >= d.maxBackoff {
+			return d.maxBackoff
+		}
+	}
+	return wait
+}
+
+func (d *webhookDispatcher) deadLetters() []WebhookDelivery {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	deliveries := make([]WebhookDelivery, 0, len(d.dead))
+	for _, del := range d.dead {
+		deliveries = append(deliveries, *del)
+	}
+	sort.Slice(deliveries, func(i, j int) bool {
+		return deliveries[i].Created.Before(deliveries[j].Created)
+	})
+	return deliveries
+}
+
+func (d *webhookDispatcher) deadLetter(id string) (*WebhookDelivery, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	del, ok := d.dead[id]
+	if !ok {
+		return nil, notFoundErr{errors.New("dead letter not found")}
+	}
+	copied := *del
+	return &copied, nil
+}
+
+// replay moves a dead letter back to the end of its torrent's outbox queue
+// with a fresh set of attempts.
+func (d *webhookDispatcher) replay(id string) (*WebhookDelivery, error) {
+	d.mu.Lock()
+	del, ok := d.dead[id]
+	if ok {
+		delete(d.dead, id)
+	}
+	d.mu.Unlock()
+	if !ok {
+		return nil, notFoundErr{errors.New("dead letter not found")}
+	}
+
+	del.Dead = false
+	del.Attempts = 0
+	del.LastError = ""
+	replayed := *del
+	if err := d.enqueue(del); err != nil {
+		d.mu.Lock()
+		del.Dead = true
+		d.dead[id] = del
+		d.mu.Unlock()
+		return nil, errors.Wrap(webhookErr{err}, "could not queue webhook delivery")
+	}
+	return &replayed, nil
+}
+
+func (d *webhookDispatcher) deleteDeadLetter(id string) error {
+	d.mu.Lock()
+	_, ok := d.dead[id]
+	if ok {
+		delete(d.dead, id)
+	}
+	d.mu.Unlock()
+	if !ok {
+		return notFoundErr{errors.New("dead letter not found")}
+	}
+	if d.store != nil {
+		if err := d.store.DeleteDelivery(id); err != nil {
+			return errors.Wrap(webhookErr{err}, "could not delete dead letter")
+		}
+	}
+	return nil
+}
+
+func invokeWebhook(client *http.Client, del *WebhookDelivery, secret string) error {
+	req, err := http.NewRequest(http.MethodPost, del.URL, strings.NewReader(del.Body))
+	if err != nil {
+		return err
+	}
+	for k, v := range del.Headers {
+		req.Header.Set(k, v)
+	}
+	contentType := del.ContentType
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set(WebhookDeliveryHeader, del.ID)
+	if secret != "" {
+		now := time.Now()
+		req.Header.Set(WebhookTimestampHeader, strconv.FormatInt(now.Unix(), 10))
+		req.Header.Set(WebhookSignatureHeader, SignWebhook(secret, now, []byte(del.Body)))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// Drain the body so that the connection can be reused, unless it is
+	// too large to be worth it.
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, maxWebhookResponse))
+
+	// Redirects that the client doesn't follow, like 304, aren't deliveries.
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errors.New(resp.Status)
+	}
+	return nil
+}
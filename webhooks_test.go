@@ -0,0 +1,244 @@
+package torrential_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	"github.com/joelanford/torrential"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebhookDirectory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "torrential-webhooks")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	store := torrential.NewWebhookDirectory(dir)
+
+	deliveries, err := store.LoadDeliveries()
+	assert.NoError(t, err)
+	assert.Empty(t, deliveries)
+
+	d := torrential.WebhookDelivery{
+		ID:        "delivery-1",
+		URL:       "http://localhost/webhook",
+		InfoHash:  "d0d14c926e6e99761a2fdcff27b403d96376eff6",
+		EventType: "added",
+		Body:      `{"event":{}}`,
+		Created:   time.Unix(1500000000, 0).UTC(),
+	}
+	assert.NoError(t, store.SaveDelivery(d))
+
+	d.Attempts = 5
+	d.Dead = true
+	assert.NoError(t, store.SaveDelivery(d))
+
+	// Files that can't be decoded are quarantined instead of failing the
+	// whole outbox.
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "bad.json"), []byte("{"), 0660))
+	deliveries, err = store.LoadDeliveries()
+	assert.NoError(t, err)
+	assert.Equal(t, []torrential.WebhookDelivery{d}, deliveries)
+	_, err = os.Stat(filepath.Join(dir, "quarantine", "bad.json"))
+	assert.NoError(t, err)
+
+	assert.NoError(t, store.DeleteDelivery(d.ID))
+	assert.NoError(t, store.DeleteDelivery(d.ID))
+
+	deliveries, err = store.LoadDeliveries()
+	assert.NoError(t, err)
+	assert.Empty(t, deliveries)
+}
+
+func TestWebhookSubscriptions(t *testing.T) {
+	bodies := make(chan string, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies <- r.URL.Path + " " + string(body)
+	}))
+	defer receiver.Close()
+
+	f, err := torrential.NewFakeService()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	_, err = f.AddWebhook(torrential.Webhook{
+		ID:         "movies",
+		URL:        receiver.URL + "/movies",
+		EventTypes: []torrential.EventType{torrential.DownloadDone},
+		Labels:     []string{"movies"},
+		Template:   `{{.Type}} {{json .Torrent.Name}} {{json .Labels}}`,
+	})
+	assert.NoError(t, err)
+	_, err = f.AddWebhook(torrential.Webhook{
+		ID:     "music",
+		URL:    receiver.URL + "/music",
+		Labels: []string{"music"},
+	})
+	assert.NoError(t, err)
+
+	file, err := os.Open("testdata/sample.torrent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	tor, err := f.AddTorrentReaderContext(context.Background(), file, torrential.Labels("movies", "new"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the event type and labels of the second event match a webhook.
+	f.Send(torrential.Event{Type: torrential.Added, Torrent: *tor})
+	f.Send(torrential.Event{Type: torrential.DownloadDone, Torrent: *tor})
+	select {
+	case body := <-bodies:
+		assert.Equal(t, `/movies downloadDone "sample.txt" ["movies","new"]`, body)
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook was not invoked")
+	}
+	select {
+	case body := <-bodies:
+		t.Errorf("unexpected webhook request %s", body)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// webhookReceiver records the times of its requests, and fails them with 503
+// Service Unavailable until ok is set.
+type webhookReceiver struct {
+	mu       sync.Mutex
+	ok       bool
+	attempts []time.Time
+}
+
+func (rcv *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	rcv.attempts = append(rcv.attempts, time.Now())
+	if !rcv.ok {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}
+}
+
+func (rcv *webhookReceiver) setOK(ok bool) {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	rcv.ok = ok
+}
+
+func (rcv *webhookReceiver) numAttempts() int {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	return len(rcv.attempts)
+}
+
+func newWebhookService(t *testing.T, url string, maxAttempts int, backoff time.Duration) *torrential.Service {
+	svc, err := torrential.NewService(&torrential.Config{
+		ClientConfig: &torrent.Config{
+			ListenAddr:      "localhost:0",
+			NoDHT:           true,
+			DisableTrackers: true,
+		},
+		MemoryStorage: true,
+		Webhooks: []torrential.Webhook{
+			{URL: url, EventTypes: []torrential.EventType{torrential.Added}},
+		},
+		WebhookMaxAttempts: maxAttempts,
+		WebhookBackoff:     backoff,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open("testdata/sample.torrent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if _, err := svc.AddTorrentReader(file); err != nil {
+		t.Fatal(err)
+	}
+	return svc
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+	rcv := &webhookReceiver{}
+	receiver := httptest.NewServer(rcv)
+	defer receiver.Close()
+
+	svc := newWebhookService(t, receiver.URL, 5, 50*time.Millisecond)
+	defer svc.Close(context.Background())
+
+	waitFor(t, "two failed attempts", func() bool { return rcv.numAttempts() >= 2 })
+	rcv.setOK(true)
+	waitFor(t, "the third attempt", func() bool { return rcv.numAttempts() >= 3 })
+	time.Sleep(250 * time.Millisecond)
+
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	if assert.Len(t, rcv.attempts, 3) {
+		// The backoff doubles after each failed attempt.
+		assert.True(t, rcv.attempts[1].Sub(rcv.attempts[0]) >= 50*time.Millisecond)
+		assert.True(t, rcv.attempts[2].Sub(rcv.attempts[1]) >= 100*time.Millisecond)
+	}
+	assert.Empty(t, svc.DeadLetters())
+}
+
+func TestWebhookDeadLetters(t *testing.T) {
+	rcv := &webhookReceiver{}
+	receiver := httptest.NewServer(rcv)
+	defer receiver.Close()
+
+	svc := newWebhookService(t, receiver.URL, 2, 10*time.Millisecond)
+	defer svc.Close(context.Background())
+
+	waitFor(t, "a dead letter", func() bool { return len(svc.DeadLetters()) == 1 })
+	dead := svc.DeadLetters()[0]
+	assert.True(t, dead.Dead)
+	assert.Equal(t, 2, dead.Attempts)
+	assert.Contains(t, dead.LastError, "503")
+	assert.Equal(t, 2, rcv.numAttempts())
+
+	rcv.setOK(true)
+	replayed, err := svc.ReplayDeadLetter(dead.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, dead.ID, replayed.ID)
+	assert.False(t, replayed.Dead)
+	waitFor(t, "the replayed delivery", func() bool { return rcv.numAttempts() == 3 })
+	assert.Empty(t, svc.DeadLetters())
+
+	_, err = svc.ReplayDeadLetter(dead.ID)
+	assert.Error(t, err)
+}
+
+func TestWebhookCloseInterruptsBackoff(t *testing.T) {
+	rcv := &webhookReceiver{}
+	receiver := httptest.NewServer(rcv)
+	defer receiver.Close()
+
+	svc := newWebhookService(t, receiver.URL, 5, time.Hour)
+	waitFor(t, "the first attempt", func() bool { return rcv.numAttempts() == 1 })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	assert.Error(t, svc.Close(ctx))
+	assert.Equal(t, 1, rcv.numAttempts())
+}
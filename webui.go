@@ -0,0 +1,293 @@
+package torrential
+
+// webUI is the single-page web UI served under /ui/. It only uses the REST
+// API and the websocket event stream, which it finds relative to its own URL,
+// so it works under any base path. With token auth, it is opened as
+// /ui/?access_token=<token>.
+const webUI = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>torrential</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; margin: 0; color: #222; background: #f6f7f9; }
+header { background: #24292e; color: #fff; padding: 12px 24px; display: flex; align-items: center; justify-content: space-between; }
+header h1 { font-size: 20px; margin: 0; }
+#connection { font-size: 13px; color: #ccc; }
+main { padding: 24px; max-width: 1100px; margin: 0 auto; }
+section { background: #fff; border: 1px solid #e1e4e8; border-radius: 6px; padding: 16px; margin-bottom: 24px; }
+h2 { font-size: 16px; margin: 0 0 12px; }
+form { display: flex; flex-wrap: wrap; gap: 8px; align-items: center; margin-bottom: 8px; }
+input[type=text] { flex: 1; min-width: 240px; padding: 6px 8px; border: 1px solid #d1d5da; border-radius: 4px; }
+button { padding: 6px 12px; border: 1px solid #d1d5da; border-radius: 4px; background: #fafbfc; cursor: pointer; }
+button.danger { color: #cb2431; }
+table { width: 100%; border-collapse: collapse; font-size: 14px; }
+th, td { text-align: left; padding: 8px; border-bottom: 1px solid #eaecef; }
+th { font-weight: 600; color: #586069; }
+td.name { word-break: break-all; }
+.bar { width: 140px; height: 10px; background: #eaecef; border-radius: 5px; overflow: hidden; display: inline-block; vertical-align: middle; }
+.bar div { height: 100%; background: #2ea44f; }
+.pct { font-size: 12px; color: #586069; margin-left: 6px; }
+#message { font-size: 13px; min-height: 18px; }
+#message.error { color: #cb2431; }
+#empty { color: #586069; }
+</style>
+</head>
+<body>
+<header>
+  <h1>torrential</h1>
+  <span id="connection">connecting…</span>
+</header>
+<main>
+  <section>
+    <h2>Add torrents</h2>
+    <form id="add-link">
+      <input type="text" id="link" placeholder="Torrent URL or magnet link">
+      <input type="text" id="labels" placeholder="Labels (comma-separated)" style="flex: 0 1 220px; min-width: 160px">
+      <button type="submit">Add</button>
+    </form>
+    <form id="add-file">
+      <input type="file" id="file" accept=".torrent,application/x-bittorrent" multiple>
+      <button type="submit">Upload</button>
+    </form>
+    <div id="message"></div>
+  </section>
+  <section>
+    <h2>Torrents</h2>
+    <table>
+      <thead><tr><th>Name</th><th>Progress</th><th>Size</th><th>Peers</th><th>State</th><th></th></tr></thead>
+      <tbody id="torrents"></tbody>
+    </table>
+    <p id="empty">No torrents.</p>
+  </section>
+</main>
+<script>
+(function() {
+  "use strict";
+
+  var api = new URL("../", window.location.href);
+  var torrents = {};
+
+  // A token can be given by opening the UI as /ui/?access_token=<token>. It
+  // is sent in the Authorization header of API requests, and removed from the
+  // address bar so that it doesn't stay in the browser history.
+  var page = new URL(window.location.href);
+  var token = page.searchParams.get("access_token") || sessionStorage.getItem("access_token");
+  if (page.searchParams.has("access_token")) {
+    sessionStorage.setItem("access_token", token);
+    page.searchParams.delete("access_token");
+    history.replaceState(null, "", page.toString());
+  }
+
+  function apiURL(path, query) {
+    var u = new URL(path, api);
+    (query || []).forEach(function(kv) { u.searchParams.append(kv[0], kv[1]); });
+    return u.toString();
+  }
+
+  function request(method, path, query, contentType, body) {
+    var opts = { method: method, headers: { "Accept": "application/json" }, body: body };
+    if (token) {
+      opts.headers["Authorization"] = "Bearer " + token;
+    }
+    if (contentType) {
+      opts.headers["Content-Type"] = contentType;
+    }
+    return fetch(apiURL(path, query), opts).then(function(resp) {
+      return resp.text().then(function(text) {
+        var data = {};
+        try { data = JSON.parse(text); } catch (e) { data = { error: text }; }
+        if (!resp.ok) {
+          throw new Error(data.error || resp.statusText);
+        }
+        return data;
+      });
+    });
+  }
+
+  function showMessage(text, isError) {
+    var el = document.getElementById("message");
+    el.textContent = text;
+    el.className = isError ? "error" : "";
+  }
+
+  function formatBytes(n) {
+    var units = ["B", "KiB", "MiB", "GiB", "TiB"];
+    var i = 0;
+    while (n >= 1024 && i < units.length - 1) {
+      n /= 1024;
+      i++;
+    }
+    return (i === 0 ? n : n.toFixed(1)) + " " + units[i];
+  }
+
+  function state(t) {
+    if (t.paused) { return "paused"; }
+    if (!t.hasInfo) { return "getting info"; }
+    if (t.seeding) { return "seeding"; }
+    if (t.bytesMissing === 0) { return "done"; }
+    return "downloading";
+  }
+
+  function cell(row, content, className) {
+    var td = document.createElement("td");
+    if (className) { td.className = className; }
+    if (typeof content === "string") {
+      td.textContent = content;
+    } else {
+      td.appendChild(content);
+    }
+    row.appendChild(td);
+    return td;
+  }
+
+  function button(label, className, onClick) {
+    var b = document.createElement("button");
+    b.textContent = label;
+    if (className) { b.className = className; }
+    b.addEventListener("click", onClick);
+    return b;
+  }
+
+  function render() {
+    var body = document.getElementById("torrents");
+    while (body.firstChild) { body.removeChild(body.firstChild); }
+    var list = Object.keys(torrents).map(function(k) { return torrents[k]; });
+    list.sort(function(a, b) { return (a.name || a.infoHash).localeCompare(b.name || b.infoHash); });
+    document.getElementById("empty").style.display = list.length ? "none" : "";
+
+    list.forEach(function(t) {
+      var row = document.createElement("tr");
+      cell(row, t.name || t.infoHash, "name");
+
+      var pct = t.hasInfo && t.length > 0 ? 100 * t.bytesCompleted / t.length : 0;
+      var progress = document.createElement("span");
+      var bar = document.createElement("span");
+      bar.className = "bar";
+      var fill = document.createElement("div");
+      fill.style.width = pct.toFixed(1) + "%";
+      bar.appendChild(fill);
+      var label = document.createElement("span");
+      label.className = "pct";
+      label.textContent = t.hasInfo ? pct.toFixed(1) + "%" : "-";
+      progress.appendChild(bar);
+      progress.appendChild(label);
+      cell(row, progress);
+
+      cell(row, t.hasInfo ? formatBytes(t.length) : "-");
+      cell(row, String(t.stats ? t.stats.activePeers : 0));
+      cell(row, state(t));
+
+      var actions = document.createElement("span");
+      actions.appendChild(button("Drop", "danger", function() { drop(t, false); }));
+      actions.appendChild(document.createTextNode(" "));
+      actions.appendChild(button("Drop and delete files", "danger", function() { drop(t, true); }));
+      cell(row, actions);
+      body.appendChild(row);
+    });
+  }
+
+  function load() {
+    return request("GET", "torrents").then(function(data) {
+      torrents = {};
+      (data.torrents || []).forEach(function(t) { torrents[t.infoHash] = t; });
+      render();
+    }).catch(function(err) { showMessage(err.message, true); });
+  }
+
+  function added(data) {
+    torrents[data.torrent.infoHash] = data.torrent;
+    render();
+    showMessage("Added " + (data.torrent.name || data.torrent.infoHash), false);
+  }
+
+  function labelQuery() {
+    return document.getElementById("labels").value.split(",").map(function(l) {
+      return l.trim();
+    }).filter(function(l) { return l; }).map(function(l) { return ["label", l]; });
+  }
+
+  function drop(t, deleteFiles) {
+    var name = t.name || t.infoHash;
+    var question = deleteFiles ? "Drop " + name + " and delete its files?" : "Drop " + name + "?";
+    if (!window.confirm(question)) { return; }
+    var query = deleteFiles ? [["deleteFiles", "true"]] : [];
+    request("DELETE", "torrents/" + t.infoHash, query).then(function() {
+      delete torrents[t.infoHash];
+      render();
+      showMessage("Dropped " + name, false);
+    }).catch(function(err) { showMessage(err.message, true); });
+  }
+
+  document.getElementById("add-link").addEventListener("submit", function(ev) {
+    ev.preventDefault();
+    var input = document.getElementById("link");
+    var link = input.value.trim();
+    if (!link) { return; }
+    var contentType = link.indexOf("magnet:") === 0 ? "x-scheme-handler/magnet" : "application/x-url";
+    request("POST", "torrents", labelQuery(), contentType, link).then(function(data) {
+      input.value = "";
+      added(data);
+    }).catch(function(err) { showMessage(err.message, true); });
+  });
+
+  document.getElementById("add-file").addEventListener("submit", function(ev) {
+    ev.preventDefault();
+    var input = document.getElementById("file");
+    Array.prototype.forEach.call(input.files, function(file) {
+      request("POST", "torrents", labelQuery(), "application/x-bittorrent", file).then(added).catch(function(err) {
+        showMessage(file.name + ": " + err.message, true);
+      });
+    });
+    input.value = "";
+  });
+
+  // Torrents are updated from the events stream, and reloaded when a torrent
+  // is added or closed. The stream reconnects with a growing delay.
+  var delay = 1000;
+  function connect() {
+    var u = new URL(apiURL("torrents/events", [["format", "json"]]));
+    u.protocol = u.protocol === "https:" ? "wss:" : "ws:";
+    if (token) {
+      // Browsers can't set headers on websockets.
+      u.searchParams.set("access_token", token);
+    }
+    var ws = new WebSocket(u.toString());
+    var status = document.getElementById("connection");
+    ws.onopen = function() {
+      delay = 1000;
+      status.textContent = "live";
+      load();
+    };
+    ws.onmessage = function(msg) {
+      var e;
+      try { e = JSON.parse(msg.data).event; } catch (err) { return; }
+      if (!e || !e.torrent) { return; }
+      if (e.type === "added" || e.type === "closed") {
+        load();
+        return;
+      }
+      var old = torrents[e.torrent.infoHash];
+      if (old) {
+        // The torrents of events don't carry the download state.
+        e.torrent.paused = old.paused;
+        e.torrent.priority = old.priority;
+      }
+      torrents[e.torrent.infoHash] = e.torrent;
+      render();
+    };
+    ws.onclose = function() {
+      status.textContent = "disconnected, reconnecting…";
+      setTimeout(connect, delay);
+      delay = Math.min(delay * 2, 30000);
+    };
+  }
+
+  load();
+  connect();
+})();
+</script>
+</body>
+</html>
+`